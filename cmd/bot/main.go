@@ -12,10 +12,25 @@ import (
 
 	"example-tool-call/internal/config"
 	"example-tool-call/internal/handlers"
+	"example-tool-call/internal/services/cache"
 	"example-tool-call/internal/services/database"
 	"example-tool-call/internal/services/fonnte"
+	"example-tool-call/internal/services/format"
+	"example-tool-call/internal/services/guard"
+	"example-tool-call/internal/services/handoff"
+	"example-tool-call/internal/services/media"
+	"example-tool-call/internal/services/messenger"
+	"example-tool-call/internal/services/meta"
+	"example-tool-call/internal/services/moderation"
 	"example-tool-call/internal/services/openai"
+	"example-tool-call/internal/services/outbox"
+	"example-tool-call/internal/services/persona"
+	"example-tool-call/internal/services/queue"
+	"example-tool-call/internal/services/router"
 	"example-tool-call/internal/services/tools"
+	"example-tool-call/internal/services/voice"
+	"example-tool-call/internal/services/webhookauth"
+	"example-tool-call/internal/services/whatsapp"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -49,20 +64,122 @@ func main() {
 	logger.Info("Database connected successfully")
 
 	// Initialize services
-	fontteService := fonnte.New(cfg.Fonnte.APIKey, logger)
-	openaiService := openai.New(cfg.OpenAI.APIKey, cfg.OpenAI.BaseURL, cfg.OpenAI.Model, cfg.OpenAI.MaxTokens, logger)
+	gateway, err := newMessenger(cfg, db, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize WhatsApp gateway")
+	}
+
+	// Replies are delivered in the background through the gateway
+	messageOutbox := outbox.New(cfg.Outbox, gateway, format.New(cfg.Format), db, logger)
+	openaiService, err := openai.New(cfg.OpenAI, db, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize LLM service")
+	}
 	
 	// Initialize tool manager
 	toolManager := tools.NewManager(db, logger)
 	
 	// Register image generation tool
-	imageGenTool := tools.NewImageGenerationTool(cfg.Image.APIKey, logger)
-	toolManager.RegisterTool(imageGenTool)
+	if cfg.OpenAI.Provider == openai.ScriptedProvider {
+		// Keep the scripted setup fully offline
+		toolManager.RegisterTool(tools.NewStubImageGenerationTool())
+	} else {
+		imageGenTool := tools.NewImageGenerationTool(cfg.Image.APIKey, logger)
+		toolManager.RegisterTool(imageGenTool)
+	}
+
+	// Let the model hand conversations over to staff
+	if cfg.Handoff.Enabled && cfg.Handoff.Tool {
+		toolManager.RegisterTool(tools.NewEscalationTool())
+	}
+
+	// Initialize personas
+	personaService, err := persona.New(cfg.Persona.File, cfg.Persona.SystemPrompt, cfg.Persona.Timezone, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load personas")
+	}
+	if err := personaService.Watch(); err != nil {
+		logger.WithError(err).Warn("Persona hot-reload disabled")
+	}
+	defer personaService.Close()
+
+	// Initialize model router
+	modelRouter, err := router.New(cfg.Router, cfg.OpenAI.Model, openaiService, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize model router")
+	}
+
+	// Initialize moderation
+	moderationService, err := moderation.New(cfg.Moderation, openaiService, messageOutbox, db, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize moderation")
+	}
+
+	// Initialize prompt injection guard
+	guardService := guard.New(cfg.Guard, db, logger)
+
+	// Initialize response cache
+	responseCache := cache.New(cfg.Cache, openaiService, db, logger)
+
+	// Initialize media store
+	var mediaService *media.Service
+	if cfg.Media.Enabled {
+		mediaService, err = media.New(cfg.Media, cfg.Server.PublicURL, db, logger)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to initialize media store")
+		}
+	}
+
+	// Initialize voice message transcription and speech
+	var voiceService *voice.Service
+	if cfg.Voice.Enabled {
+		voiceService = voice.New(cfg.Voice, mediaService, logger)
+	}
+
+	// Initialize human handoff
+	var handoffService *handoff.Service
+	if cfg.Handoff.Enabled {
+		handoffService = handoff.New(cfg.Handoff, openaiService, messageOutbox, db, logger)
+	}
+
+	// Initialize webhook authentication
+	webhookAuth, err := webhookauth.New(cfg.Webhook, db, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize webhook authentication")
+	}
+
+	// Initialize inbound message queue
+	messageQueue := queue.New(cfg.Queue, db, logger)
 
 	logger.Info("Services initialized successfully")
 
 	// Initialize handlers
-	handler := handlers.NewHandler(db, fontteService, openaiService, toolManager, logger)
+	handler := handlers.NewHandler(handlers.Deps{
+		DB:          db,
+		Messenger:   gateway,
+		Outbox:      messageOutbox,
+		OpenAI:      openaiService,
+		Tools:       toolManager,
+		Personas:    personaService,
+		Router:      modelRouter,
+		Streaming:   cfg.Streaming,
+		Moderation:  moderationService,
+		Guard:       guardService,
+		Language:    cfg.Language,
+		Groups:      cfg.Group,
+		Cache:       responseCache,
+		Voice:       voiceService,
+		Media:       mediaService,
+		Handoff:     handoffService,
+		WebhookAuth: webhookAuth,
+		Queue:       messageQueue,
+		StatsToken:  cfg.Server.StatsToken,
+		Logger:      logger,
+	})
+
+	// Start processing queued messages and sending replies
+	messageOutbox.Start()
+	messageQueue.Start(handler.ProcessMessage)
 
 	// Setup HTTP server
 	if cfg.Server.Host == "0.0.0.0" {
@@ -70,6 +187,10 @@ func main() {
 	}
 
 	router := gin.New()
+	// Only listed proxies may set the client IP used by the webhook allowlist
+	if err := router.SetTrustedProxies(cfg.Webhook.TrustedProxies); err != nil {
+		logger.WithError(err).Fatal("Invalid trusted proxies")
+	}
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 
@@ -78,7 +199,23 @@ func main() {
 	router.GET("/stats", handler.Stats)
 
 	// Webhook endpoints
-	router.POST("/webhook/fonnte", handler.FontteWebhook)
+	router.POST("/webhook/"+gateway.Name(), webhookAuth.Middleware(), handler.Webhook)
+	router.GET("/webhook/"+gateway.Name(), handler.VerifyWebhook)
+	router.POST("/webhook/"+gateway.Name()+"/status", webhookAuth.Middleware(), handler.StatusWebhook)
+
+	// Stored media, fetched by the gateway through signed URLs
+	if mediaService.Enabled() {
+		router.GET(media.Path+"/:id/:name", mediaService.Serve)
+	}
+
+	// Admin API for taking over conversations
+	if handoffService.AdminEnabled() {
+		admin := router.Group("/admin", handoffService.Middleware())
+		admin.GET("/handoffs", handler.ListHandoffs)
+		admin.POST("/conversations/:jid/handoff", handler.StartHandoff)
+		admin.DELETE("/conversations/:jid/handoff", handler.EndHandoff)
+		admin.POST("/conversations/:jid/messages", handler.SendOperatorMessage)
+	}
 
 	// Start server
 	server := &http.Server{
@@ -98,6 +235,30 @@ func main() {
 		}
 	}()
 
+	// Gateways with their own connection report receipts directly
+	if notifier, ok := gateway.(messenger.StatusNotifier); ok {
+		notifier.OnStatus(handler.RecordStatus)
+	}
+
+	// Gateways with their own connection deliver messages directly
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	listening := make(chan struct{})
+	if listener, ok := gateway.(messenger.Listener); ok {
+		go func() {
+			defer close(listening)
+			enqueue := func(msg messenger.Inbound) {
+				if err := messageQueue.Enqueue(msg); err != nil {
+					logger.WithError(err).WithField("sender", msg.Sender).Error("Failed to queue message")
+				}
+			}
+			if err := listener.Listen(listenCtx, enqueue); err != nil {
+				logger.WithError(err).Fatal("WhatsApp connection failed")
+			}
+		}()
+	} else {
+		close(listening)
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -105,6 +266,9 @@ func main() {
 
 	logger.Info("Shutting down server...")
 
+	stopListening()
+	<-listening
+
 	// Give outstanding requests 30 seconds to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -113,5 +277,39 @@ func main() {
 		logger.WithError(err).Fatal("Server forced to shutdown")
 	}
 
+	// Let workers finish the messages they already claimed
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Queue.DrainTimeout)
+	defer cancelDrain()
+
+	if err := messageQueue.Shutdown(drainCtx); err != nil {
+		logger.WithError(err).Warn("Message queue did not drain in time")
+	}
+
+	// Then stop sending. The outbox has its own deadline, so a queue drain
+	// that used up QUEUE_DRAIN_TIMEOUT still leaves it time; unsent replies
+	// are delivered after the restart.
+	outboxCtx, cancelOutbox := context.WithTimeout(context.Background(), cfg.Outbox.DrainTimeout)
+	defer cancelOutbox()
+
+	if err := messageOutbox.Shutdown(outboxCtx); err != nil {
+		logger.WithError(err).Warn("Outbox did not drain in time")
+	}
+
 	logger.Info("Server exited")
-}
\ No newline at end of file
+}
+
+// newMessenger creates the WhatsApp gateway selected by WHATSAPP_GATEWAY
+func newMessenger(cfg *config.Config, db *database.DB, logger *logrus.Logger) (messenger.Messenger, error) {
+	switch cfg.WhatsApp.Gateway {
+	case messenger.GatewayMeta:
+		return meta.New(cfg.Meta, logger), nil
+	case messenger.GatewayWhatsmeow:
+		service, err := whatsapp.New(cfg.WhatsApp, db, logger)
+		if err != nil {
+			return nil, err
+		}
+		return service, nil
+	default:
+		return fonnte.New(cfg.Fonnte, logger), nil
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/eval"
+	"example-tool-call/internal/services/database"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	var (
+		scenarioPaths  = flag.String("scenarios", "", "comma separated scenario files or directories")
+		replay         = flag.String("replay", "", "comma separated sender numbers whose stored conversations are replayed")
+		replayLimit    = flag.Int("replay-limit", 50, "number of stored messages to replay per conversation")
+		model          = flag.String("model", "", "model to evaluate (default: OPENAI_MODEL)")
+		personaFile    = flag.String("persona-file", "", "personas file to evaluate (default: PERSONA_FILE)")
+		systemPrompt   = flag.String("system-prompt", "", "default system prompt to evaluate")
+		compareModel   = flag.String("compare-model", "", "model of a second configuration to compare against")
+		comparePersona = flag.String("compare-persona-file", "", "personas file of a second configuration")
+		comparePrompt  = flag.String("compare-system-prompt", "", "default system prompt of a second configuration")
+		judgeModel     = flag.String("judge-model", "", "model grading judge rubrics (default: OPENAI_MODEL)")
+		liveTools      = flag.Bool("live-tools", false, "execute real tools instead of stubs")
+		out            = flag.String("out", "eval-report.md", "file the Markdown report is written to (- for stdout)")
+		verbose        = flag.Bool("v", false, "log pipeline activity")
+	)
+	flag.Parse()
+
+	cfg, err := config.LoadOffline()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	scenarios, err := loadScenarios(cfg, *scenarioPaths, *replay, *replayLimit)
+	if err != nil {
+		log.Fatalf("Failed to load scenarios: %v", err)
+	}
+	if len(scenarios) == 0 {
+		log.Fatal("No scenarios to run; pass -scenarios or -replay")
+	}
+
+	variants := []eval.Variant{{Name: "A", Model: *model, PersonaFile: *personaFile, SystemPrompt: *systemPrompt}}
+	if *compareModel != "" || *comparePersona != "" || *comparePrompt != "" {
+		variants = append(variants, eval.Variant{
+			Name:         "B",
+			Model:        firstNonEmpty(*compareModel, *model),
+			PersonaFile:  firstNonEmpty(*comparePersona, *personaFile),
+			SystemPrompt: firstNonEmpty(*comparePrompt, *systemPrompt),
+		})
+	}
+
+	runner, err := eval.NewRunner(cfg, *judgeModel, *liveTools, logger)
+	if err != nil {
+		log.Fatalf("Failed to initialize evaluation: %v", err)
+	}
+	ctx := context.Background()
+
+	var results []*eval.Result
+	for _, variant := range variants {
+		fmt.Fprintf(os.Stderr, "Running %d scenario(s) with variant %s\n", len(scenarios), variant.Name)
+		result, err := runner.Run(ctx, variant, scenarios)
+		if err != nil {
+			log.Fatalf("Evaluation failed: %v", err)
+		}
+		passed, total := result.Counts()
+		fmt.Fprintf(os.Stderr, "Variant %s: %d/%d checks passed\n", variant.Name, passed, total)
+		results = append(results, result)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create report: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := eval.WriteReport(w, results...); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+
+	// Fail CI when the last evaluated configuration misses a check
+	if passed, total := results[len(results)-1].Counts(); passed < total {
+		os.Exit(1)
+	}
+}
+
+func loadScenarios(cfg *config.Config, paths, replay string, replayLimit int) ([]eval.Scenario, error) {
+	var scenarios []eval.Scenario
+	if paths != "" {
+		loaded, err := eval.LoadScenarios(splitList(paths)...)
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, loaded...)
+	}
+
+	if replay != "" {
+		db, err := database.New(cfg.Database.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		for _, jid := range splitList(replay) {
+			scenario, err := eval.ReplayConversation(db, jid, replayLimit)
+			if err != nil {
+				return nil, err
+			}
+			scenarios = append(scenarios, scenario)
+		}
+	}
+	return scenarios, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
@@ -17,6 +19,9 @@ type Config struct {
 	
 	// Fonnte Configuration
 	Fonnte FontteConfig `mapstructure:"fonnte"`
+
+	// Meta WhatsApp Cloud API Configuration
+	Meta MetaConfig `mapstructure:"meta"`
 	
 	// OpenAI Configuration
 	OpenAI OpenAIConfig `mapstructure:"openai"`
@@ -26,28 +31,112 @@ type Config struct {
 	
 	// Database Configuration
 	Database DatabaseConfig `mapstructure:"database"`
+
+	// Persona Configuration
+	Persona PersonaConfig `mapstructure:"persona"`
+
+	// Model Routing Configuration
+	Router RouterConfig `mapstructure:"router"`
+
+	// Streaming Configuration
+	Streaming StreamingConfig `mapstructure:"streaming"`
+
+	// Moderation Configuration
+	Moderation ModerationConfig `mapstructure:"moderation"`
+
+	// Prompt Injection Guard Configuration
+	Guard GuardConfig `mapstructure:"guard"`
+
+	// Language Configuration
+	Language LanguageConfig `mapstructure:"language"`
+
+	// Response Cache Configuration
+	Cache CacheConfig `mapstructure:"cache"`
+
+	// Webhook Authentication Configuration
+	Webhook WebhookConfig `mapstructure:"webhook"`
+
+	// Inbound Queue Configuration
+	Queue QueueConfig `mapstructure:"queue"`
+
+	// Outbound Message Configuration
+	Outbox OutboxConfig `mapstructure:"outbox"`
+
+	// Reply Formatting Configuration
+	Format FormatConfig `mapstructure:"format"`
+
+	// Voice Message Configuration
+	Voice VoiceConfig `mapstructure:"voice"`
+
+	// Media Storage Configuration
+	Media MediaConfig `mapstructure:"media"`
+
+	// Group Chat Configuration
+	Group GroupConfig `mapstructure:"group"`
+
+	// Human Handoff Configuration
+	Handoff HandoffConfig `mapstructure:"handoff"`
 }
 
 type ServerConfig struct {
 	Host string `mapstructure:"host"`
 	Port string `mapstructure:"port"`
+	// PublicURL is where gateways reach the bot, used for files it serves
+	PublicURL string `mapstructure:"public_url"`
+	// StatsToken protects /stats, which then also reports usage per sender
+	StatsToken string `mapstructure:"stats_token"`
 }
 
 type WhatsAppConfig struct {
 	SessionPath string `mapstructure:"session_path"`
 	LogLevel    string `mapstructure:"log_level"`
+
+	// Gateway selects how messages are sent and received: "fonnte",
+	// "meta" or "whatsmeow"
+	Gateway string `mapstructure:"gateway"`
 }
 
 type FontteConfig struct {
-	APIKey     string `mapstructure:"api_key"`
-	WebhookURL string `mapstructure:"webhook_url"`
+	APIKey      string `mapstructure:"api_key"`
+	WebhookURL  string `mapstructure:"webhook_url"`
+	BaseURL     string `mapstructure:"base_url"`
+	CountryCode string `mapstructure:"country_code"`
+	Typing      bool   `mapstructure:"typing"`
+}
+
+type MetaConfig struct {
+	AccessToken   string `mapstructure:"access_token"`
+	PhoneNumberID string `mapstructure:"phone_number_id"`
+	AppSecret     string `mapstructure:"app_secret"`
+	VerifyToken   string `mapstructure:"verify_token"`
+	APIVersion    string `mapstructure:"api_version"`
+	BaseURL       string `mapstructure:"base_url"`
 }
 
 type OpenAIConfig struct {
-	APIKey    string `mapstructure:"api_key"`
-	BaseURL   string `mapstructure:"base_url"`
-	Model     string `mapstructure:"model"`
-	MaxTokens int    `mapstructure:"max_tokens"`
+	APIKey    string                `mapstructure:"api_key"`
+	BaseURL   string                `mapstructure:"base_url"`
+	Model     string                `mapstructure:"model"`
+	MaxTokens int                   `mapstructure:"max_tokens"`
+	Provider  string                `mapstructure:"provider"`
+	Pricing   map[string]ModelPrice `mapstructure:"pricing"`
+
+	// ScriptFile holds the rules of the "scripted" provider, and RecordFile
+	// receives real completions so they can be replayed by it
+	ScriptFile string `mapstructure:"script_file"`
+	RecordFile string `mapstructure:"record_file"`
+
+	// Structured output: use response_format json_schema when the provider
+	// supports it, and how many times to ask the model to repair bad JSON
+	JSONSchema        bool `mapstructure:"json_schema"`
+	StructuredRetries int  `mapstructure:"structured_retries"`
+}
+
+// ModelPrice is the USD price per one million tokens for a model
+type ModelPrice struct {
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
+	Cached     float64 `mapstructure:"cached"`
 }
 
 type ImageConfig struct {
@@ -59,7 +148,236 @@ type DatabaseConfig struct {
 	URL string `mapstructure:"url"`
 }
 
+type PersonaConfig struct {
+	File         string `mapstructure:"file"`
+	SystemPrompt string `mapstructure:"system_prompt"`
+	Timezone     string `mapstructure:"timezone"`
+}
+
+type RouterConfig struct {
+	Enabled      bool                   `mapstructure:"enabled"`
+	DefaultTier  string                 `mapstructure:"default_tier"`
+	Tiers        map[string]string      `mapstructure:"tiers"`
+	Rules        []RouteRule            `mapstructure:"rules"`
+	ToolKeywords []string               `mapstructure:"tool_keywords"`
+	Classifier   RouterClassifierConfig `mapstructure:"classifier"`
+}
+
+// RouteRule sends a message to Tier when all of its set conditions match
+type RouteRule struct {
+	Tier       string `mapstructure:"tier"`
+	MinLength  int    `mapstructure:"min_length"`
+	MaxLength  int    `mapstructure:"max_length"`
+	HasMedia   *bool  `mapstructure:"has_media"`
+	NeedsTools *bool  `mapstructure:"needs_tools"`
+	Pattern    string `mapstructure:"pattern"`
+}
+
+type RouterClassifierConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Model   string `mapstructure:"model"`
+}
+
+type StreamingConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	MinChunkSize int           `mapstructure:"min_chunk_size"`
+	MinInterval  time.Duration `mapstructure:"min_interval"`
+}
+
+type ModerationConfig struct {
+	Enabled       bool                      `mapstructure:"enabled"`
+	Stages        []string                  `mapstructure:"stages"`
+	Actions       map[string]string         `mapstructure:"actions"`
+	DefaultAction string                    `mapstructure:"default_action"`
+	AdminNumber   string                    `mapstructure:"admin_number"`
+	FailClosed    bool                      `mapstructure:"fail_closed"`
+	OpenAI        ModerationOpenAIConfig    `mapstructure:"openai"`
+	Blocklist     ModerationBlocklistConfig `mapstructure:"blocklist"`
+	Judge         ModerationJudgeConfig     `mapstructure:"judge"`
+}
+
+type ModerationOpenAIConfig struct {
+	Enabled   bool    `mapstructure:"enabled"`
+	Model     string  `mapstructure:"model"`
+	Threshold float64 `mapstructure:"threshold"`
+}
+
+type ModerationBlocklistConfig struct {
+	File       string              `mapstructure:"file"`
+	Categories map[string][]string `mapstructure:"categories"`
+}
+
+type ModerationJudgeConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Model   string `mapstructure:"model"`
+	Prompt  string `mapstructure:"prompt"`
+}
+
+type GuardConfig struct {
+	BlockToolsOnUntrusted bool   `mapstructure:"block_tools_on_untrusted"`
+	SuspiciousAction      string `mapstructure:"suspicious_action"` // block or log
+	MinOverlap            int    `mapstructure:"min_overlap"`
+}
+
+type LanguageConfig struct {
+	Default       string `mapstructure:"default"`
+	MinConfidence int    `mapstructure:"min_confidence"`
+}
+
+// WebhookConfig controls which webhook requests are accepted. Every check
+// is skipped while its setting is empty.
+type WebhookConfig struct {
+	Token           string        `mapstructure:"token"`
+	AllowedIPs      []string      `mapstructure:"allowed_ips"`
+	TrustedProxies  []string      `mapstructure:"trusted_proxies"`
+	Secret          string        `mapstructure:"secret"`
+	SignatureHeader string        `mapstructure:"signature_header"`
+	Devices         []string      `mapstructure:"devices"`
+	ReplayWindow    time.Duration `mapstructure:"replay_window"`
+}
+
+type QueueConfig struct {
+	Workers           int           `mapstructure:"workers"`
+	PollInterval      time.Duration `mapstructure:"poll_interval"`
+	VisibilityTimeout time.Duration `mapstructure:"visibility_timeout"`
+	MaxAttempts       int           `mapstructure:"max_attempts"`
+	RetryDelay        time.Duration `mapstructure:"retry_delay"`
+	Retention         time.Duration `mapstructure:"retention"`
+	DrainTimeout      time.Duration `mapstructure:"drain_timeout"`
+
+	// Debounce holds a sender's messages until they have been quiet this
+	// long, then answers the burst as one message. Zero answers each
+	// message on its own.
+	Debounce time.Duration `mapstructure:"debounce"`
+	// DebounceMaxWait caps how long a burst is held, counted from its
+	// oldest message, so a sender who keeps typing still gets an answer.
+	// Zero means no cap.
+	DebounceMaxWait time.Duration `mapstructure:"debounce_max_wait"`
+
+	// DedupWindow is how long a message's idempotency key is remembered;
+	// zero disables deduplication. Messages without a provider ID are keyed
+	// by their content, and identical ones arriving within DedupBucket of
+	// the first count once.
+	DedupWindow time.Duration `mapstructure:"dedup_window"`
+	DedupBucket time.Duration `mapstructure:"dedup_bucket"`
+}
+
+// OutboxConfig controls background delivery of outgoing messages
+type OutboxConfig struct {
+	Workers           int           `mapstructure:"workers"`
+	PollInterval      time.Duration `mapstructure:"poll_interval"`
+	VisibilityTimeout time.Duration `mapstructure:"visibility_timeout"`
+	MaxAttempts       int           `mapstructure:"max_attempts"`
+	RetryDelay        time.Duration `mapstructure:"retry_delay"`
+	Retention         time.Duration `mapstructure:"retention"`
+	DrainTimeout      time.Duration `mapstructure:"drain_timeout"`
+
+	// RatePerMinute and Burst limit how fast each device sends, to stay
+	// clear of WhatsApp's spam detection
+	RatePerMinute float64 `mapstructure:"rate_per_minute"`
+	Burst         int     `mapstructure:"burst"`
+}
+
+// FormatConfig controls how replies are adapted to WhatsApp before sending
+type FormatConfig struct {
+	Markdown    bool `mapstructure:"markdown"`     // convert Markdown to WhatsApp formatting
+	MaxLength   int  `mapstructure:"max_length"`   // split longer replies; 0 disables
+	NumberParts bool `mapstructure:"number_parts"` // prefix split parts with "(1/3)"
+}
+
+// VoiceConfig controls transcription of voice notes and spoken replies.
+// BaseURL and APIKey default to the OpenAI settings, so a separate
+// whisper-compatible server can be used for audio only.
+type VoiceConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	BaseURL            string `mapstructure:"base_url"`
+	APIKey             string `mapstructure:"api_key"`
+	TranscriptionModel string `mapstructure:"transcription_model"`
+	Language           string `mapstructure:"language"` // ISO-639-1 hint; empty to detect
+	MaxSize            int64  `mapstructure:"max_size"` // bytes
+	Reply              bool   `mapstructure:"reply"`    // answer voice notes with audio
+	SpeechModel        string `mapstructure:"speech_model"`
+	Voice              string `mapstructure:"voice"`
+	SpeechFormat       string `mapstructure:"speech_format"`
+}
+
+// MediaConfig controls where received and generated files are stored and
+// how long the signed URLs they are served under stay valid
+type MediaConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	Backend    string        `mapstructure:"backend"` // fs or s3
+	Dir        string        `mapstructure:"dir"`
+	MaxSize    int64         `mapstructure:"max_size"` // bytes
+	SigningKey string        `mapstructure:"signing_key"`
+	URLTTL     time.Duration `mapstructure:"url_ttl"`
+	S3         S3Config      `mapstructure:"s3"`
+}
+
+// S3Config locates a bucket on S3 or an S3-compatible store such as MinIO
+type S3Config struct {
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	PathStyle bool   `mapstructure:"path_style"` // bucket in the path instead of the host
+}
+
+// GroupConfig controls when the bot answers in group chats. With policy
+// "triggered" it answers only messages that mention it, start with one of
+// the prefixes or reply to one of its messages; "all" answers everything
+// and "ignore" nothing.
+type GroupConfig struct {
+	Policy   string   `mapstructure:"policy"`
+	Mention  bool     `mapstructure:"mention"`
+	Reply    bool     `mapstructure:"reply"`
+	Prefixes []string `mapstructure:"prefixes"`
+	Names    []string `mapstructure:"names"`   // "@name" counts as a mention too
+	History  bool     `mapstructure:"history"` // record unanswered messages as context
+}
+
+// HandoffConfig controls live-agent takeover. While a conversation is
+// handed off the bot stops replying and relays messages between the user
+// and the operators.
+type HandoffConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	Tool          bool     `mapstructure:"tool"`      // offer the escalate_to_human tool to the model
+	Keywords      []string `mapstructure:"keywords"`  // phrases that hand the conversation over
+	Operators     []string `mapstructure:"operators"` // WhatsApp numbers of the staff who take over
+	WebhookURL    string   `mapstructure:"webhook_url"`
+	WebhookSecret string   `mapstructure:"webhook_secret"`
+	AdminToken    string   `mapstructure:"admin_token"` // bearer token for the admin API
+	SummaryModel  string   `mapstructure:"summary_model"`
+}
+
+type CacheConfig struct {
+	Enabled        bool                `mapstructure:"enabled"`
+	TTL            time.Duration       `mapstructure:"ttl"`
+	MinLength      int                 `mapstructure:"min_length"`
+	Version        string              `mapstructure:"version"`          // bump to invalidate after knowledge changes
+	FollowUpWindow time.Duration       `mapstructure:"follow_up_window"` // skip the cache this soon after the previous message
+	Semantic       CacheSemanticConfig `mapstructure:"semantic"`
+}
+
+type CacheSemanticConfig struct {
+	Enabled       bool    `mapstructure:"enabled"`
+	Model         string  `mapstructure:"model"`
+	Threshold     float64 `mapstructure:"threshold"`
+	MaxCandidates int     `mapstructure:"max_candidates"`
+}
+
 func Load() (*Config, error) {
+	return load(true)
+}
+
+// LoadOffline loads the configuration for commands that never talk to a
+// WhatsApp gateway, such as the evaluation harness. Gateway credentials are
+// not required.
+func LoadOffline() (*Config, error) {
+	return load(false)
+}
+
+func load(gateway bool) (*Config, error) {
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
 		// .env file is optional, so we don't return error
@@ -91,7 +409,7 @@ func Load() (*Config, error) {
 	}
 
 	// Validate required fields
-	if err := validateConfig(&config); err != nil {
+	if err := validateConfig(&config, gateway); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
 
@@ -106,10 +424,23 @@ func setDefaults() {
 	// WhatsApp defaults
 	viper.SetDefault("whatsapp.session_path", "./sessions")
 	viper.SetDefault("whatsapp.log_level", "INFO")
+	viper.SetDefault("whatsapp.gateway", "fonnte")
+
+	// Fonnte defaults
+	viper.SetDefault("fonnte.base_url", "https://api.fonnte.com")
+	viper.SetDefault("fonnte.country_code", "62")
+	viper.SetDefault("fonnte.typing", false)
+
+	// Meta defaults
+	viper.SetDefault("meta.api_version", "v21.0")
+	viper.SetDefault("meta.base_url", "https://graph.facebook.com")
 
 	// OpenAI defaults
 	viper.SetDefault("openai.model", "gpt-4-turbo-preview")
 	viper.SetDefault("openai.max_tokens", 1000)
+	viper.SetDefault("openai.provider", "openai")
+	viper.SetDefault("openai.json_schema", true)
+	viper.SetDefault("openai.structured_retries", 2)
 
 	// Image defaults
 	viper.SetDefault("image.provider", "openai")
@@ -117,29 +448,308 @@ func setDefaults() {
 	// Database defaults
 	viper.SetDefault("database.url", "sqlite://./bot.db")
 
+	// Persona defaults
+	viper.SetDefault("persona.file", "./configs/personas.yaml")
+	viper.SetDefault("persona.timezone", "Local")
+
+	// Router defaults
+	viper.SetDefault("router.enabled", false)
+	viper.SetDefault("router.tool_keywords", []string{"image", "picture", "draw", "gambar", "foto"})
+
+	// Streaming defaults
+	viper.SetDefault("streaming.enabled", false)
+	viper.SetDefault("streaming.min_chunk_size", 200)
+	viper.SetDefault("streaming.min_interval", "1s")
+
+	// Moderation defaults
+	viper.SetDefault("moderation.enabled", false)
+	viper.SetDefault("moderation.stages", []string{"input", "output", "image"})
+	viper.SetDefault("moderation.default_action", "block")
+	viper.SetDefault("moderation.openai.enabled", true)
+	viper.SetDefault("moderation.openai.model", "omni-moderation-latest")
+
+	// Guard defaults
+	viper.SetDefault("guard.block_tools_on_untrusted", false)
+	viper.SetDefault("guard.suspicious_action", "block")
+	viper.SetDefault("guard.min_overlap", 24)
+
+	// Queue defaults
+	viper.SetDefault("queue.workers", 4)
+	viper.SetDefault("queue.poll_interval", "1s")
+	viper.SetDefault("queue.visibility_timeout", "5m")
+	viper.SetDefault("queue.max_attempts", 5)
+	viper.SetDefault("queue.retry_delay", "10s")
+	viper.SetDefault("queue.retention", "24h")
+	viper.SetDefault("queue.drain_timeout", "30s")
+	viper.SetDefault("queue.debounce", "0s")
+	viper.SetDefault("queue.debounce_max_wait", "15s")
+	viper.SetDefault("queue.dedup_window", "24h")
+	viper.SetDefault("queue.dedup_bucket", "1m")
+
+	// Outbox defaults
+	viper.SetDefault("outbox.workers", 2)
+	viper.SetDefault("outbox.poll_interval", "1s")
+	viper.SetDefault("outbox.visibility_timeout", "2m")
+	viper.SetDefault("outbox.max_attempts", 6)
+	viper.SetDefault("outbox.retry_delay", "5s")
+	viper.SetDefault("outbox.retention", "168h")
+	viper.SetDefault("outbox.drain_timeout", "10s")
+	viper.SetDefault("outbox.rate_per_minute", 30)
+	viper.SetDefault("outbox.burst", 5)
+
+	// Format defaults
+	viper.SetDefault("format.markdown", true)
+	viper.SetDefault("format.max_length", 4000)
+	viper.SetDefault("format.number_parts", true)
+
+	// Voice defaults
+	viper.SetDefault("voice.enabled", false)
+	viper.SetDefault("voice.transcription_model", "whisper-1")
+	viper.SetDefault("voice.max_size", 25<<20)
+	viper.SetDefault("voice.reply", false)
+	viper.SetDefault("voice.speech_model", "tts-1")
+	viper.SetDefault("voice.voice", "alloy")
+	viper.SetDefault("voice.speech_format", "opus")
+
+	// Group defaults
+	viper.SetDefault("group.policy", "triggered")
+	viper.SetDefault("group.mention", true)
+	viper.SetDefault("group.reply", true)
+	viper.SetDefault("group.prefixes", []string{"/ai"})
+	viper.SetDefault("group.history", true)
+
+	// Handoff defaults
+	viper.SetDefault("handoff.enabled", false)
+	viper.SetDefault("handoff.tool", true)
+	viper.SetDefault("handoff.keywords", []string{"talk to a human", "speak to a human", "real person", "live agent", "customer service", "bicara dengan manusia"})
+
+	// Media defaults
+	viper.SetDefault("media.enabled", true)
+	viper.SetDefault("media.backend", "fs")
+	viper.SetDefault("media.dir", "./data/media")
+	viper.SetDefault("media.max_size", 100<<20)
+	viper.SetDefault("media.url_ttl", "24h")
+	viper.SetDefault("media.s3.region", "us-east-1")
+	viper.SetDefault("media.s3.path_style", true)
+
+	// Webhook defaults
+	viper.SetDefault("webhook.signature_header", "X-Webhook-Signature")
+	viper.SetDefault("webhook.replay_window", 0)
+
+	// Language defaults
+	viper.SetDefault("language.default", "en")
+	viper.SetDefault("language.min_confidence", 2)
+
+	// Cache defaults
+	viper.SetDefault("cache.enabled", false)
+	viper.SetDefault("cache.ttl", "24h")
+	viper.SetDefault("cache.min_length", 12)
+	viper.SetDefault("cache.follow_up_window", "10m")
+	viper.SetDefault("cache.semantic.enabled", false)
+	viper.SetDefault("cache.semantic.model", "text-embedding-3-small")
+	viper.SetDefault("cache.semantic.threshold", 0.92)
+	viper.SetDefault("cache.semantic.max_candidates", 500)
+
 	// Bind environment variables
 	viper.BindEnv("server.host", "SERVER_HOST")
 	viper.BindEnv("server.port", "SERVER_PORT")
+	viper.BindEnv("server.public_url", "SERVER_PUBLIC_URL")
+	viper.BindEnv("server.stats_token", "SERVER_STATS_TOKEN")
 	viper.BindEnv("whatsapp.session_path", "WHATSAPP_SESSION_PATH")
 	viper.BindEnv("whatsapp.log_level", "WHATSAPP_LOG_LEVEL")
 	viper.BindEnv("fonnte.api_key", "FONNTE_API_KEY")
 	viper.BindEnv("fonnte.webhook_url", "FONNTE_WEBHOOK_URL")
+	viper.BindEnv("fonnte.base_url", "FONNTE_BASE_URL")
+	viper.BindEnv("fonnte.country_code", "FONNTE_COUNTRY_CODE")
+	viper.BindEnv("fonnte.typing", "FONNTE_TYPING")
+	viper.BindEnv("whatsapp.gateway", "WHATSAPP_GATEWAY")
+	viper.BindEnv("meta.access_token", "META_ACCESS_TOKEN")
+	viper.BindEnv("meta.phone_number_id", "META_PHONE_NUMBER_ID")
+	viper.BindEnv("meta.app_secret", "META_APP_SECRET")
+	viper.BindEnv("meta.verify_token", "META_VERIFY_TOKEN")
+	viper.BindEnv("meta.api_version", "META_API_VERSION")
+	viper.BindEnv("meta.base_url", "META_BASE_URL")
 	viper.BindEnv("openai.api_key", "OPENAI_API_KEY")
 	viper.BindEnv("openai.base_url", "OPENAI_BASE_URL")
 	viper.BindEnv("openai.model", "OPENAI_MODEL")
 	viper.BindEnv("openai.max_tokens", "OPENAI_MAX_TOKENS")
+	viper.BindEnv("openai.provider", "OPENAI_PROVIDER")
+	viper.BindEnv("openai.script_file", "OPENAI_SCRIPT_FILE")
+	viper.BindEnv("openai.record_file", "OPENAI_RECORD_FILE")
+	viper.BindEnv("openai.json_schema", "OPENAI_JSON_SCHEMA")
+	viper.BindEnv("openai.structured_retries", "OPENAI_STRUCTURED_RETRIES")
 	viper.BindEnv("image.provider", "IMAGE_API_PROVIDER")
 	viper.BindEnv("image.api_key", "IMAGE_API_KEY")
 	viper.BindEnv("database.url", "DATABASE_URL")
+	viper.BindEnv("persona.file", "PERSONA_FILE")
+	viper.BindEnv("persona.system_prompt", "PERSONA_SYSTEM_PROMPT")
+	viper.BindEnv("persona.timezone", "PERSONA_TIMEZONE")
+	viper.BindEnv("router.enabled", "ROUTER_ENABLED")
+	viper.BindEnv("router.default_tier", "ROUTER_DEFAULT_TIER")
+	viper.BindEnv("router.classifier.enabled", "ROUTER_CLASSIFIER_ENABLED")
+	viper.BindEnv("router.classifier.model", "ROUTER_CLASSIFIER_MODEL")
+	viper.BindEnv("streaming.enabled", "STREAMING_ENABLED")
+	viper.BindEnv("streaming.min_chunk_size", "STREAMING_MIN_CHUNK_SIZE")
+	viper.BindEnv("streaming.min_interval", "STREAMING_MIN_INTERVAL")
+	viper.BindEnv("moderation.enabled", "MODERATION_ENABLED")
+	viper.BindEnv("moderation.default_action", "MODERATION_DEFAULT_ACTION")
+	viper.BindEnv("moderation.admin_number", "MODERATION_ADMIN_NUMBER")
+	viper.BindEnv("moderation.fail_closed", "MODERATION_FAIL_CLOSED")
+	viper.BindEnv("moderation.openai.enabled", "MODERATION_OPENAI_ENABLED")
+	viper.BindEnv("moderation.openai.model", "MODERATION_OPENAI_MODEL")
+	viper.BindEnv("moderation.blocklist.file", "MODERATION_BLOCKLIST_FILE")
+	viper.BindEnv("moderation.judge.enabled", "MODERATION_JUDGE_ENABLED")
+	viper.BindEnv("moderation.judge.model", "MODERATION_JUDGE_MODEL")
+	viper.BindEnv("guard.block_tools_on_untrusted", "GUARD_BLOCK_TOOLS_ON_UNTRUSTED")
+	viper.BindEnv("guard.suspicious_action", "GUARD_SUSPICIOUS_ACTION")
+	viper.BindEnv("guard.min_overlap", "GUARD_MIN_OVERLAP")
+	viper.BindEnv("queue.workers", "QUEUE_WORKERS")
+	viper.BindEnv("queue.poll_interval", "QUEUE_POLL_INTERVAL")
+	viper.BindEnv("queue.visibility_timeout", "QUEUE_VISIBILITY_TIMEOUT")
+	viper.BindEnv("queue.max_attempts", "QUEUE_MAX_ATTEMPTS")
+	viper.BindEnv("queue.retry_delay", "QUEUE_RETRY_DELAY")
+	viper.BindEnv("queue.retention", "QUEUE_RETENTION")
+	viper.BindEnv("queue.drain_timeout", "QUEUE_DRAIN_TIMEOUT")
+	viper.BindEnv("queue.debounce", "QUEUE_DEBOUNCE")
+	viper.BindEnv("queue.debounce_max_wait", "QUEUE_DEBOUNCE_MAX_WAIT")
+	viper.BindEnv("queue.dedup_window", "QUEUE_DEDUP_WINDOW")
+	viper.BindEnv("queue.dedup_bucket", "QUEUE_DEDUP_BUCKET")
+	viper.BindEnv("outbox.workers", "OUTBOX_WORKERS")
+	viper.BindEnv("outbox.poll_interval", "OUTBOX_POLL_INTERVAL")
+	viper.BindEnv("outbox.visibility_timeout", "OUTBOX_VISIBILITY_TIMEOUT")
+	viper.BindEnv("outbox.max_attempts", "OUTBOX_MAX_ATTEMPTS")
+	viper.BindEnv("outbox.retry_delay", "OUTBOX_RETRY_DELAY")
+	viper.BindEnv("outbox.retention", "OUTBOX_RETENTION")
+	viper.BindEnv("outbox.drain_timeout", "OUTBOX_DRAIN_TIMEOUT")
+	viper.BindEnv("outbox.rate_per_minute", "OUTBOX_RATE_PER_MINUTE")
+	viper.BindEnv("outbox.burst", "OUTBOX_BURST")
+	viper.BindEnv("format.markdown", "FORMAT_MARKDOWN")
+	viper.BindEnv("format.max_length", "FORMAT_MAX_LENGTH")
+	viper.BindEnv("format.number_parts", "FORMAT_NUMBER_PARTS")
+	viper.BindEnv("voice.enabled", "VOICE_ENABLED")
+	viper.BindEnv("voice.base_url", "VOICE_BASE_URL")
+	viper.BindEnv("voice.api_key", "VOICE_API_KEY")
+	viper.BindEnv("voice.transcription_model", "VOICE_TRANSCRIPTION_MODEL")
+	viper.BindEnv("voice.language", "VOICE_LANGUAGE")
+	viper.BindEnv("voice.max_size", "VOICE_MAX_SIZE")
+	viper.BindEnv("voice.reply", "VOICE_REPLY")
+	viper.BindEnv("voice.speech_model", "VOICE_SPEECH_MODEL")
+	viper.BindEnv("voice.voice", "VOICE_VOICE")
+	viper.BindEnv("voice.speech_format", "VOICE_SPEECH_FORMAT")
+	viper.BindEnv("group.policy", "GROUP_POLICY")
+	viper.BindEnv("group.mention", "GROUP_MENTION")
+	viper.BindEnv("group.reply", "GROUP_REPLY")
+	viper.BindEnv("group.prefixes", "GROUP_PREFIXES")
+	viper.BindEnv("group.names", "GROUP_NAMES")
+	viper.BindEnv("group.history", "GROUP_HISTORY")
+	viper.BindEnv("handoff.enabled", "HANDOFF_ENABLED")
+	viper.BindEnv("handoff.tool", "HANDOFF_TOOL")
+	viper.BindEnv("handoff.keywords", "HANDOFF_KEYWORDS")
+	viper.BindEnv("handoff.operators", "HANDOFF_OPERATORS")
+	viper.BindEnv("handoff.webhook_url", "HANDOFF_WEBHOOK_URL")
+	viper.BindEnv("handoff.webhook_secret", "HANDOFF_WEBHOOK_SECRET")
+	viper.BindEnv("handoff.admin_token", "HANDOFF_ADMIN_TOKEN")
+	viper.BindEnv("handoff.summary_model", "HANDOFF_SUMMARY_MODEL")
+	viper.BindEnv("media.enabled", "MEDIA_ENABLED")
+	viper.BindEnv("media.backend", "MEDIA_BACKEND")
+	viper.BindEnv("media.dir", "MEDIA_DIR")
+	viper.BindEnv("media.max_size", "MEDIA_MAX_SIZE")
+	viper.BindEnv("media.signing_key", "MEDIA_SIGNING_KEY")
+	viper.BindEnv("media.url_ttl", "MEDIA_URL_TTL")
+	viper.BindEnv("media.s3.endpoint", "MEDIA_S3_ENDPOINT")
+	viper.BindEnv("media.s3.region", "MEDIA_S3_REGION")
+	viper.BindEnv("media.s3.bucket", "MEDIA_S3_BUCKET")
+	viper.BindEnv("media.s3.access_key", "MEDIA_S3_ACCESS_KEY")
+	viper.BindEnv("media.s3.secret_key", "MEDIA_S3_SECRET_KEY")
+	viper.BindEnv("media.s3.path_style", "MEDIA_S3_PATH_STYLE")
+	viper.BindEnv("webhook.token", "WEBHOOK_TOKEN")
+	viper.BindEnv("webhook.allowed_ips", "WEBHOOK_ALLOWED_IPS")
+	viper.BindEnv("webhook.trusted_proxies", "WEBHOOK_TRUSTED_PROXIES")
+	viper.BindEnv("webhook.secret", "WEBHOOK_SECRET")
+	viper.BindEnv("webhook.signature_header", "WEBHOOK_SIGNATURE_HEADER")
+	viper.BindEnv("webhook.devices", "WEBHOOK_DEVICES")
+	viper.BindEnv("webhook.replay_window", "WEBHOOK_REPLAY_WINDOW")
+	viper.BindEnv("language.default", "LANGUAGE_DEFAULT")
+	viper.BindEnv("language.min_confidence", "LANGUAGE_MIN_CONFIDENCE")
+	viper.BindEnv("cache.enabled", "CACHE_ENABLED")
+	viper.BindEnv("cache.ttl", "CACHE_TTL")
+	viper.BindEnv("cache.min_length", "CACHE_MIN_LENGTH")
+	viper.BindEnv("cache.version", "CACHE_VERSION")
+	viper.BindEnv("cache.follow_up_window", "CACHE_FOLLOW_UP_WINDOW")
+	viper.BindEnv("cache.semantic.enabled", "CACHE_SEMANTIC_ENABLED")
+	viper.BindEnv("cache.semantic.model", "CACHE_SEMANTIC_MODEL")
+	viper.BindEnv("cache.semantic.threshold", "CACHE_SEMANTIC_THRESHOLD")
 }
 
-func validateConfig(config *Config) error {
-	if config.OpenAI.APIKey == "" || config.OpenAI.APIKey == "your_openai_api_key" {
+func validateConfig(config *Config, gateway bool) error {
+	if config.OpenAI.Provider == "scripted" {
+		if config.OpenAI.ScriptFile == "" {
+			return fmt.Errorf("OPENAI_SCRIPT_FILE is required for the scripted provider")
+		}
+	} else if config.OpenAI.APIKey == "" || config.OpenAI.APIKey == "your_openai_api_key" {
 		return fmt.Errorf("OPENAI_API_KEY is required")
 	}
 
-	if config.Fonnte.APIKey == "" || config.Fonnte.APIKey == "your_fonnte_api_key" {
-		return fmt.Errorf("FONNTE_API_KEY is required")
+	if gateway {
+		if err := validateGateway(config); err != nil {
+			return err
+		}
+	}
+
+	if config.Voice.Enabled {
+		if config.Voice.BaseURL == "" {
+			config.Voice.BaseURL = config.OpenAI.BaseURL
+		}
+		if config.Voice.APIKey == "" {
+			config.Voice.APIKey = config.OpenAI.APIKey
+		}
+		// Gateways fetch spoken replies from the media store by URL
+		if config.Voice.Reply && (!config.Media.Enabled || config.Server.PublicURL == "") {
+			return fmt.Errorf("VOICE_REPLY requires MEDIA_ENABLED and SERVER_PUBLIC_URL")
+		}
+	}
+
+	// Unsigned timestamps and nonces can be made up by anyone, who would
+	// then fill the nonce store
+	if config.Webhook.ReplayWindow > 0 && config.Webhook.Secret == "" {
+		return fmt.Errorf("WEBHOOK_REPLAY_WINDOW requires WEBHOOK_SECRET")
+	}
+
+	// Output moderation screens each streamed part on its own, so a reply
+	// split across parts could slip through
+	if config.Streaming.Enabled && config.Moderation.Enabled {
+		for _, stage := range config.Moderation.Stages {
+			if strings.EqualFold(stage, "output") {
+				return fmt.Errorf("STREAMING_ENABLED cannot be combined with the output moderation stage; remove output from moderation.stages or disable streaming")
+			}
+		}
+	}
+
+	switch config.Group.Policy {
+	case "triggered", "all", "ignore":
+	default:
+		return fmt.Errorf("unknown GROUP_POLICY %q", config.Group.Policy)
+	}
+
+	// Someone has to be told when a conversation is handed over
+	if config.Handoff.Enabled && len(config.Handoff.Operators) == 0 && config.Handoff.WebhookURL == "" {
+		return fmt.Errorf("HANDOFF_OPERATORS or HANDOFF_WEBHOOK_URL is required when HANDOFF_ENABLED is set")
+	}
+
+	if config.Media.Enabled {
+		switch config.Media.Backend {
+		case "fs":
+			if err := os.MkdirAll(config.Media.Dir, 0755); err != nil {
+				return fmt.Errorf("failed to create media directory: %w", err)
+			}
+		case "s3":
+			s3 := config.Media.S3
+			if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+				return fmt.Errorf("MEDIA_S3_ENDPOINT, MEDIA_S3_BUCKET, MEDIA_S3_ACCESS_KEY and MEDIA_S3_SECRET_KEY are required for the s3 media backend")
+			}
+		default:
+			return fmt.Errorf("unknown MEDIA_BACKEND %q", config.Media.Backend)
+		}
 	}
 
 	// Create sessions directory if it doesn't exist
@@ -147,5 +757,26 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("failed to create sessions directory: %w", err)
 	}
 
+	return nil
+}
+
+// validateGateway checks the credentials of the configured WhatsApp gateway
+func validateGateway(config *Config) error {
+	switch config.WhatsApp.Gateway {
+	case "fonnte":
+		if config.Fonnte.APIKey == "" || config.Fonnte.APIKey == "your_fonnte_api_key" {
+			return fmt.Errorf("FONNTE_API_KEY is required")
+		}
+	case "meta":
+		if config.Meta.AccessToken == "" || config.Meta.PhoneNumberID == "" {
+			return fmt.Errorf("META_ACCESS_TOKEN and META_PHONE_NUMBER_ID are required for the meta gateway")
+		}
+		if config.Meta.AppSecret == "" || config.Meta.VerifyToken == "" {
+			return fmt.Errorf("META_APP_SECRET and META_VERIFY_TOKEN are required for the meta gateway")
+		}
+	case "whatsmeow":
+	default:
+		return fmt.Errorf("unknown WHATSAPP_GATEWAY %q", config.WhatsApp.Gateway)
+	}
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,147 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	openaiService "example-tool-call/internal/services/openai"
+)
+
+const judgePrompt = `You grade replies of a WhatsApp assistant. Decide whether the assistant's reply
+satisfies the rubric. Judge only what the rubric asks for.
+
+Rubric: %s`
+
+// Check is the outcome of one assertion
+type Check struct {
+	Name   string `json:"name"`
+	Pass   bool   `json:"pass"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type verdict struct {
+	Pass   bool   `json:"pass" description:"Whether the reply satisfies the rubric"`
+	Reason string `json:"reason" description:"One sentence explaining the decision"`
+}
+
+// check evaluates a turn's assertions against what the bot did. The text
+// checked is every reply and image caption sent during the turn.
+func (r *Runner) check(ctx context.Context, turn Turn, result TurnResult) []Check {
+	expect := turn.Expect
+	text := result.Text()
+	lower := strings.ToLower(text)
+
+	var checks []Check
+	for _, want := range expect.Contains {
+		checks = append(checks, Check{
+			Name: fmt.Sprintf("contains %q", want),
+			Pass: strings.Contains(lower, strings.ToLower(want)),
+		})
+	}
+
+	for _, unwanted := range expect.NotContains {
+		checks = append(checks, Check{
+			Name: fmt.Sprintf("does not contain %q", unwanted),
+			Pass: !strings.Contains(lower, strings.ToLower(unwanted)),
+		})
+	}
+
+	for _, expr := range expect.Regex {
+		check := Check{Name: fmt.Sprintf("matches /%s/", expr)}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			check.Detail = fmt.Sprintf("invalid pattern: %v", err)
+		} else {
+			check.Pass = pattern.MatchString(text)
+		}
+		checks = append(checks, check)
+	}
+
+	if expect.Tool != nil {
+		check := Check{Name: fmt.Sprintf("calls %s", expect.Tool.Name)}
+		for _, call := range result.ToolCalls {
+			if call.Name == expect.Tool.Name && argsMatch(expect.Tool.Args, call.Args) {
+				check.Pass = true
+				break
+			}
+		}
+		if !check.Pass {
+			check.Detail = fmt.Sprintf("tool calls: %s", formatToolCalls(result.ToolCalls))
+		}
+		checks = append(checks, check)
+	}
+
+	if expect.NoTool {
+		check := Check{Name: "calls no tools", Pass: len(result.ToolCalls) == 0}
+		if !check.Pass {
+			check.Detail = fmt.Sprintf("tool calls: %s", formatToolCalls(result.ToolCalls))
+		}
+		checks = append(checks, check)
+	}
+
+	if expect.Judge != "" {
+		checks = append(checks, r.judgeReply(ctx, turn.User, text, expect.Judge))
+	}
+
+	return checks
+}
+
+func (r *Runner) judgeReply(ctx context.Context, user, reply, rubric string) Check {
+	check := Check{Name: fmt.Sprintf("judge: %s", rubric)}
+
+	schema, err := openaiService.SchemaFor("eval_verdict", verdict{})
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	var v verdict
+	err = r.judge.GenerateStructured(ctx, r.judgeModel, []openaiService.ChatMessage{
+		{Role: "system", Content: fmt.Sprintf(judgePrompt, rubric)},
+		{Role: "user", Content: fmt.Sprintf("User message:\n%s\n\nAssistant reply:\n%s", user, reply)},
+	}, schema, &v)
+	if err != nil {
+		check.Detail = fmt.Sprintf("judge failed: %v", err)
+		return check
+	}
+
+	check.Pass = v.Pass
+	check.Detail = v.Reason
+	return check
+}
+
+// argsMatch reports whether every expected argument is present. Strings
+// match case-insensitively as substrings, other values must be equal.
+func argsMatch(expected, actual map[string]interface{}) bool {
+	for name, want := range expected {
+		got, ok := actual[name]
+		if !ok {
+			return false
+		}
+		if wantStr, isStr := want.(string); isStr {
+			gotStr, _ := got.(string)
+			if !strings.Contains(strings.ToLower(gotStr), strings.ToLower(wantStr)) {
+				return false
+			}
+			continue
+		}
+		if fmt.Sprint(want) != fmt.Sprint(got) && !reflect.DeepEqual(want, got) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatToolCalls(calls []ToolCall) string {
+	if len(calls) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(calls))
+	for i, call := range calls {
+		parts[i] = fmt.Sprintf("%s(%v)", call.Name, call.Args)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,97 @@
+package eval
+
+import (
+	"context"
+	"testing"
+)
+
+func TestArgsMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]interface{}
+		actual   map[string]interface{}
+		want     bool
+	}{
+		{"no expectations", nil, map[string]interface{}{"prompt": "a cat"}, true},
+		{"substring", map[string]interface{}{"prompt": "CAT"}, map[string]interface{}{"prompt": "a black cat"}, true},
+		{"missing substring", map[string]interface{}{"prompt": "dog"}, map[string]interface{}{"prompt": "a cat"}, false},
+		{"missing argument", map[string]interface{}{"style": "vivid"}, map[string]interface{}{"prompt": "a cat"}, false},
+		{"string against number", map[string]interface{}{"size": "1024"}, map[string]interface{}{"size": 1024}, false},
+		{"number from YAML against JSON", map[string]interface{}{"count": 2}, map[string]interface{}{"count": float64(2)}, true},
+		{"different number", map[string]interface{}{"count": 2}, map[string]interface{}{"count": float64(3)}, false},
+		{"bool", map[string]interface{}{"hd": true}, map[string]interface{}{"hd": true}, true},
+		{"list", map[string]interface{}{"tags": []interface{}{"a", "b"}}, map[string]interface{}{"tags": []interface{}{"a", "b"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := argsMatch(tt.expected, tt.actual); got != tt.want {
+				t.Fatalf("argsMatch(%v, %v) = %v, want %v", tt.expected, tt.actual, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	drawCat := ToolCall{Name: "generate_image", Args: map[string]interface{}{"prompt": "a black cat"}}
+	tests := []struct {
+		name   string
+		expect Expect
+		result TurnResult
+		want   map[string]bool
+	}{
+		{
+			name:   "contains ignores case",
+			expect: Expect{Contains: []string{"hello"}, NotContains: []string{"sorry"}},
+			result: TurnResult{Replies: []string{"Hello there"}},
+			want:   map[string]bool{`contains "hello"`: true, `does not contain "sorry"`: true},
+		},
+		{
+			name:   "captions count as text",
+			expect: Expect{Contains: []string{"cat"}, NotContains: []string{"black"}},
+			result: TurnResult{Images: []Image{{Caption: "Your black cat"}}},
+			want:   map[string]bool{`contains "cat"`: true, `does not contain "black"`: false},
+		},
+		{
+			name:   "regex",
+			expect: Expect{Regex: []string{`^\d+ items$`, `(`}},
+			result: TurnResult{Replies: []string{"3 items"}},
+			want:   map[string]bool{`matches /^\d+ items$/`: true, `matches /(/`: false},
+		},
+		{
+			name:   "tool with arguments",
+			expect: Expect{Tool: &ToolExpectation{Name: "generate_image", Args: map[string]interface{}{"prompt": "cat"}}},
+			result: TurnResult{ToolCalls: []ToolCall{drawCat}},
+			want:   map[string]bool{"calls generate_image": true},
+		},
+		{
+			name:   "tool with other arguments",
+			expect: Expect{Tool: &ToolExpectation{Name: "generate_image", Args: map[string]interface{}{"prompt": "dog"}}},
+			result: TurnResult{ToolCalls: []ToolCall{drawCat}},
+			want:   map[string]bool{"calls generate_image": false},
+		},
+		{
+			name:   "no tool",
+			expect: Expect{NoTool: true},
+			result: TurnResult{ToolCalls: []ToolCall{drawCat}},
+			want:   map[string]bool{"calls no tools": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Runner{}
+			checks := r.check(context.Background(), Turn{Expect: tt.expect}, tt.result)
+			if len(checks) != len(tt.want) {
+				t.Fatalf("got %d checks, want %d: %+v", len(checks), len(tt.want), checks)
+			}
+			for _, check := range checks {
+				want, ok := tt.want[check.Name]
+				if !ok {
+					t.Fatalf("unexpected check %q", check.Name)
+				}
+				if check.Pass != want {
+					t.Errorf("%s: pass = %v, want %v (%s)", check.Name, check.Pass, want, check.Detail)
+				}
+			}
+		})
+	}
+}
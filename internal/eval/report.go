@@ -0,0 +1,176 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Result holds the outcome of running all scenarios with one variant
+type Result struct {
+	Variant   Variant          `json:"variant"`
+	Scenarios []ScenarioResult `json:"scenarios"`
+}
+
+type ScenarioResult struct {
+	Name  string       `json:"name"`
+	Turns []TurnResult `json:"turns"`
+}
+
+type TurnResult struct {
+	User      string     `json:"user"`
+	Recorded  string     `json:"recorded,omitempty"`
+	Replies   []string   `json:"replies"`
+	Images    []Image    `json:"images,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Checks    []Check    `json:"checks,omitempty"`
+}
+
+// Text joins every reply and image caption sent during the turn
+func (t TurnResult) Text() string {
+	parts := append([]string(nil), t.Replies...)
+	for _, image := range t.Images {
+		parts = append(parts, image.Caption)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// Counts returns the number of passed and total checks
+func (r *Result) Counts() (passed, total int) {
+	for _, scenario := range r.Scenarios {
+		for _, turn := range scenario.Turns {
+			for _, check := range turn.Checks {
+				total++
+				if check.Pass {
+					passed++
+				}
+			}
+		}
+	}
+	return passed, total
+}
+
+// WriteReport writes a Markdown report of one or two results. With two
+// results the second is compared against the first: changed replies are
+// marked and checks that flipped are listed as regressions or improvements.
+func WriteReport(w io.Writer, results ...*Result) error {
+	if len(results) == 0 || len(results) > 2 {
+		return fmt.Errorf("report needs one or two results, got %d", len(results))
+	}
+
+	var b strings.Builder
+	b.WriteString("# Evaluation report\n\n")
+	b.WriteString("| Variant | Model | Persona file | Checks passed |\n|---|---|---|---|\n")
+	for _, result := range results {
+		passed, total := result.Counts()
+		fmt.Fprintf(&b, "| %s | %s | %s | %d/%d |\n", result.Variant.Name,
+			orDefault(result.Variant.Model), orDefault(result.Variant.PersonaFile), passed, total)
+	}
+	b.WriteString("\n")
+
+	if len(results) == 2 {
+		writeFlips(&b, results[0], results[1])
+	}
+
+	base := results[0]
+	for i, scenario := range base.Scenarios {
+		fmt.Fprintf(&b, "## %s\n\n", scenario.Name)
+		for j, turn := range scenario.Turns {
+			changed := ""
+			if len(results) == 2 && !sameTurn(turn, results[1].Scenarios[i].Turns[j]) {
+				changed = " — **changed**"
+			}
+			fmt.Fprintf(&b, "### Turn %d%s\n\n> **User:** %s\n\n", j+1, changed, quote(turn.User))
+			if turn.Recorded != "" {
+				fmt.Fprintf(&b, "**Recorded reply:**\n\n> %s\n\n", quote(turn.Recorded))
+			}
+			for _, result := range results {
+				writeTurn(&b, result.Variant.Name, result.Scenarios[i].Turns[j])
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeTurn(b *strings.Builder, variant string, turn TurnResult) {
+	fmt.Fprintf(b, "**%s:**\n\n", variant)
+	if text := turn.Text(); text != "" {
+		fmt.Fprintf(b, "> %s\n\n", quote(text))
+	} else {
+		b.WriteString("> _(no reply)_\n\n")
+	}
+	for _, image := range turn.Images {
+		fmt.Fprintf(b, "- image: %s\n", image.URL)
+	}
+	for _, call := range turn.ToolCalls {
+		fmt.Fprintf(b, "- tool: %s %v\n", call.Name, call.Args)
+	}
+	for _, check := range turn.Checks {
+		mark := "❌"
+		if check.Pass {
+			mark = "✅"
+		}
+		if check.Detail != "" {
+			fmt.Fprintf(b, "- %s %s — %s\n", mark, check.Name, check.Detail)
+		} else {
+			fmt.Fprintf(b, "- %s %s\n", mark, check.Name)
+		}
+	}
+	b.WriteString("\n")
+}
+
+// writeFlips lists checks whose outcome differs between the two results
+func writeFlips(b *strings.Builder, base, candidate *Result) {
+	var regressions, improvements []string
+	for i, scenario := range base.Scenarios {
+		for j, turn := range scenario.Turns {
+			other := candidate.Scenarios[i].Turns[j]
+			for k, check := range turn.Checks {
+				if k >= len(other.Checks) || check.Pass == other.Checks[k].Pass {
+					continue
+				}
+				line := fmt.Sprintf("- %s, turn %d: %s", scenario.Name, j+1, check.Name)
+				if check.Pass {
+					regressions = append(regressions, line)
+				} else {
+					improvements = append(improvements, line)
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(b, "## Regressions in %s (%d)\n\n", candidate.Variant.Name, len(regressions))
+	for _, line := range regressions {
+		b.WriteString(line + "\n")
+	}
+	fmt.Fprintf(b, "\n## Improvements in %s (%d)\n\n", candidate.Variant.Name, len(improvements))
+	for _, line := range improvements {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+}
+
+func sameTurn(a, b TurnResult) bool {
+	if a.Text() != b.Text() || len(a.ToolCalls) != len(b.ToolCalls) || len(a.Checks) != len(b.Checks) {
+		return false
+	}
+	for i := range a.Checks {
+		if a.Checks[i].Pass != b.Checks[i].Pass {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(text string) string {
+	return strings.ReplaceAll(strings.TrimSpace(text), "\n", "\n> ")
+}
+
+func orDefault(value string) string {
+	if value == "" {
+		return "(config)"
+	}
+	return value
+}
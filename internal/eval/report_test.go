@@ -0,0 +1,83 @@
+package eval
+
+import (
+	"strings"
+	"testing"
+)
+
+func result(name string, replies []string, passes []bool) *Result {
+	scenario := ScenarioResult{Name: "greeting"}
+	for i, reply := range replies {
+		scenario.Turns = append(scenario.Turns, TurnResult{
+			User:    "hi",
+			Replies: []string{reply},
+			Checks:  []Check{{Name: "says hello", Pass: passes[i]}},
+		})
+	}
+	return &Result{Variant: Variant{Name: name}, Scenarios: []ScenarioResult{scenario}}
+}
+
+func TestWriteReport(t *testing.T) {
+	base := result("A", []string{"Hello", "Hello again", "Bye"}, []bool{true, false, true})
+
+	tests := []struct {
+		name      string
+		results   []*Result
+		contains  []string
+		excludes  []string
+		wantError bool
+	}{
+		{
+			name:     "single result",
+			results:  []*Result{base},
+			contains: []string{"| A | (config) | (config) | 2/3 |", "### Turn 1\n", "- ✅ says hello", "- ❌ says hello"},
+			excludes: []string{"Regressions", "**changed**"},
+		},
+		{
+			name:    "comparison",
+			results: []*Result{base, result("B", []string{"Hello", "Hi again", "Goodbye"}, []bool{true, true, false})},
+			contains: []string{
+				"| B | (config) | (config) | 2/3 |",
+				"## Regressions in B (1)\n\n- greeting, turn 3: says hello\n",
+				"## Improvements in B (1)\n\n- greeting, turn 2: says hello\n",
+				"### Turn 1\n",
+				"### Turn 2 — **changed**",
+				"### Turn 3 — **changed**",
+			},
+		},
+		{
+			name:     "unchanged comparison",
+			results:  []*Result{base, result("B", []string{"Hello", "Hello again", "Bye"}, []bool{true, false, true})},
+			contains: []string{"## Regressions in B (0)", "## Improvements in B (0)"},
+			excludes: []string{"**changed**"},
+		},
+		{name: "no results", wantError: true},
+		{name: "too many results", results: []*Result{base, base, base}, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			err := WriteReport(&b, tt.results...)
+			if tt.wantError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteReport: %v", err)
+			}
+			report := b.String()
+			for _, want := range tt.contains {
+				if !strings.Contains(report, want) {
+					t.Errorf("report lacks %q:\n%s", want, report)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(report, unwanted) {
+					t.Errorf("report contains %q:\n%s", unwanted, report)
+				}
+			}
+		})
+	}
+}
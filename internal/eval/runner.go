@@ -0,0 +1,277 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/handlers"
+	"example-tool-call/internal/services/cache"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/guard"
+	"example-tool-call/internal/services/messenger"
+	"example-tool-call/internal/services/moderation"
+	openaiService "example-tool-call/internal/services/openai"
+	"example-tool-call/internal/services/persona"
+	"example-tool-call/internal/services/router"
+	"example-tool-call/internal/services/tools"
+	"github.com/sirupsen/logrus"
+)
+
+// Variant is a model and prompt configuration under evaluation. Empty
+// fields keep the values from the loaded configuration.
+type Variant struct {
+	Name         string
+	Model        string
+	PersonaFile  string
+	SystemPrompt string
+}
+
+// Runner replays scenarios through the real message pipeline
+type Runner struct {
+	cfg        *config.Config
+	judge      *openaiService.Service
+	judgeModel string
+	liveTools  bool
+	logger     *logrus.Logger
+}
+
+func NewRunner(cfg *config.Config, judgeModel string, liveTools bool, logger *logrus.Logger) (*Runner, error) {
+	if judgeModel == "" {
+		judgeModel = cfg.OpenAI.Model
+	}
+	judge, err := openaiService.New(cfg.OpenAI, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{
+		cfg:        cfg,
+		judge:      judge,
+		judgeModel: judgeModel,
+		liveTools:  liveTools,
+		logger:     logger,
+	}, nil
+}
+
+// Run replays every scenario with the variant's configuration. Each
+// scenario starts from an empty database so history does not leak between
+// scenarios.
+func (r *Runner) Run(ctx context.Context, variant Variant, scenarios []Scenario) (*Result, error) {
+	result := &Result{Variant: variant}
+	for _, scenario := range scenarios {
+		scenarioResult, err := r.runScenario(ctx, variant, scenario)
+		if err != nil {
+			return nil, fmt.Errorf("scenario %q: %w", scenario.Name, err)
+		}
+		result.Scenarios = append(result.Scenarios, *scenarioResult)
+	}
+	return result, nil
+}
+
+func (r *Runner) runScenario(ctx context.Context, variant Variant, scenario Scenario) (*ScenarioResult, error) {
+	dir, err := os.MkdirTemp("", "bot-eval-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := database.New("sqlite://" + filepath.Join(dir, "eval.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	out := &sink{}
+	recorder := &toolRecorder{}
+	handler, err := r.buildHandler(variant, db, out, recorder)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ScenarioResult{Name: scenario.Name}
+	for _, turn := range scenario.Turns {
+		out.reset()
+		recorder.reset()
+
+		err := handler.ProcessMessage(messenger.Inbound{
+			Gateway: "eval",
+			Device:  "eval",
+			Sender:  scenario.Sender,
+			Name:    scenario.SenderName,
+			Message: turn.User,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s: %w", scenario.Name, err)
+		}
+
+		turnResult := TurnResult{
+			User:      turn.User,
+			Recorded:  turn.Recorded,
+			Replies:   out.messages(),
+			Images:    out.images(),
+			ToolCalls: recorder.calls(),
+		}
+		turnResult.Checks = r.check(ctx, turn, turnResult)
+		result.Turns = append(result.Turns, turnResult)
+	}
+	return result, nil
+}
+
+// buildHandler wires the services the way cmd/bot does, with the variant's
+// overrides applied, the response cache disabled and replies captured
+func (r *Runner) buildHandler(variant Variant, db *database.DB, out messenger.Messenger, recorder *toolRecorder) (*handlers.Handler, error) {
+	cfg := *r.cfg
+	cfg.Cache.Enabled = false
+	if variant.Model != "" {
+		// An explicit model must not be rerouted
+		cfg.OpenAI.Model = variant.Model
+		cfg.Router.Enabled = false
+	}
+	if variant.PersonaFile != "" {
+		cfg.Persona.File = variant.PersonaFile
+	}
+	if variant.SystemPrompt != "" {
+		cfg.Persona.SystemPrompt = variant.SystemPrompt
+	}
+
+	openai, err := openaiService.New(cfg.OpenAI, db, r.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	toolManager := tools.NewManager(db, r.logger)
+	var imageTool tools.Tool = tools.NewStubImageGenerationTool()
+	if r.liveTools {
+		imageTool = tools.NewImageGenerationTool(cfg.Image.APIKey, r.logger)
+	}
+	toolManager.RegisterTool(recorder.wrap(imageTool))
+
+	personas, err := persona.New(cfg.Persona.File, cfg.Persona.SystemPrompt, cfg.Persona.Timezone, r.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	modelRouter, err := router.New(cfg.Router, cfg.OpenAI.Model, openai, r.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	moderationService, err := moderation.New(cfg.Moderation, openai, nil, db, r.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return handlers.NewHandler(handlers.Deps{
+		DB:         db,
+		Messenger:  out,
+		Outbox:     out,
+		OpenAI:     openai,
+		Tools:      toolManager,
+		Personas:   personas,
+		Router:     modelRouter,
+		Streaming:  cfg.Streaming,
+		Moderation: moderationService,
+		Guard:      guard.New(cfg.Guard, db, r.logger),
+		Language:   cfg.Language,
+		Groups:     cfg.Group,
+		Cache:      cache.New(cfg.Cache, openai, db, r.logger),
+		Logger:     r.logger,
+	}), nil
+}
+
+// Image is an image the bot sent
+type Image struct {
+	URL     string `json:"url"`
+	Caption string `json:"caption"`
+}
+
+// sink captures outgoing messages instead of sending them to WhatsApp
+type sink struct {
+	mu   sync.Mutex
+	text []string
+	imgs []Image
+}
+
+func (s *sink) Name() string {
+	return "eval"
+}
+
+func (s *sink) SendText(ctx context.Context, to, text string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.text = append(s.text, text)
+	return fmt.Sprintf("eval_%d", len(s.text)), nil
+}
+
+func (s *sink) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.imgs = append(s.imgs, Image{URL: media.URL, Caption: media.Caption})
+	return fmt.Sprintf("eval_image_%d", len(s.imgs)), nil
+}
+
+func (s *sink) ParseWebhook(r *http.Request) ([]messenger.Inbound, error) {
+	return nil, messenger.ErrNoWebhook
+}
+
+func (s *sink) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.text, s.imgs = nil, nil
+}
+
+func (s *sink) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.text...)
+}
+
+func (s *sink) images() []Image {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Image(nil), s.imgs...)
+}
+
+// ToolCall is a tool the bot executed
+type ToolCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+// toolRecorder remembers the tool calls made during a turn
+type toolRecorder struct {
+	mu   sync.Mutex
+	list []ToolCall
+}
+
+func (r *toolRecorder) wrap(tool tools.Tool) tools.Tool {
+	return recordingTool{Tool: tool, recorder: r}
+}
+
+func (r *toolRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.list = nil
+}
+
+func (r *toolRecorder) calls() []ToolCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ToolCall(nil), r.list...)
+}
+
+type recordingTool struct {
+	tools.Tool
+	recorder *toolRecorder
+}
+
+func (t recordingTool) Execute(ctx context.Context, parameters map[string]interface{}) (interface{}, error) {
+	t.recorder.mu.Lock()
+	t.recorder.list = append(t.recorder.list, ToolCall{Name: t.Name(), Args: parameters})
+	t.recorder.mu.Unlock()
+	return t.Tool.Execute(ctx, parameters)
+}
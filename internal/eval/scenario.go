@@ -0,0 +1,155 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"example-tool-call/internal/services/database"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultSender = "6280000000000"
+
+// Scenario is a scripted conversation replayed through the bot
+type Scenario struct {
+	Name       string `yaml:"name"`
+	Sender     string `yaml:"sender"`
+	SenderName string `yaml:"sender_name"`
+	Turns      []Turn `yaml:"turns"`
+}
+
+// Turn is one user message and what the bot's answer must satisfy
+type Turn struct {
+	User   string `yaml:"user"`
+	Expect Expect `yaml:"expect"`
+	// Recorded is the reply stored in the database for replayed
+	// conversations, shown in the report for comparison
+	Recorded string `yaml:"-"`
+}
+
+// Expect lists the assertions for a turn. Every set field must hold.
+type Expect struct {
+	Contains    []string         `yaml:"contains"`
+	NotContains []string         `yaml:"not_contains"`
+	Regex       []string         `yaml:"regex"`
+	Tool        *ToolExpectation `yaml:"tool"`
+	NoTool      bool             `yaml:"no_tool"`
+	// Judge is a rubric graded by a language model
+	Judge string `yaml:"judge"`
+}
+
+// ToolExpectation requires a tool call by name whose arguments include
+// Args. String arguments match case-insensitively as substrings.
+type ToolExpectation struct {
+	Name string                 `yaml:"name"`
+	Args map[string]interface{} `yaml:"args"`
+}
+
+// LoadScenarios reads scenario files. A path may be a YAML file, which can
+// hold several documents, or a directory of .yaml/.yml files.
+func LoadScenarios(paths ...string) ([]Scenario, error) {
+	var scenarios []Scenario
+	for _, path := range paths {
+		files, err := scenarioFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			loaded, err := loadScenarioFile(file)
+			if err != nil {
+				return nil, err
+			}
+			scenarios = append(scenarios, loaded...)
+		}
+	}
+	return scenarios, nil
+}
+
+func scenarioFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenarios: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func loadScenarioFile(path string) ([]Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scenario file: %w", err)
+	}
+	defer f.Close()
+
+	var scenarios []Scenario
+	decoder := yaml.NewDecoder(f)
+	for {
+		var scenario Scenario
+		if err := decoder.Decode(&scenario); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if len(scenario.Turns) == 0 {
+			continue
+		}
+
+		if scenario.Name == "" {
+			scenario.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			if len(scenarios) > 0 {
+				scenario.Name = fmt.Sprintf("%s #%d", scenario.Name, len(scenarios)+1)
+			}
+		}
+		if scenario.Sender == "" {
+			scenario.Sender = defaultSender
+		}
+		scenarios = append(scenarios, scenario)
+	}
+	return scenarios, nil
+}
+
+// ReplayConversation turns the last limit stored messages with jid into a
+// scenario without assertions. The bot's original replies are kept for
+// comparison in the report.
+func ReplayConversation(db *database.DB, jid string, limit int) (Scenario, error) {
+	messages, err := db.GetMessages(jid, limit)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("failed to load conversation %s: %w", jid, err)
+	}
+
+	scenario := Scenario{Name: "replay " + jid, Sender: jid}
+	// Messages come newest first
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if !msg.IsFromMe {
+			scenario.Turns = append(scenario.Turns, Turn{User: msg.Content})
+			continue
+		}
+		if n := len(scenario.Turns); n > 0 {
+			turn := &scenario.Turns[n-1]
+			turn.Recorded = strings.TrimSpace(turn.Recorded + "\n" + msg.Content)
+		}
+	}
+
+	if len(scenario.Turns) == 0 {
+		return Scenario{}, fmt.Errorf("no stored messages from %s", jid)
+	}
+	return scenario, nil
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"strings"
+	"unicode"
+
+	"example-tool-call/internal/services/messenger"
+)
+
+// Group policies
+const (
+	groupPolicyTriggered = "triggered" // answer mentions, prefixes and replies to the bot
+	groupPolicyAll       = "all"
+	groupPolicyIgnore    = "ignore"
+)
+
+// addressedInGroup reports whether a group message is meant for the bot
+// under the group policy, and returns its text with the mention or command
+// prefix that addressed the bot removed
+func (h *Handler) addressedInGroup(msg messenger.Inbound) (string, bool) {
+	switch h.groups.Policy {
+	case groupPolicyAll:
+		return msg.Message, true
+	case groupPolicyIgnore:
+		return msg.Message, false
+	}
+
+	text := strings.TrimSpace(msg.Message)
+	for _, prefix := range h.groups.Prefixes {
+		if rest, ok := cutPrefix(text, prefix); ok {
+			return keepText(rest, msg.Message), true
+		}
+	}
+
+	if h.groups.Mention {
+		if rest, ok := h.cutMention(text, msg.Device); ok {
+			return keepText(rest, msg.Message), true
+		}
+		if containsNumber(msg.Mentions, msg.Device) {
+			return msg.Message, true
+		}
+	}
+
+	if h.groups.Reply && h.repliesToBot(msg) {
+		return msg.Message, true
+	}
+	return msg.Message, false
+}
+
+// cutMention removes the first "@number" or "@name" naming the bot
+func (h *Handler) cutMention(text, device string) (string, bool) {
+	handles := h.mentionHandles(device)
+	for i := 0; i < len(text); i++ {
+		if text[i] != '@' {
+			continue
+		}
+		for _, handle := range handles {
+			end := i + 1 + len(handle)
+			if end <= len(text) && strings.EqualFold(text[i+1:end], handle) && atBoundary(text, end) {
+				before, after := strings.TrimSpace(text[:i]), strings.TrimSpace(text[end:])
+				if before == "" || after == "" {
+					return before + after, true
+				}
+				return before + " " + after, true
+			}
+		}
+	}
+	return text, false
+}
+
+// mentionHandles are the handles that mention the bot after an
+// "@": its number, when the gateway reports it, and the configured names
+func (h *Handler) mentionHandles(device string) []string {
+	handles := make([]string, 0, len(h.groups.Names)+1)
+	if number := messenger.Number(device); number != "" {
+		handles = append(handles, number)
+	}
+	for _, name := range h.groups.Names {
+		if name = strings.TrimPrefix(strings.TrimSpace(name), "@"); name != "" {
+			handles = append(handles, name)
+		}
+	}
+	return handles
+}
+
+// repliesToBot reports whether the message quotes one the bot sent
+func (h *Handler) repliesToBot(msg messenger.Inbound) bool {
+	if msg.QuotedSender != "" && msg.Device != "" && messenger.Number(msg.QuotedSender) == messenger.Number(msg.Device) {
+		return true
+	}
+	if msg.QuotedID == "" {
+		return false
+	}
+	sent, err := h.db.SentByBot(msg.QuotedID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to look up quoted message")
+	}
+	return sent
+}
+
+// cutPrefix removes a case-insensitive command prefix that is followed by a
+// space or the end of the text
+func cutPrefix(text, prefix string) (string, bool) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" || len(text) < len(prefix) || !strings.EqualFold(text[:len(prefix)], prefix) {
+		return text, false
+	}
+	if !atBoundary(text, len(prefix)) {
+		return text, false
+	}
+	return strings.TrimSpace(text[len(prefix):]), true
+}
+
+// atBoundary reports whether position i ends a word in text
+func atBoundary(text string, i int) bool {
+	if i >= len(text) {
+		return true
+	}
+	r := rune(text[i])
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+}
+
+// keepText falls back to the original text when removing the mention or
+// prefix leaves nothing, so "@bot" alone still reaches the model
+func keepText(text, original string) string {
+	if strings.TrimSpace(text) == "" {
+		return original
+	}
+	return text
+}
+
+// containsNumber reports whether any JID or number in list is number
+func containsNumber(list []string, number string) bool {
+	number = messenger.Number(number)
+	if number == "" {
+		return false
+	}
+	for _, item := range list {
+		if messenger.Number(item) == number {
+			return true
+		}
+	}
+	return false
+}
+
+// withAuthor prefixes a group member's message with their name, so the
+// model can tell members apart in the shared history
+func withAuthor(content, name, member string) string {
+	if member == "" {
+		return content
+	}
+	if name == "" {
+		name = member
+	}
+	return name + ": " + content
+}
@@ -1,18 +1,37 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"example-tool-call/internal/config"
 	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/cache"
 	"example-tool-call/internal/services/database"
-	"example-tool-call/internal/services/fonnte"
+	"example-tool-call/internal/services/guard"
+	"example-tool-call/internal/services/handoff"
+	"example-tool-call/internal/services/i18n"
+	"example-tool-call/internal/services/media"
+	"example-tool-call/internal/services/messenger"
+	"example-tool-call/internal/services/moderation"
 	openaiService "example-tool-call/internal/services/openai"
+	"example-tool-call/internal/services/outbox"
+	"example-tool-call/internal/services/persona"
+	"example-tool-call/internal/services/queue"
+	"example-tool-call/internal/services/router"
 	"example-tool-call/internal/services/tools"
+	"example-tool-call/internal/services/voice"
+	"example-tool-call/internal/services/webhookauth"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sashabaranov/go-openai"
@@ -20,20 +39,101 @@ import (
 )
 
 type Handler struct {
-	db      *database.DB
-	fonnte  *fonnte.Service
-	openai  *openaiService.Service
-	toolMgr *tools.Manager
-	logger  *logrus.Logger
+	db          *database.DB
+	messenger   messenger.Messenger
+	outbox      messenger.Sender
+	openai      *openaiService.Service
+	toolMgr     *tools.Manager
+	personas    *persona.Service
+	router      *router.Router
+	streaming   config.StreamingConfig
+	moderation  *moderation.Service
+	guard       *guard.Service
+	language    config.LanguageConfig
+	groups      config.GroupConfig
+	cache       *cache.Service
+	voice       *voice.Service
+	media       *media.Service
+	handoff     *handoff.Service
+	webhookAuth *webhookauth.Service
+	queue       *queue.Service
+	statsToken  string
+	logger      *logrus.Logger
 }
 
-func NewHandler(db *database.DB, fonnte *fonnte.Service, openai *openaiService.Service, toolMgr *tools.Manager, logger *logrus.Logger) *Handler {
+// turn identifies the message being answered and how to answer it
+type turn struct {
+	sender    string
+	messageID string
+	replyID   string
+	language  string
+	// spoken is set when the user sent a voice note
+	spoken bool
+	// name is the sender's display name; member and memberName identify
+	// the author of a group message
+	name       string
+	member     string
+	memberName string
+}
+
+func (t turn) subject() moderation.Subject {
+	return moderation.Subject{Sender: t.sender, MessageID: t.messageID}
+}
+
+func (t turn) guardSubject() guard.Subject {
+	return guard.Subject{Sender: t.sender, MessageID: t.messageID}
+}
+
+// Deps are the services a Handler uses. Voice, Media, Handoff, WebhookAuth
+// and Queue are optional and may be nil.
+type Deps struct {
+	DB *database.DB
+	// Messenger receives media and status updates; replies go through Outbox
+	Messenger   messenger.Messenger
+	Outbox      messenger.Sender
+	OpenAI      *openaiService.Service
+	Tools       *tools.Manager
+	Personas    *persona.Service
+	Router      *router.Router
+	Streaming   config.StreamingConfig
+	Moderation  *moderation.Service
+	Guard       *guard.Service
+	Language    config.LanguageConfig
+	Groups      config.GroupConfig
+	Cache       *cache.Service
+	Voice       *voice.Service
+	Media       *media.Service
+	Handoff     *handoff.Service
+	WebhookAuth *webhookauth.Service
+	Queue       *queue.Service
+	// StatsToken is the bearer token /stats requires; with one set, usage
+	// is also reported per sender
+	StatsToken string
+	Logger     *logrus.Logger
+}
+
+func NewHandler(deps Deps) *Handler {
 	return &Handler{
-		db:      db,
-		fonnte:  fonnte,
-		openai:  openai,
-		toolMgr: toolMgr,
-		logger:  logger,
+		db:          deps.DB,
+		messenger:   deps.Messenger,
+		outbox:      deps.Outbox,
+		openai:      deps.OpenAI,
+		toolMgr:     deps.Tools,
+		personas:    deps.Personas,
+		router:      deps.Router,
+		streaming:   deps.Streaming,
+		moderation:  deps.Moderation,
+		guard:       deps.Guard,
+		language:    deps.Language,
+		groups:      deps.Groups,
+		cache:       deps.Cache,
+		voice:       deps.Voice,
+		media:       deps.Media,
+		handoff:     deps.Handoff,
+		webhookAuth: deps.WebhookAuth,
+		queue:       deps.Queue,
+		statsToken:  deps.StatsToken,
+		logger:      deps.Logger,
 	}
 }
 
@@ -46,6 +146,18 @@ func (h *Handler) Health(c *gin.Context) {
 }
 
 func (h *Handler) Stats(c *gin.Context) {
+	// Usage per sender identifies customers, so it is only reported when
+	// the endpoint is protected
+	usageGroups := []string{"day", "model"}
+	if h.statsToken != "" {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !hmac.Equal([]byte(token), []byte(h.statsToken)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		usageGroups = append(usageGroups, "sender")
+	}
+
 	// Get basic statistics
 	var messageCount int64
 	var conversationCount int64
@@ -59,43 +171,351 @@ func (h *Handler) Stats(c *gin.Context) {
 		"messages":        messageCount,
 		"conversations":   conversationCount,
 		"tool_executions": toolExecutionCount,
+		"llm_usage":       h.llmUsageStats(c, usageGroups...),
+		"response_cache":  h.cache.Stats(),
+		"voice":           h.voice.Stats(),
+		"media":           h.media.Stats(),
+		"handoff":         h.handoff.Stats(),
+		"webhook_auth":    h.webhookAuth.Stats(),
+		"queue":           h.queue.Stats(),
+		"outbox":          h.outboxStats(),
+		"delivery":        h.deliveryStats(c),
 		"timestamp":       time.Now().UTC(),
 	})
 }
 
-func (h *Handler) FontteWebhook(c *gin.Context) {
-	var webhook fonnte.WebhookMessage
-	if err := c.ShouldBindJSON(&webhook); err != nil {
-		h.logger.WithError(err).Error("Failed to parse webhook")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+// statsWindow reads the `days` query parameter (default 30)
+func statsWindow(c *gin.Context) (int, time.Time) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return days, time.Now().AddDate(0, 0, -days)
+}
+
+// deliveryStats reports how many replies sent over the last `days` days
+// were delivered and read
+func (h *Handler) deliveryStats(c *gin.Context) gin.H {
+	_, since := statsWindow(c)
+	totals, err := h.db.GetDeliveryTotals(since)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get delivery totals")
+	}
+
+	rate := func(n int64) float64 {
+		if totals.Sent == 0 {
+			return 0
+		}
+		return float64(n) / float64(totals.Sent)
+	}
+	return gin.H{
+		"totals":        totals,
+		"delivery_rate": rate(totals.Delivered),
+		"read_rate":     rate(totals.Read),
+		"failure_rate":  rate(totals.Failed),
+	}
+}
+
+// outboxStats reports background delivery when replies go through the outbox
+func (h *Handler) outboxStats() outbox.Stats {
+	if o, ok := h.outbox.(*outbox.Service); ok {
+		return o.Stats()
+	}
+	return outbox.Stats{}
+}
+
+// llmUsageStats reports token usage and cost over the last `days` days
+// (default 30) in total and broken down per group (day, model or sender)
+func (h *Handler) llmUsageStats(c *gin.Context, groups ...string) gin.H {
+	days, since := statsWindow(c)
+	stats := gin.H{"days": days}
+
+	totals, err := h.db.GetUsageTotals(since)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get LLM usage totals")
+	}
+	stats["total"] = totals
+
+	for _, group := range groups {
+		usage, err := h.db.GetUsageBy(group, since)
+		if err != nil {
+			h.logger.WithError(err).WithField("group", group).Error("Failed to get LLM usage")
+		}
+		stats["by_"+group] = usage
+	}
+
+	return stats
+}
+
+// Webhook receives inbound messages from the configured gateway
+func (h *Handler) Webhook(c *gin.Context) {
+	// Gateways such as Meta report delivery statuses on the message
+	// webhook, so keep the body for a second parse
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
 		return
 	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
 
-	h.logger.WithFields(logrus.Fields{
-		"sender":  webhook.Sender,
-		"message": webhook.Message,
-		"device":  webhook.Device,
-	}).Info("Received Fonnte webhook")
+	inbound, err := h.messenger.ParseWebhook(c.Request)
+	if err != nil {
+		h.logger.WithError(err).WithField("gateway", h.messenger.Name()).Error("Failed to parse webhook")
+		if errors.Is(err, messenger.ErrInvalidSignature) {
+			h.webhookAuth.Reject(c.Request, c.ClientIP(), "", webhookauth.ReasonInvalidSignature, "gateway="+h.messenger.Name())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
 
-	// Process the message
-	go h.processMessage(webhook.Sender, webhook.Message)
+	h.webhookAuth.Accept()
+	if parser, ok := h.messenger.(messenger.StatusParser); ok {
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		statuses, err := parser.ParseStatus(c.Request)
+		if err != nil {
+			h.logger.WithError(err).Warn("Failed to parse statuses in webhook")
+		}
+		for _, status := range statuses {
+			h.RecordStatus(status)
+		}
+	}
+
+	accepted := inbound[:0]
+	for _, msg := range inbound {
+		if !h.webhookAuth.AllowDevice(c.Request, c.ClientIP(), msg.Device, msg.Sender) {
+			continue
+		}
+
+		h.logger.WithFields(logrus.Fields{
+			"gateway": h.messenger.Name(),
+			"type":    msg.Type,
+			"sender":  msg.Sender,
+			"member":  msg.Member,
+			"message": msg.Message,
+			"device":  msg.Device,
+		}).Info("Received webhook")
+		accepted = append(accepted, msg)
+	}
+
+	// Persist before acknowledging so the gateway retries if this fails
+	if err := h.queue.Enqueue(accepted...); err != nil {
+		h.logger.WithError(err).Error("Failed to queue webhook messages")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue message"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "received"})
 }
 
-func (h *Handler) processMessage(sender, message string) {
-	ctx := context.Background()
+// StatusWebhook receives delivery status callbacks from gateways that send
+// them to their own URL, such as Fonnte
+func (h *Handler) StatusWebhook(c *gin.Context) {
+	parser, ok := h.messenger.(messenger.StatusParser)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gateway does not report statuses"})
+		return
+	}
+
+	statuses, err := parser.ParseStatus(c.Request)
+	if err != nil {
+		h.logger.WithError(err).WithField("gateway", h.messenger.Name()).Error("Failed to parse status webhook")
+		if errors.Is(err, messenger.ErrInvalidSignature) {
+			h.webhookAuth.Reject(c.Request, c.ClientIP(), "", webhookauth.ReasonInvalidSignature, "gateway="+h.messenger.Name())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	h.webhookAuth.Accept()
+	for _, status := range statuses {
+		h.RecordStatus(status)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "received"})
+}
+
+// RecordStatus stores a delivery update for a message the bot sent.
+// Updates for messages the bot did not send, such as ones typed on the
+// phone, are ignored.
+func (h *Handler) RecordStatus(status messenger.Status) {
+	switch status.State {
+	case messenger.StateSent, messenger.StateDelivered, messenger.StateRead, messenger.StateFailed:
+	default:
+		return
+	}
+
+	matched, err := h.db.RecordMessageStatus(&models.MessageStatus{
+		ProviderID:   status.ProviderID,
+		RecipientJID: status.Recipient,
+		Status:       string(status.State),
+		Error:        status.Error,
+		Timestamp:    status.Timestamp,
+	})
+	logger := h.logger.WithFields(logrus.Fields{
+		"provider_id": status.ProviderID,
+		"state":       status.State,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to record message status")
+		return
+	}
+	if !matched {
+		logger.Debug("Ignoring status for unknown message")
+		return
+	}
+	if status.State == messenger.StateFailed {
+		logger.WithField("error", status.Error).Warn("Gateway reported a failed delivery")
+	}
+}
+
+// VerifyWebhook answers the subscription challenge of gateways that send
+// one before delivering webhooks
+func (h *Handler) VerifyWebhook(c *gin.Context) {
+	verifier, ok := h.messenger.(messenger.Verifier)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	challenge, ok := verifier.VerifyWebhook(c.Request.URL.Query())
+	if !ok {
+		h.logger.WithField("gateway", h.messenger.Name()).Warn("Rejected webhook verification")
+		c.Status(http.StatusForbidden)
+		return
+	}
+	c.String(http.StatusOK, challenge)
+}
+
+// ProcessMessage answers an incoming message and returns once every reply
+// has been sent. It only fails before anything was sent, so the queue can
+// safely retry it.
+func (h *Handler) ProcessMessage(msg messenger.Inbound) error {
+	if msg.Type == "" {
+		msg.Type = messenger.TypeText
+	}
+	sender, message := msg.Sender, inboundContent(msg)
 
 	// Skip empty messages
 	if strings.TrimSpace(message) == "" {
-		return
+		return nil
+	}
+
+	// Provider IDs survive redelivery, so the unique message ID catches
+	// duplicates that slip past the queue
+	userMessageID := fmt.Sprintf("user_%d", time.Now().UnixNano())
+	if msg.ID != "" {
+		userMessageID = "user_" + msg.ID
+	}
+	replyID := fmt.Sprintf("assistant_%d", time.Now().UnixNano())
+	ctx := openaiService.WithCallMeta(context.Background(), openaiService.CallMeta{
+		MessageID: userMessageID,
+		Sender:    sender,
+	})
+	// Replies count against the rate limit of the device that received the
+	// message and are linked to the assistant message once delivered. The
+	// gateway sends them from its configured account.
+	ctx = outbox.WithReply(ctx, outbox.Reply{MessageID: replyID, Device: msg.Device})
+
+	// Messages from operators are commands or replies to handed-off users
+	if h.handoff.IsOperator(sender) {
+		if reply := h.handoff.HandleOperator(ctx, sender, msg); reply != "" {
+			h.sendTextMessage(ctx, sender, reply)
+		}
+		return nil
 	}
 
 	// Get or create conversation
 	conversation, err := h.db.GetOrCreateConversation(sender)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to get conversation")
-		return
+		return fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	t := turn{
+		sender:    sender,
+		messageID: userMessageID,
+		replyID:   replyID,
+		language:  h.replyLanguage(conversation, msg.Message),
+		name:      msg.Name,
+	}
+
+	if msg.IsGroup() {
+		t.member, t.memberName = msg.Member, msg.Name
+
+		// Only answer group messages addressed to the bot; the rest are
+		// kept as context
+		text, addressed := h.addressedInGroup(msg)
+		if !addressed {
+			if h.groups.History {
+				h.recordUserMessage(t, conversation, msg.Type, message)
+			}
+			return nil
+		}
+		msg.Message = text
+		message = inboundContent(msg)
+	}
+
+	// While a human is answering, messages are only recorded and forwarded
+	if conversation.Handoff && h.handoff.Enabled() {
+		h.recordUserMessage(t, conversation, msg.Type, message)
+		h.handoff.Forward(ctx, conversation, msg.Name, message)
+		return nil
+	}
+
+	// Keep a copy of attachments, since gateway links expire
+	var attachment *models.Media
+	if msg.MediaURL != "" || msg.MediaID != "" {
+		attachment = h.storeAttachment(ctx, t, msg)
+	}
+
+	switch msg.Type {
+	case messenger.TypeText:
+		// Let the user pick a model or language for this conversation
+		if reply, ok := h.handleModelCommand(conversation, t.language, message); ok {
+			h.sendTextMessage(ctx, sender, reply)
+			return nil
+		}
+		if reply, ok := h.handleLanguageCommand(conversation, t.language, message); ok {
+			h.sendTextMessage(ctx, sender, reply)
+			return nil
+		}
+		if reply, ok := h.handleFactCommand(personaTarget(msg).User, t.language, message); ok {
+			h.sendTextMessage(ctx, sender, reply)
+			return nil
+		}
+		// Hand the conversation to a human when the user asks for one
+		if h.handoff.Triggered(message) && h.startHandoff(ctx, t, handoff.SourceKeyword, message) {
+			h.recordUserMessage(t, conversation, msg.Type, message)
+			return nil
+		}
+	case messenger.TypeAudio:
+		if !h.voice.Enabled() {
+			h.replyUnsupported(ctx, t, conversation, msg.Type, message, i18n.UnsupportedAudio)
+			return nil
+		}
+		transcript, ok := h.transcribe(ctx, msg, attachment)
+		if !ok {
+			h.replyUnsupported(ctx, t, conversation, msg.Type, message, i18n.ErrTranscription)
+			return nil
+		}
+		// The transcript stands in for the audio from here on, so the turn
+		// is routed and cached like text
+		msg.Message = transcript
+		msg.MediaURL, msg.MediaID = "", ""
+		message = inboundContent(msg)
+		t.language = h.replyLanguage(conversation, transcript)
+		t.spoken = true
+	case messenger.TypeUnsupported:
+		h.replyUnsupported(ctx, t, conversation, msg.Type, message, i18n.UnsupportedMessage)
+		return nil
+	}
+
+	// Screen the incoming message before it reaches the model
+	if h.moderation.Check(ctx, moderation.StageInput, t.subject(), message).Blocked() {
+		h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.BlockedInput))
+		return nil
 	}
 
 	// Get recent messages for context
@@ -106,10 +526,12 @@ func (h *Handler) processMessage(sender, message string) {
 	}
 
 	// Build conversation history
+	target := personaTarget(msg)
+	p := h.personas.Resolve(target)
 	messages := []openaiService.ChatMessage{
 		{
 			Role:    "system",
-			Content: "You are a helpful WhatsApp AI assistant. You can generate images when requested. Be friendly and helpful.",
+			Content: h.systemPrompt(msg, target, p, t.language),
 		},
 	}
 
@@ -120,82 +542,487 @@ func (h *Handler) processMessage(sender, message string) {
 		if msg.IsFromMe {
 			role = "assistant"
 		}
-		messages = append(messages, openaiService.ChatMessage{
-			Role:    role,
-			Content: msg.Content,
-		})
+		// Handoff summaries tell the model what a human agent did
+		if msg.MessageType == handoff.SummaryType {
+			role = "system"
+		}
+		content := withAuthor(msg.Content, msg.MemberName, msg.MemberJID)
+		if role == "user" {
+			messages = append(messages, userMessage(content, messenger.MessageType(msg.MessageType)))
+		} else {
+			messages = append(messages, openaiService.ChatMessage{Role: role, Content: content})
+		}
 	}
 
 	// Add current message
-	messages = append(messages, openaiService.ChatMessage{
-		Role:    "user",
-		Content: message,
+	messages = append(messages, userMessage(withAuthor(message, t.memberName, t.member), msg.Type))
+
+	// Delimit untrusted content before it reaches the model
+	messages = h.guard.Prepare(t.guardSubject(), messages)
+
+	// Pick a model for this message
+	decision := h.router.Route(ctx, router.Request{
+		Text:     message,
+		HasMedia: msg.HasMedia(),
+		Override: conversation.ModelOverride,
 	})
+	h.logger.WithFields(logrus.Fields{
+		"sender": sender,
+		"tier":   decision.Tier,
+		"model":  decision.Model,
+		"reason": decision.Reason,
+	}).Debug("Routed message")
+
+	// Answer repeated questions from the cache when the turn involves no
+	// media, tools or untrusted content
+	var lookup *cache.Lookup
+	if h.cacheable(msg, messages) {
+		key := cache.Key{
+			Persona:  p.Name,
+			Template: p.Prompt,
+			Model:    decision.Model,
+			Language: t.language,
+		}
+		if len(recentMessages) > 0 {
+			key.LastMessage = recentMessages[0].Timestamp
+		}
+		lookup = h.cache.Lookup(ctx, key, message)
+	}
+
+	// Save the assistant message before any part of the reply is queued, so
+	// the outbox and delivery updates always find it. The content is filled
+	// in once the reply is complete.
+	received := time.Now()
+	assistantMsg := &models.Message{
+		MessageID:   t.replyID,
+		FromJID:     "bot",
+		ToJID:       sender,
+		MessageType: "text",
+		IsFromMe:    true,
+		Model:       decision.Model,
+		RouteReason: decision.Reason,
+		Timestamp:   time.Now(),
+	}
+	if lookup.Hit() {
+		assistantMsg.RouteReason = "cache " + lookup.Kind
+	}
+	if err := h.db.SaveMessage(assistantMsg); err != nil {
+		h.logger.WithError(err).Error("Failed to save assistant message")
+	}
+
+	var reply string
+	if lookup.Hit() {
+		reply = lookup.Answer
+		decision.Reason = assistantMsg.RouteReason
+		h.sendReply(ctx, t, reply)
+	} else {
+		var calledTools, ok bool
+		reply, calledTools, ok = h.generateReply(ctx, t, decision, messages)
+		if !ok {
+			if err := h.db.DeleteMessage(t.replyID); err != nil {
+				h.logger.WithError(err).Error("Failed to delete unanswered assistant message")
+			}
+			return nil
+		}
+		if !calledTools {
+			lookup.Store(ctx, reply)
+		}
+	}
+
+	// Save user message
+	userMsg := &models.Message{
+		MessageID:   userMessageID,
+		FromJID:     sender,
+		ToJID:       "bot",
+		Content:     message,
+		MessageType: string(msg.Type),
+		IsFromMe:    false,
+		MemberJID:   t.member,
+		MemberName:  t.memberName,
+		Model:       decision.Model,
+		RouteReason: decision.Reason,
+		Timestamp:   received,
+	}
+	if err := h.db.SaveMessage(userMsg); err != nil {
+		h.logger.WithError(err).Error("Failed to save user message")
+	}
+
+	if err := h.db.SetMessageContent(t.replyID, reply, decision.Reason); err != nil {
+		h.logger.WithError(err).Error("Failed to save assistant message")
+	}
+
+	// Update conversation
+	conversation.LastMessage = message
+	conversation.MessageCount++
+	if err := h.db.UpdateConversation(conversation); err != nil {
+		h.logger.WithError(err).Error("Failed to update conversation")
+	}
+	return nil
+}
+
+// generateReply asks the model for an answer and delivers it, running any
+// tool calls it makes. It returns the assistant text and whether the model
+// tried to call tools; ok is false when no answer could be produced.
+func (h *Handler) generateReply(ctx context.Context, t turn, decision router.Decision, messages []openaiService.ChatMessage) (string, bool, bool) {
+	sender := t.sender
 
 	// Get available tools
 	tools := h.toolMgr.GetAvailableTools()
 
-	// Generate response
-	response, err := h.openai.GenerateResponse(ctx, messages, tools)
+	// Generate response, streaming text to the user as it arrives if enabled
+	var (
+		response *openai.ChatCompletionResponse
+		delivery *streamDelivery
+		blocked  bool
+		err      error
+	)
+	if h.streaming.Enabled {
+		streamCtx := outbox.Streamed(ctx)
+		delivery = newStreamDelivery(h.streaming, func(text string) {
+			// Parts go out as text only; the reply is spoken once at the end
+			if !h.sendReplyText(streamCtx, t, text) {
+				blocked = true
+			}
+		})
+		response, err = h.openai.GenerateResponseStream(ctx, decision.Model, messages, tools, delivery.Write)
+	} else {
+		response, err = h.openai.GenerateResponseWithModel(ctx, decision.Model, messages, tools)
+	}
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to generate response")
-		h.sendErrorMessage(sender, "Sorry, I'm having trouble processing your message right now.")
-		return
+		h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.ErrProcessing))
+		return "", false, false
 	}
 
 	if len(response.Choices) == 0 {
 		h.logger.Error("No response choices received")
-		h.sendErrorMessage(sender, "Sorry, I couldn't generate a response.")
-		return
+		h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.ErrNoResponse))
+		return "", false, false
 	}
 
 	choice := response.Choices[0]
-	
+	calledTools := len(choice.Message.ToolCalls) > 0
+
+	// Drop tool calls that untrusted content may have induced
+	if len(choice.Message.ToolCalls) > 0 {
+		choice.Message.ToolCalls = h.guard.FilterToolCalls(t.guardSubject(), messages, choice.Message.ToolCalls)
+		if len(choice.Message.ToolCalls) == 0 && strings.TrimSpace(choice.Message.Content) == "" {
+			choice.Message.Content = i18n.T(t.language, i18n.BlockedToolCall)
+			if delivery != nil {
+				delivery.Write(choice.Message.Content)
+			}
+		}
+	}
+
 	// Handle tool calls
 	if len(choice.Message.ToolCalls) > 0 {
-		h.handleToolCalls(ctx, sender, choice.Message.ToolCalls, choice.Message.Content)
+		assistantMessage := choice.Message.Content
+		if delivery != nil {
+			// Text already streamed out must not be repeated as a caption
+			assistantMessage = delivery.Pending()
+		}
+		h.handleToolCalls(ctx, t, choice.Message.ToolCalls, assistantMessage)
+	} else if delivery != nil {
+		delivery.Flush()
+		if !blocked {
+			h.speakReply(ctx, t, choice.Message.Content)
+		}
 	} else {
 		// Send regular text response
-		h.sendTextMessage(sender, choice.Message.Content)
+		h.sendReply(ctx, t, choice.Message.Content)
 	}
 
-	// Save user message
+	return choice.Message.Content, calledTools, true
+}
+
+// cacheable reports whether a turn may be answered from the response cache:
+// it carries no media or untrusted content and does not look like a tool
+// request
+func (h *Handler) cacheable(msg messenger.Inbound, messages []openaiService.ChatMessage) bool {
+	// Group answers depend on who is talking, so they are never shared
+	if msg.HasMedia() || msg.IsGroup() || h.router.NeedsTools(msg.Message) {
+		return false
+	}
+	for _, msg := range messages {
+		if msg.Untrusted {
+			return false
+		}
+	}
+	return true
+}
+
+// handleModelCommand handles "/model", "/model <tier or model>" and
+// "/model auto", returning the reply to send when the message was a command
+func (h *Handler) handleModelCommand(conversation *models.Conversation, lang, message string) (string, bool) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 || fields[0] != "/model" {
+		return "", false
+	}
+
+	if len(fields) == 1 {
+		current := "auto"
+		if conversation.ModelOverride != "" {
+			current = conversation.ModelOverride
+		}
+		return i18n.T(lang, i18n.ModelCurrent, current, strings.Join(h.router.Tiers(), ", ")), true
+	}
+
+	choice := fields[1]
+	if choice == "auto" {
+		conversation.ModelOverride = ""
+	} else {
+		decision, ok := h.router.Resolve(choice)
+		if !ok {
+			return i18n.T(lang, i18n.ModelUnknown, choice, strings.Join(h.router.Tiers(), ", ")), true
+		}
+		conversation.ModelOverride = choice
+		if decision.Tier != "" {
+			conversation.ModelOverride = decision.Tier
+		}
+	}
+
+	if err := h.db.UpdateConversation(conversation); err != nil {
+		h.logger.WithError(err).Error("Failed to save model override")
+		return i18n.T(lang, i18n.ModelSaveFailed), true
+	}
+
+	if conversation.ModelOverride == "" {
+		return i18n.T(lang, i18n.ModelAuto), true
+	}
+	return i18n.T(lang, i18n.ModelSet, conversation.ModelOverride), true
+}
+
+// replyLanguage picks the language to answer in. A language chosen with
+// /lang always wins; otherwise a confident detection updates the stored
+// preference, and short or ambiguous messages keep the previous one.
+func (h *Handler) replyLanguage(conversation *models.Conversation, message string) string {
+	if conversation.LanguageSet && conversation.Language != "" {
+		return conversation.Language
+	}
+
+	detected, confidence := i18n.Detect(message)
+	if detected != "" && confidence >= h.language.MinConfidence {
+		conversation.Language = detected
+		return detected
+	}
+	if conversation.Language != "" {
+		return conversation.Language
+	}
+	if detected != "" {
+		return detected
+	}
+	return h.language.Default
+}
+
+// handleLanguageCommand handles "/lang", "/lang <code>" and "/lang auto",
+// returning the reply to send when the message was a command
+func (h *Handler) handleLanguageCommand(conversation *models.Conversation, lang, message string) (string, bool) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 || fields[0] != "/lang" {
+		return "", false
+	}
+
+	if len(fields) == 1 {
+		current := "auto"
+		if conversation.LanguageSet {
+			current = conversation.Language
+		}
+		return i18n.T(lang, i18n.LanguageCurrent, current, strings.Join(i18n.Languages, ", ")), true
+	}
+
+	choice := strings.ToLower(fields[1])
+	reply := i18n.T(lang, i18n.LanguageAuto)
+	if choice == "auto" {
+		conversation.LanguageSet = false
+	} else {
+		if !i18n.Supported(choice) {
+			return i18n.T(lang, i18n.LanguageUnknown, choice, strings.Join(i18n.Languages, ", ")), true
+		}
+		conversation.Language = choice
+		conversation.LanguageSet = true
+		reply = i18n.T(choice, i18n.LanguageSet)
+	}
+
+	if err := h.db.UpdateConversation(conversation); err != nil {
+		h.logger.WithError(err).Error("Failed to save language preference")
+		return i18n.T(lang, i18n.ErrProcessing), true
+	}
+	return reply, true
+}
+
+// Users may ask the bot to remember up to maxFacts facts of up to
+// maxFactLength characters each
+const (
+	maxFacts      = 20
+	maxFactLength = 300
+)
+
+// handleFactCommand handles "/remember <fact>", "/remember" and "/forget",
+// returning the reply to send when the message was a command. Facts belong
+// to the user, so a group member's facts follow them into other chats.
+func (h *Handler) handleFactCommand(user, lang, message string) (string, bool) {
+	command, fact, _ := strings.Cut(strings.TrimSpace(message), " ")
+	if command != "/remember" && command != "/forget" {
+		return "", false
+	}
+
+	if command == "/forget" {
+		if _, err := h.db.DeleteUserFacts(user); err != nil {
+			h.logger.WithError(err).Error("Failed to forget user facts")
+			return i18n.T(lang, i18n.ErrProcessing), true
+		}
+		return i18n.T(lang, i18n.FactForgotten), true
+	}
+
+	fact = strings.Join(strings.Fields(fact), " ")
+	if fact == "" {
+		facts, err := h.db.GetUserFacts(user)
+		if err != nil {
+			h.logger.WithError(err).Error("Failed to load user facts")
+			return i18n.T(lang, i18n.ErrProcessing), true
+		}
+		if len(facts) == 0 {
+			return i18n.T(lang, i18n.FactNone), true
+		}
+		return i18n.T(lang, i18n.FactList, "- "+strings.Join(facts, "\n- ")), true
+	}
+
+	if runes := []rune(fact); len(runes) > maxFactLength {
+		fact = string(runes[:maxFactLength])
+	}
+	if err := h.db.AddUserFact(user, fact, maxFacts); err != nil {
+		h.logger.WithError(err).Error("Failed to save user fact")
+		return i18n.T(lang, i18n.ErrProcessing), true
+	}
+	return i18n.T(lang, i18n.FactSaved), true
+}
+
+// inboundContent renders a message as the text the model and the history
+// see. Attachments are described in brackets ahead of their caption.
+func inboundContent(msg messenger.Inbound) string {
+	var note string
+	switch msg.Type {
+	case messenger.TypeText:
+		return msg.Message
+	case messenger.TypeLocation:
+		note = fmt.Sprintf("[Shared location: %s]", msg.Location)
+	case messenger.TypeUnsupported:
+		note = "[Unsupported message]"
+	default:
+		note = fmt.Sprintf("[Sent %s", attachmentLabels[msg.Type])
+		if msg.Filename != "" {
+			note += ": " + msg.Filename
+		}
+		note += "]"
+	}
+
+	if caption := strings.TrimSpace(msg.Message); caption != "" {
+		return note + "\n" + caption
+	}
+	return note
+}
+
+// userMessage builds the prompt message for something the user sent.
+// Captions, filenames and transcripts of attachments are not text the user
+// typed to the bot (they are often forwarded), so they are marked untrusted
+// for the guard.
+func userMessage(content string, msgType messenger.MessageType) openaiService.ChatMessage {
+	message := openaiService.ChatMessage{Role: "user", Content: content}
+	if source, ok := untrustedSources[msgType]; ok {
+		message.Untrusted = true
+		message.Source = source
+	}
+	return message
+}
+
+var untrustedSources = map[messenger.MessageType]string{
+	messenger.TypeImage:    "image caption",
+	messenger.TypeVideo:    "video caption",
+	messenger.TypeAudio:    "voice transcript",
+	messenger.TypeDocument: "document",
+	messenger.TypeLocation: "shared location",
+}
+
+var attachmentLabels = map[messenger.MessageType]string{
+	messenger.TypeImage:    "an image",
+	messenger.TypeVideo:    "a video",
+	messenger.TypeAudio:    "a voice message",
+	messenger.TypeDocument: "a document",
+}
+
+// replyUnsupported answers a message the bot cannot read with a canned
+// reply and records it
+func (h *Handler) replyUnsupported(ctx context.Context, t turn, conversation *models.Conversation, msgType messenger.MessageType, content string, key i18n.Key) {
+	h.sendTextMessage(ctx, t.sender, i18n.T(t.language, key))
+	h.recordUserMessage(t, conversation, msgType, content)
+}
+
+// recordUserMessage stores a message that gets no model reply so it still
+// shows up in the history
+func (h *Handler) recordUserMessage(t turn, conversation *models.Conversation, msgType messenger.MessageType, content string) {
 	userMsg := &models.Message{
-		MessageID:   fmt.Sprintf("user_%d", time.Now().UnixNano()),
-		FromJID:     sender,
+		MessageID:   t.messageID,
+		FromJID:     t.sender,
 		ToJID:       "bot",
-		Content:     message,
-		MessageType: "text",
-		IsFromMe:    false,
+		Content:     content,
+		MessageType: string(msgType),
+		MemberJID:   t.member,
+		MemberName:  t.memberName,
 		Timestamp:   time.Now(),
 	}
 	if err := h.db.SaveMessage(userMsg); err != nil {
 		h.logger.WithError(err).Error("Failed to save user message")
 	}
 
-	// Save assistant message
-	assistantMsg := &models.Message{
-		MessageID:   fmt.Sprintf("assistant_%d", time.Now().UnixNano()),
-		FromJID:     "bot",
-		ToJID:       sender,
-		Content:     choice.Message.Content,
-		MessageType: "text",
-		IsFromMe:    true,
-		Timestamp:   time.Now(),
-	}
-	if err := h.db.SaveMessage(assistantMsg); err != nil {
-		h.logger.WithError(err).Error("Failed to save assistant message")
-	}
-
-	// Update conversation
-	conversation.LastMessage = message
+	conversation.LastMessage = content
 	conversation.MessageCount++
 	if err := h.db.UpdateConversation(conversation); err != nil {
 		h.logger.WithError(err).Error("Failed to update conversation")
 	}
 }
 
-func (h *Handler) handleToolCalls(ctx context.Context, sender string, toolCalls []openai.ToolCall, assistantMessage string) {
+// personaTarget identifies who a message is from for persona assignment.
+// Group messages carry the group in Sender and the author in Member.
+func personaTarget(msg messenger.Inbound) persona.Target {
+	target := persona.Target{Device: msg.Device, User: msg.Sender}
+	if msg.IsGroup() {
+		target.Group = msg.Sender
+		target.User = msg.Member
+	}
+	return target
+}
+
+// systemPrompt renders the persona assigned to the target and tells the
+// model which language to answer in and whether it is in a group
+func (h *Handler) systemPrompt(msg messenger.Inbound, target persona.Target, p *persona.Persona, lang string) string {
+	facts, err := h.db.GetUserFacts(target.User)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to load user facts")
+	}
+
+	prompt, err := h.personas.Render(p, persona.PromptData{
+		Sender:     target.User,
+		SenderName: msg.Name,
+		Group:      target.Group,
+		Device:     msg.Device,
+		Language:   i18n.Name(lang),
+		Tools:      h.toolMgr.ToolNames(),
+		Facts:      facts,
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("persona", p.Name).Error("Failed to render system prompt")
+		prompt = persona.DefaultPrompt
+	}
+
+	prompt += fmt.Sprintf("\n\nAlways answer in %s unless the user asks for a different language.", i18n.Name(lang))
+	if target.Group != "" {
+		prompt += "\n\nThis is a group chat. Each member's message starts with their name; answer the member who addressed you."
+	}
+	return prompt
+}
+
+func (h *Handler) handleToolCalls(ctx context.Context, t turn, toolCalls []openai.ToolCall, assistantMessage string) {
+	sender := t.sender
 	for _, toolCall := range toolCalls {
 		// Parse tool call parameters
 		var parameters map[string]interface{}
@@ -208,23 +1035,26 @@ func (h *Handler) handleToolCalls(ctx context.Context, sender string, toolCalls
 		result, err := h.toolMgr.ExecuteTool(ctx, toolCall.ID, toolCall.Function.Name, parameters)
 		if err != nil {
 			h.logger.WithError(err).Error("Tool execution failed")
-			h.sendErrorMessage(sender, fmt.Sprintf("Sorry, I couldn't execute the %s tool.", toolCall.Function.Name))
+			h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.ErrToolFailed, toolCall.Function.Name))
 			continue
 		}
 
 		// Handle different tool results
 		switch toolCall.Function.Name {
 		case "generate_image":
-			h.handleImageGenerationResult(sender, result, assistantMessage)
+			h.handleImageGenerationResult(ctx, t, result, assistantMessage)
+		case "escalate_to_human":
+			h.handleEscalationResult(ctx, t, result, assistantMessage)
 		default:
-			h.sendTextMessage(sender, fmt.Sprintf("Tool %s executed successfully", toolCall.Function.Name))
+			h.sendTextMessage(ctx, sender, i18n.T(t.language, i18n.ToolSucceeded, toolCall.Function.Name))
 		}
 	}
 }
 
-func (h *Handler) handleImageGenerationResult(sender string, result *tools.ExecutionResult, assistantMessage string) {
+func (h *Handler) handleImageGenerationResult(ctx context.Context, t turn, result *tools.ExecutionResult, assistantMessage string) {
+	sender := t.sender
 	if !result.Success {
-		h.sendErrorMessage(sender, "Sorry, I couldn't generate the image. Please try again with a different prompt.")
+		h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.ErrImageGeneration))
 		return
 	}
 
@@ -233,38 +1063,173 @@ func (h *Handler) handleImageGenerationResult(sender string, result *tools.Execu
 	resultBytes, _ := json.Marshal(result.Result)
 	if err := json.Unmarshal(resultBytes, &imageResult); err != nil {
 		h.logger.WithError(err).Error("Failed to parse image generation result")
-		h.sendErrorMessage(sender, "Sorry, there was an error processing the generated image.")
+		h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.ErrImageProcessing))
 		return
 	}
 
 	// Send the image
 	caption := assistantMessage
 	if caption == "" {
-		caption = "Here's your generated image!"
+		caption = i18n.T(t.language, i18n.ImageCaption)
+	}
+
+	// Screen what the image depicts (via its prompt) and the caption
+	screened := strings.TrimSpace(imageResult.RevisedPrompt + "\n" + caption)
+	if h.moderation.Check(ctx, moderation.StageImage, t.subject(), screened).Blocked() {
+		h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.BlockedImage))
+		return
+	}
+
+	// The generated image URL expires within hours, so keep a copy and send
+	// the copy when the gateway can fetch it from the bot. Without a copy the
+	// original URL is still good for now.
+	imageURL := imageResult.ImageURL
+	if h.media.Enabled() {
+		if stored, err := h.media.Fetch(ctx, imageURL, media.Info{}); err != nil {
+			h.logger.WithError(err).WithField("image_url", imageURL).Error("Failed to store generated image")
+		} else {
+			if err := h.media.Link(t.replyID, stored); err != nil {
+				h.logger.WithError(err).Error("Failed to link generated image")
+			}
+			if h.media.CanServe() {
+				imageURL = h.media.URL(stored)
+			}
+		}
 	}
 
-	_, err := h.fonnte.SendImage(sender, imageResult.ImageURL, caption)
+	_, err := h.outbox.SendMedia(ctx, sender, messenger.Media{
+		Type:    messenger.MediaImage,
+		URL:     imageURL,
+		Caption: caption,
+	})
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to send image")
-		h.sendErrorMessage(sender, "Sorry, I couldn't send the generated image.")
+		h.sendErrorMessage(ctx, sender, i18n.T(t.language, i18n.ErrImageSend))
 		return
 	}
 
 	h.logger.WithFields(logrus.Fields{
 		"sender":    sender,
-		"image_url": imageResult.ImageURL,
-	}).Info("Image sent successfully")
+		"image_url": imageURL,
+	}).Info("Image queued for delivery")
+}
+
+// sendReply screens model output before sending it to the user. Voice
+// notes are also answered with the reply spoken, when enabled.
+func (h *Handler) sendReply(ctx context.Context, t turn, message string) {
+	if h.sendReplyText(ctx, t, message) {
+		h.speakReply(ctx, t, message)
+	}
+}
+
+// sendReplyText screens model output and sends it as text. It reports
+// false when moderation blocked it.
+func (h *Handler) sendReplyText(ctx context.Context, t turn, message string) bool {
+	if h.moderation.Check(ctx, moderation.StageOutput, t.subject(), message).Blocked() {
+		h.sendErrorMessage(ctx, t.sender, i18n.T(t.language, i18n.BlockedOutput))
+		return false
+	}
+	h.sendTextMessage(ctx, t.sender, message)
+	return true
+}
+
+// speakReply sends a reply as audio when the turn was spoken and voice
+// replies are enabled
+func (h *Handler) speakReply(ctx context.Context, t turn, message string) {
+	if t.spoken && h.voice.Replies() {
+		h.sendVoiceMessage(ctx, t, message)
+	}
+}
+
+// storeAttachment downloads the media of an inbound message into the media
+// store and links it to the message. Failures are logged and the message
+// is answered anyway.
+func (h *Handler) storeAttachment(ctx context.Context, t turn, msg messenger.Inbound) *models.Media {
+	if !h.media.Enabled() {
+		return nil
+	}
+
+	content, err := messenger.OpenMedia(ctx, h.messenger, msg)
+	if err != nil {
+		h.logger.WithError(err).WithField("sender", t.sender).Warn("Failed to download attachment")
+		return nil
+	}
+	defer content.Close()
+
+	stored, err := h.media.Store(ctx, content, media.Info{Filename: msg.Filename, SourceURL: msg.MediaURL})
+	if err != nil {
+		h.logger.WithError(err).WithField("sender", t.sender).Warn("Failed to store attachment")
+		return nil
+	}
+	if err := h.media.Link(t.messageID, stored); err != nil {
+		h.logger.WithError(err).Error("Failed to link attachment")
+	}
+	return stored
+}
+
+// transcribe returns what was said in a voice note, read from the media
+// store when it was stored. ok is false when it could not be transcribed or
+// nothing was said.
+func (h *Handler) transcribe(ctx context.Context, msg messenger.Inbound, stored *models.Media) (string, bool) {
+	var audio io.ReadCloser
+	var err error
+	if stored != nil {
+		audio, err = h.media.Open(ctx, stored)
+	} else {
+		audio, err = messenger.OpenMedia(ctx, h.messenger, msg)
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("sender", msg.Sender).Error("Failed to download voice message")
+		return "", false
+	}
+	defer audio.Close()
+
+	// The API needs an extension to recognise the format; WhatsApp voice
+	// notes are Ogg Opus
+	filename := msg.Filename
+	if path.Ext(filename) == "" {
+		filename = "voice.ogg"
+	}
+
+	transcript, err := h.voice.Transcribe(ctx, audio, path.Base(filename))
+	if err != nil {
+		h.logger.WithError(err).WithField("sender", msg.Sender).Error("Failed to transcribe voice message")
+		return "", false
+	}
+	return transcript, transcript != ""
+}
+
+// sendVoiceMessage speaks a reply and sends it as an audio message. The
+// text was already sent, so failures are only logged.
+func (h *Handler) sendVoiceMessage(ctx context.Context, t turn, message string) {
+	stored, err := h.voice.Synthesize(ctx, message)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to synthesize reply")
+		return
+	}
+	if err := h.media.Link(t.replyID, stored); err != nil {
+		h.logger.WithError(err).Error("Failed to link voice message")
+	}
+
+	_, err = h.outbox.SendMedia(ctx, t.sender, messenger.Media{
+		Type:     messenger.MediaAudio,
+		URL:      h.media.URL(stored),
+		MimeType: stored.MimeType,
+	})
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to send voice message")
+	}
 }
 
-func (h *Handler) sendTextMessage(sender, message string) {
-	_, err := h.fonnte.SendMessage(sender, message)
+func (h *Handler) sendTextMessage(ctx context.Context, sender, message string) {
+	_, err := h.outbox.SendText(ctx, sender, message)
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to send text message")
 	}
 }
 
-func (h *Handler) sendErrorMessage(sender, message string) {
-	_, err := h.fonnte.SendMessage(sender, message)
+func (h *Handler) sendErrorMessage(ctx context.Context, sender, message string) {
+	_, err := h.outbox.SendText(ctx, sender, message)
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to send error message")
 	}
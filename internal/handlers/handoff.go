@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"example-tool-call/internal/services/handoff"
+	"example-tool-call/internal/services/i18n"
+	"example-tool-call/internal/services/tools"
+	"github.com/gin-gonic/gin"
+)
+
+// startHandoff hands the conversation over to a human and reports whether a
+// handoff is now active
+func (h *Handler) startHandoff(ctx context.Context, t turn, source, reason string) bool {
+	name := t.name
+	if t.memberName != "" {
+		name = t.memberName
+	}
+	_, err := h.handoff.Start(ctx, handoff.Request{
+		JID:    t.sender,
+		Name:   name,
+		Source: source,
+		Reason: reason,
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("sender", t.sender).Error("Failed to hand off conversation")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) handleEscalationResult(ctx context.Context, t turn, result *tools.ExecutionResult, assistantMessage string) {
+	var escalation tools.EscalationResult
+	resultBytes, _ := json.Marshal(result.Result)
+	if !result.Success || json.Unmarshal(resultBytes, &escalation) != nil {
+		h.sendErrorMessage(ctx, t.sender, i18n.T(t.language, i18n.ErrToolFailed, "escalate_to_human"))
+		return
+	}
+
+	if strings.TrimSpace(assistantMessage) != "" {
+		h.sendReply(ctx, t, assistantMessage)
+	}
+	if !h.startHandoff(ctx, t, handoff.SourceTool, escalation.Reason) {
+		h.sendErrorMessage(ctx, t.sender, i18n.T(t.language, i18n.ErrProcessing))
+	}
+}
+
+// ListHandoffs returns the conversations currently handed off
+func (h *Handler) ListHandoffs(c *gin.Context) {
+	handoffs, err := h.db.GetHandoffs()
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list handoffs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list handoffs"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"handoffs": handoffs})
+}
+
+// StartHandoff hands a conversation over to a human
+func (h *Handler) StartHandoff(c *gin.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	jid := c.Param("jid")
+	started, err := h.handoff.Start(c.Request.Context(), handoff.Request{
+		JID:    jid,
+		Source: handoff.SourceAdmin,
+		Reason: body.Reason,
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("jid", jid).Error("Failed to hand off conversation")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hand off conversation"})
+		return
+	}
+	if !started {
+		c.JSON(http.StatusConflict, gin.H{"error": "Conversation is already handed off"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "handed_off"})
+}
+
+// EndHandoff gives a conversation back to the bot and returns the summary
+// left for the model
+func (h *Handler) EndHandoff(c *gin.Context) {
+	jid := c.Param("jid")
+	summary, err := h.handoff.Release(c.Request.Context(), jid, "admin")
+	if errors.Is(err, handoff.ErrNotActive) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation is not handed off"})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("jid", jid).Error("Failed to end handoff")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end handoff"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "released", "summary": summary})
+}
+
+// SendOperatorMessage relays an operator's message to a handed-off user,
+// for operators answering through the webhook instead of WhatsApp
+func (h *Handler) SendOperatorMessage(c *gin.Context) {
+	var body struct {
+		Text     string `json:"text" binding:"required"`
+		Operator string `json:"operator"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Text) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text is required"})
+		return
+	}
+
+	jid := c.Param("jid")
+	err := h.handoff.Relay(c.Request.Context(), jid, body.Operator, body.Text)
+	if errors.Is(err, handoff.ErrNotActive) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Conversation is not handed off"})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("jid", jid).Error("Failed to relay operator message")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "queued"})
+}
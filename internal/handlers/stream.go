@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"example-tool-call/internal/config"
+)
+
+// streamDelivery forwards a streamed completion to WhatsApp in readable
+// pieces. Text is buffered until at least minChunk characters are available
+// and then cut at the last paragraph or sentence boundary, with at least
+// interval between consecutive sends.
+type streamDelivery struct {
+	send     func(text string)
+	minChunk int
+	interval time.Duration
+
+	buf      strings.Builder
+	lastSent time.Time
+}
+
+func newStreamDelivery(cfg config.StreamingConfig, send func(text string)) *streamDelivery {
+	return &streamDelivery{
+		send:     send,
+		minChunk: cfg.MinChunkSize,
+		interval: cfg.MinInterval,
+	}
+}
+
+// Write buffers a delta and sends any complete chunk that is ready
+func (d *streamDelivery) Write(delta string) {
+	d.buf.WriteString(delta)
+
+	text := d.buf.String()
+	if len(text) < d.minChunk || time.Since(d.lastSent) < d.interval {
+		return
+	}
+
+	cut := chunkBoundary(text, d.minChunk)
+	if cut <= 0 {
+		return
+	}
+
+	d.emit(text[:cut])
+	d.buf.Reset()
+	d.buf.WriteString(text[cut:])
+}
+
+// Pending returns the text that has not been sent yet
+func (d *streamDelivery) Pending() string {
+	return strings.TrimSpace(d.buf.String())
+}
+
+// Flush sends whatever is left, waiting out the rate limit if needed
+func (d *streamDelivery) Flush() {
+	text := d.buf.String()
+	d.buf.Reset()
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	if wait := d.interval - time.Since(d.lastSent); wait > 0 {
+		time.Sleep(wait)
+	}
+	d.emit(text)
+}
+
+func (d *streamDelivery) emit(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	d.send(text)
+	d.lastSent = time.Now()
+}
+
+// chunkBoundary returns the index just past the last paragraph break in text,
+// or failing that the last sentence end, provided the chunk before it is at
+// least minChunk long. Code blocks are never cut, so each chunk can be
+// formatted on its own. It returns -1 when there is no suitable boundary yet.
+func chunkBoundary(text string, minChunk int) int {
+	for i := strings.LastIndex(text, "\n\n"); i >= minChunk; i = strings.LastIndex(text[:i], "\n\n") {
+		if !inCodeBlock(text, i) {
+			return i + 2
+		}
+	}
+
+	for i := len(text) - 2; i >= minChunk-1 && i >= 0; i-- {
+		switch text[i] {
+		case '.', '!', '?':
+			if next := text[i+1]; (next == ' ' || next == '\n') && !inCodeBlock(text, i) {
+				return i + 2
+			}
+		}
+	}
+	return -1
+}
+
+// inCodeBlock reports whether position i of text is inside a ``` fence
+func inCodeBlock(text string, i int) bool {
+	return strings.Count(text[:i], "```")%2 == 1
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkBoundary(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string // text before the cut, or "" for no cut
+	}{
+		{
+			name: "paragraph",
+			text: "First paragraph here.\n\nSecond one is still stream",
+			want: "First paragraph here.\n\n",
+		},
+		{
+			name: "sentence",
+			text: "First sentence here. Second one is still stream",
+			want: "First sentence here. ",
+		},
+		{
+			name: "paragraph before code block",
+			text: "Run this:\n\n```\nmake build\n\nmake test. Then\n",
+			want: "Run this:\n\n",
+		},
+		{
+			name: "inside code block only",
+			text: "```\nmake build\n\nmake test. Then\n",
+			want: "",
+		},
+		{
+			name: "after closed code block",
+			text: "```\nmake build\n\nmake test\n```\n\nDone. More",
+			want: "```\nmake build\n\nmake test\n```\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cut := chunkBoundary(tt.text, 5)
+			got := ""
+			if cut > 0 {
+				got = tt.text[:cut]
+			}
+			if got != tt.want {
+				t.Errorf("cut before %q, want %q", got, tt.want)
+			}
+			if strings.Count(got, "```")%2 != 0 {
+				t.Errorf("chunk %q leaves a code block open", got)
+			}
+		})
+	}
+}
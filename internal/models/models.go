@@ -25,20 +25,49 @@ type Message struct {
 	Content     string    `gorm:"type:text" json:"content"`
 	MessageType string    `gorm:"not null" json:"message_type"`
 	IsFromMe    bool      `gorm:"default:false" json:"is_from_me"`
+	Model       string    `json:"model,omitempty"`
+	RouteReason string    `json:"route_reason,omitempty"`
 	Timestamp   time.Time `gorm:"not null" json:"timestamp"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// MemberJID and MemberName attribute a group message to its author;
+	// FromJID is the group
+	MemberJID  string `gorm:"column:member_jid" json:"member_jid,omitempty"`
+	MemberName string `json:"member_name,omitempty"`
+
+	// ProviderMessageID is the gateway's ID for a sent reply, recorded by
+	// the outbox once delivered
+	ProviderMessageID string `gorm:"index" json:"provider_message_id,omitempty"`
+
+	// DeliveryStatus is the furthest state a sent reply reached: sent,
+	// delivered, read or failed
+	DeliveryStatus string     `json:"delivery_status,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
 }
 
 // Conversation represents a conversation thread
 type Conversation struct {
-	ID           uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
-	JID          string    `gorm:"uniqueIndex;not null" json:"jid"`
-	LastMessage  string    `gorm:"type:text" json:"last_message"`
-	MessageCount int       `gorm:"default:0" json:"message_count"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Messages     []Message `gorm:"foreignKey:FromJID;references:JID" json:"messages,omitempty"`
+	ID            uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	JID           string    `gorm:"uniqueIndex;not null" json:"jid"`
+	LastMessage   string    `gorm:"type:text" json:"last_message"`
+	MessageCount  int       `gorm:"default:0" json:"message_count"`
+	ModelOverride string    `json:"model_override,omitempty"`
+	Language      string    `json:"language,omitempty"`
+	LanguageSet   bool      `gorm:"default:false" json:"language_set"` // chosen with /lang, not detected
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Messages      []Message `gorm:"foreignKey:FromJID;references:JID" json:"messages,omitempty"`
+
+	// Handoff is set while a human agent has taken over the conversation.
+	// HandoffSource says what started it (tool, keyword or admin) and
+	// HandoffOperator is the number of the operator answering, if any.
+	Handoff          bool       `gorm:"default:false;index" json:"handoff"`
+	HandoffSource    string     `json:"handoff_source,omitempty"`
+	HandoffReason    string     `gorm:"type:text" json:"handoff_reason,omitempty"`
+	HandoffOperator  string     `json:"handoff_operator,omitempty"`
+	HandoffStartedAt *time.Time `json:"handoff_started_at,omitempty"`
 }
 
 // ToolExecution represents a tool execution log
@@ -54,6 +83,181 @@ type ToolExecution struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// LLMCall represents a single chat completion request and its token usage
+type LLMCall struct {
+	ID               uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	MessageID        string    `gorm:"index" json:"message_id"`
+	SenderJID        string    `gorm:"column:sender_jid;index" json:"sender_jid"`
+	Provider         string    `gorm:"not null" json:"provider"`
+	Model            string    `gorm:"index;not null" json:"model"`
+	PromptTokens     int       `gorm:"default:0" json:"prompt_tokens"`
+	CompletionTokens int       `gorm:"default:0" json:"completion_tokens"`
+	CachedTokens     int       `gorm:"default:0" json:"cached_tokens"`
+	TotalTokens      int       `gorm:"default:0" json:"total_tokens"`
+	Latency          int64     `gorm:"not null" json:"latency"` // milliseconds
+	FinishReason     string    `json:"finish_reason"`
+	Cost             float64   `gorm:"default:0" json:"cost"` // USD
+	Success          bool      `gorm:"default:false" json:"success"`
+	ErrorMsg         string    `gorm:"type:text" json:"error_msg,omitempty"`
+	CreatedAt        time.Time `gorm:"index" json:"created_at"`
+}
+
+
+// ModerationDecision records the outcome of screening a piece of content
+type ModerationDecision struct {
+	ID         uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	MessageID  string    `gorm:"index" json:"message_id"`
+	SenderJID  string    `gorm:"column:sender_jid;index" json:"sender_jid"`
+	Stage      string    `gorm:"not null" json:"stage"`        // input, output or image
+	Action     string    `gorm:"index;not null" json:"action"` // allow, warn or block
+	Notified   bool      `gorm:"default:false" json:"notified"`
+	Categories string    `json:"categories"`             // comma separated
+	Flags      string    `gorm:"type:text" json:"flags"` // JSON encoded checker flags
+	Content    string    `gorm:"type:text" json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+// SecurityEvent records a security intervention such as a blocked tool call
+type SecurityEvent struct {
+	ID        uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	Kind      string    `gorm:"index;not null" json:"kind"`
+	Source    string    `gorm:"not null" json:"source"`
+	SenderJID string    `gorm:"column:sender_jid;index" json:"sender_jid"`
+	MessageID string    `gorm:"index" json:"message_id"`
+	Detail    string    `gorm:"type:text" json:"detail"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// CachedResponse is a stored answer to a question that needed no tools
+type CachedResponse struct {
+	ID        uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	Scope     string    `gorm:"index;not null" json:"scope"` // hash of persona, model, language and version
+	Key       string    `gorm:"index;not null" json:"key"`   // hash of the normalized question
+	Question  string    `gorm:"type:text" json:"question"`
+	Answer    string    `gorm:"type:text" json:"answer"`
+	Embedding string    `gorm:"type:text" json:"-"` // JSON encoded vector, empty without semantic matching
+	Model     string    `json:"model"`
+	Hits      int       `gorm:"default:0" json:"hits"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Queue states of a QueuedMessage
+const (
+	QueuePending    = "pending"
+	QueueProcessing = "processing"
+	QueueDone       = "done"
+	QueueDead       = "dead"
+)
+
+// QueuedMessage is an inbound message persisted before the webhook is
+// acknowledged and processed by the worker pool
+type QueuedMessage struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	Gateway     string     `json:"gateway"`
+	SenderJID   string     `gorm:"column:sender_jid;index" json:"sender_jid"`
+	Payload     string     `gorm:"type:text;not null" json:"payload"` // JSON encoded messenger.Inbound
+	Status      string     `gorm:"index:idx_queue_claim,priority:1;not null" json:"status"`
+	AvailableAt time.Time  `gorm:"index:idx_queue_claim,priority:2;not null" json:"available_at"` // next attempt, or visibility deadline while processing
+	Attempts    int        `gorm:"default:0" json:"attempts"`
+	LockedBy    string     `json:"locked_by,omitempty"`
+	DedupKey    string     `gorm:"size:64" json:"dedup_key,omitempty"`
+	LastError   string     `gorm:"type:text" json:"last_error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Outbox states
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboundMessage is a text or media message waiting to be sent, or the
+// record of one that was
+type OutboundMessage struct {
+	ID           uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
+	Gateway      string     `json:"gateway"`
+	Device       string     `json:"device"`
+	RecipientJID string     `gorm:"column:recipient_jid;index" json:"recipient_jid"`
+	MessageID    string     `gorm:"index" json:"message_id,omitempty"` // the models.Message this is part of
+	Kind         string     `gorm:"not null" json:"kind"`              // text or a messenger media type
+	Text         string     `gorm:"type:text" json:"text,omitempty"`   // message or caption
+	MediaURL     string     `json:"media_url,omitempty"`
+	Filename     string     `json:"filename,omitempty"`
+	MimeType     string     `json:"mime_type,omitempty"`
+	Status       string     `gorm:"index:idx_outbox_claim,priority:1;not null" json:"status"`
+	AvailableAt  time.Time  `gorm:"index:idx_outbox_claim,priority:2;not null" json:"available_at"`
+	Attempts     int        `gorm:"default:0" json:"attempts"`
+	LockedBy     string     `json:"locked_by,omitempty"`
+	ProviderID   string     `gorm:"index" json:"provider_id,omitempty"`
+	LastError    string     `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt       *time.Time `json:"sent_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Delivery states reported for sent messages
+const (
+	DeliverySent      = "sent"
+	DeliveryDelivered = "delivered"
+	DeliveryRead      = "read"
+	DeliveryFailed    = "failed"
+)
+
+// MessageStatus is one delivery state reported for a sent message
+type MessageStatus struct {
+	ID           uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	ProviderID   string    `gorm:"index;not null" json:"provider_id"`
+	MessageID    string    `gorm:"index" json:"message_id,omitempty"`
+	RecipientJID string    `gorm:"column:recipient_jid" json:"recipient_jid"`
+	Status       string    `gorm:"not null" json:"status"`
+	Error        string    `gorm:"type:text" json:"error,omitempty"`
+	Timestamp    time.Time `json:"timestamp"` // when the gateway says it happened
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// InboundKey records the idempotency key of an accepted inbound message so
+// a redelivered webhook is not processed twice
+type InboundKey struct {
+	Key       string    `gorm:"primaryKey;size:64" json:"key"`
+	Gateway   string    `json:"gateway"`
+	SenderJID string    `gorm:"column:sender_jid" json:"sender_jid"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// Media is a stored file, either received or generated. Files are stored
+// once per content hash and linked to the messages that carried them.
+type Media struct {
+	ID        uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	Hash      string    `gorm:"uniqueIndex;size:64;not null" json:"hash"` // SHA-256 of the content
+	Backend   string    `gorm:"not null" json:"backend"`
+	Key       string    `gorm:"not null" json:"key"`
+	MimeType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	Filename  string    `json:"filename,omitempty"`
+	SourceURL string    `gorm:"type:text" json:"source_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MessageMedia links a message to a stored file it carried
+type MessageMedia struct {
+	MessageID string    `gorm:"primaryKey" json:"message_id"`
+	MediaID   uuid.UUID `gorm:"type:char(36);primaryKey;index" json:"media_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserFact is something a user asked the bot to remember about them,
+// available to prompt templates as .Facts
+type UserFact struct {
+	ID        uuid.UUID `gorm:"type:char(36);primary_key" json:"id"`
+	JID       string    `gorm:"index;not null" json:"jid"`
+	Fact      string    `gorm:"type:text;not null" json:"fact"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
 // BeforeCreate hooks for UUID generation
 func (s *Session) BeforeCreate(tx *gorm.DB) error {
 	if s.ID == uuid.Nil {
@@ -81,4 +285,65 @@ func (t *ToolExecution) BeforeCreate(tx *gorm.DB) error {
 		t.ID = uuid.New()
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (l *LLMCall) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+func (d *ModerationDecision) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+func (e *SecurityEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+func (c *CachedResponse) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+func (q *QueuedMessage) BeforeCreate(tx *gorm.DB) error {
+	if q.ID == uuid.Nil {
+		q.ID = uuid.New()
+	}
+	return nil
+}
+
+func (o *OutboundMessage) BeforeCreate(tx *gorm.DB) error {
+	if o.ID == uuid.Nil {
+		o.ID = uuid.New()
+	}
+	return nil
+}
+
+func (m *MessageStatus) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+func (m *Media) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+func (f *UserFact) BeforeCreate(tx *gorm.DB) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	openaiService "example-tool-call/internal/services/openai"
+	"github.com/sirupsen/logrus"
+)
+
+// Service caches answers to questions that needed no tools. Entries are
+// shared by everyone talking to the same persona and scoped by its prompt
+// template, model, language and the configured version, so editing a
+// persona or bumping the version invalidates them. Messages that may be
+// follow-ups are neither answered from nor stored in the cache.
+type Service struct {
+	enabled        bool
+	ttl            time.Duration
+	minLength      int
+	version        string
+	followUpWindow time.Duration
+	semantic       config.CacheSemanticConfig
+	openai         *openaiService.Service
+	db             *database.DB
+	logger         *logrus.Logger
+
+	lookups      atomic.Int64
+	exactHits    atomic.Int64
+	semanticHits atomic.Int64
+}
+
+// Key identifies what an answer depends on besides the question
+type Key struct {
+	Persona  string
+	Template string // persona prompt template, before rendering
+	Model    string
+	Language string
+	// LastMessage is when the previous message in the conversation was
+	// sent. Within the follow-up window the answer to "and on weekends?"
+	// depends on it, so the cache is skipped.
+	LastMessage time.Time
+}
+
+// Lookup is the outcome of a cache lookup. On a miss it remembers the
+// question so the answer can be stored once generated.
+type Lookup struct {
+	Answer string
+	Kind   string // "exact" or "semantic" on a hit
+
+	service    *Service
+	scope      string
+	key        string
+	question   string
+	model      string
+	normalized string
+	embedding  []float32
+}
+
+// Hit reports whether a cached answer was found
+func (l *Lookup) Hit() bool {
+	return l != nil && l.Kind != ""
+}
+
+// Stats reports cache effectiveness since startup
+type Stats struct {
+	Enabled      bool    `json:"enabled"`
+	Lookups      int64   `json:"lookups"`
+	Hits         int64   `json:"hits"`
+	ExactHits    int64   `json:"exact_hits"`
+	SemanticHits int64   `json:"semantic_hits"`
+	HitRate      float64 `json:"hit_rate"`
+	Entries      int64   `json:"entries"`
+}
+
+func New(cfg config.CacheConfig, openai *openaiService.Service, db *database.DB, logger *logrus.Logger) *Service {
+	s := &Service{
+		enabled:        cfg.Enabled,
+		ttl:            cfg.TTL,
+		minLength:      cfg.MinLength,
+		version:        cfg.Version,
+		followUpWindow: cfg.FollowUpWindow,
+		semantic:       cfg.Semantic,
+		openai:         openai,
+		db:             db,
+		logger:         logger,
+	}
+	if s.ttl <= 0 {
+		s.ttl = 24 * time.Hour
+	}
+	if s.semantic.MaxCandidates <= 0 {
+		s.semantic.MaxCandidates = 500
+	}
+
+	if s.enabled {
+		logger.WithFields(logrus.Fields{
+			"ttl":      s.ttl,
+			"semantic": s.semantic.Enabled,
+		}).Info("Response cache enabled")
+	}
+	return s
+}
+
+// Lookup finds a cached answer for the question, first by normalized text
+// and then, when semantic matching is enabled, by embedding similarity.
+// It returns nil when the question is not cacheable.
+func (s *Service) Lookup(ctx context.Context, key Key, question string) *Lookup {
+	if s == nil || !s.enabled {
+		return nil
+	}
+	if !key.LastMessage.IsZero() && time.Since(key.LastMessage) < s.followUpWindow {
+		return nil
+	}
+
+	normalized := normalize(question)
+	if utf8.RuneCountInString(normalized) < s.minLength {
+		return nil
+	}
+
+	s.lookups.Add(1)
+	now := time.Now()
+	lookup := &Lookup{
+		service:    s,
+		scope:      s.scope(key),
+		key:        hash(normalized),
+		question:   question,
+		model:      key.Model,
+		normalized: normalized,
+	}
+
+	entry, err := s.db.FindCachedResponse(lookup.scope, lookup.key, now)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to look up cached response")
+	}
+	if entry != nil {
+		s.exactHits.Add(1)
+		s.hit(entry, lookup, "exact", 1)
+		return lookup
+	}
+
+	if !s.semantic.Enabled {
+		return lookup
+	}
+
+	lookup.embedding, err = s.openai.Embed(ctx, s.semantic.Model, normalized)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to embed question for cache lookup")
+		return lookup
+	}
+
+	candidates, err := s.db.GetEmbeddedCachedResponses(lookup.scope, now, s.semantic.MaxCandidates)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to load cached responses")
+		return lookup
+	}
+
+	var best *models.CachedResponse
+	bestScore := s.semantic.Threshold
+	for i := range candidates {
+		var vector []float32
+		if err := json.Unmarshal([]byte(candidates[i].Embedding), &vector); err != nil {
+			continue
+		}
+		if score := cosine(lookup.embedding, vector); score >= bestScore {
+			best, bestScore = &candidates[i], score
+		}
+	}
+	if best != nil {
+		s.semanticHits.Add(1)
+		s.hit(best, lookup, "semantic", bestScore)
+	}
+	return lookup
+}
+
+func (s *Service) hit(entry *models.CachedResponse, lookup *Lookup, kind string, score float64) {
+	lookup.Answer = entry.Answer
+	lookup.Kind = kind
+
+	if err := s.db.IncrementCachedResponseHits(entry.ID); err != nil {
+		s.logger.WithError(err).Error("Failed to count cache hit")
+	}
+	s.logger.WithFields(logrus.Fields{
+		"kind":     kind,
+		"score":    score,
+		"question": entry.Question,
+	}).Debug("Response cache hit")
+}
+
+// Store saves the answer generated after a miss
+func (l *Lookup) Store(ctx context.Context, answer string) {
+	if l == nil || l.Hit() || strings.TrimSpace(answer) == "" {
+		return
+	}
+	s := l.service
+
+	entry := &models.CachedResponse{
+		Scope:     l.scope,
+		Key:       l.key,
+		Question:  l.question,
+		Answer:    answer,
+		Model:     l.model,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+	if s.semantic.Enabled {
+		embedding := l.embedding
+		if embedding == nil {
+			var err error
+			if embedding, err = s.openai.Embed(ctx, s.semantic.Model, l.normalized); err != nil {
+				s.logger.WithError(err).Warn("Failed to embed question for cache entry")
+			}
+		}
+		if embedding != nil {
+			if data, err := json.Marshal(embedding); err == nil {
+				entry.Embedding = string(data)
+			}
+		}
+	}
+
+	if err := s.db.SaveCachedResponse(entry); err != nil {
+		s.logger.WithError(err).Error("Failed to save cached response")
+	}
+	if _, err := s.db.DeleteExpiredCachedResponses(time.Now()); err != nil {
+		s.logger.WithError(err).Error("Failed to delete expired cached responses")
+	}
+}
+
+// Stats returns hit counts since startup and the number of live entries
+func (s *Service) Stats() Stats {
+	stats := Stats{Enabled: s != nil && s.enabled}
+	if !stats.Enabled {
+		return stats
+	}
+
+	stats.Lookups = s.lookups.Load()
+	stats.ExactHits = s.exactHits.Load()
+	stats.SemanticHits = s.semanticHits.Load()
+	stats.Hits = stats.ExactHits + stats.SemanticHits
+	if stats.Lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(stats.Lookups)
+	}
+
+	entries, err := s.db.CountCachedResponses(time.Now())
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to count cached responses")
+	}
+	stats.Entries = entries
+	return stats
+}
+
+func (s *Service) scope(key Key) string {
+	return hash(strings.Join([]string{key.Persona, hash(key.Template), key.Model, key.Language, s.version}, "\x00"))
+}
+
+func hash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalize lowercases the question and collapses punctuation and
+// whitespace, so "What are your hours?" and "what are your hours" match
+func normalize(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
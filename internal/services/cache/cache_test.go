@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/database"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	db, err := database.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return New(config.CacheConfig{
+		Enabled:        true,
+		TTL:            time.Hour,
+		MinLength:      5,
+		FollowUpWindow: 10 * time.Minute,
+	}, nil, db, logger)
+}
+
+func TestLookupScope(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	base := Key{Persona: "support", Template: "You help {{.SenderName}}.", Model: "gpt-4o-mini", Language: "en"}
+
+	lookup := s.Lookup(ctx, base, "What are your opening hours?")
+	if lookup == nil || lookup.Hit() {
+		t.Fatalf("first lookup = %+v, want a miss", lookup)
+	}
+	lookup.Store(ctx, "9 to 5")
+
+	otherPersona := base
+	otherPersona.Persona = "sales"
+	editedTemplate := base
+	editedTemplate.Template = "You help {{.SenderName}} politely."
+	otherModel := base
+	otherModel.Model = "gpt-4o"
+	otherLanguage := base
+	otherLanguage.Language = "id"
+	quietConversation := base
+	quietConversation.LastMessage = time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		key      Key
+		question string
+		hit      bool
+	}{
+		{"same question", base, "What are your opening hours?", true},
+		{"normalized question", base, "what are your opening hours", true},
+		{"earlier conversation", quietConversation, "What are your opening hours?", true},
+		{"other question", base, "Where is your shop?", false},
+		{"other persona", otherPersona, "What are your opening hours?", false},
+		{"edited template", editedTemplate, "What are your opening hours?", false},
+		{"other model", otherModel, "What are your opening hours?", false},
+		{"other language", otherLanguage, "What are your opening hours?", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := s.Lookup(ctx, tt.key, tt.question)
+			if lookup == nil {
+				t.Fatal("lookup skipped")
+			}
+			if lookup.Hit() != tt.hit {
+				t.Errorf("hit = %v, want %v", lookup.Hit(), tt.hit)
+			}
+			if tt.hit && lookup.Answer != "9 to 5" {
+				t.Errorf("answer = %q", lookup.Answer)
+			}
+		})
+	}
+}
+
+func TestLookupSkipsFollowUps(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	key := Key{Persona: "support", Template: "You help.", Model: "gpt-4o-mini", Language: "en"}
+	s.Lookup(ctx, key, "And on weekends?").Store(ctx, "Closed")
+
+	key.LastMessage = time.Now().Add(-time.Minute)
+	if lookup := s.Lookup(ctx, key, "And on weekends?"); lookup != nil {
+		t.Errorf("follow-up lookup = %+v, want nil", lookup)
+	}
+	if lookup := s.Lookup(ctx, key, "ok"); lookup != nil {
+		t.Errorf("short message lookup = %+v, want nil", lookup)
+	}
+}
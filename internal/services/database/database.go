@@ -1,13 +1,17 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"example-tool-call/internal/models"
+	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -43,6 +47,17 @@ func New(databaseURL string) (*DB, error) {
 		&models.Message{},
 		&models.Conversation{},
 		&models.ToolExecution{},
+		&models.LLMCall{},
+		&models.ModerationDecision{},
+		&models.SecurityEvent{},
+		&models.CachedResponse{},
+		&models.QueuedMessage{},
+		&models.InboundKey{},
+		&models.OutboundMessage{},
+		&models.MessageStatus{},
+		&models.Media{},
+		&models.MessageMedia{},
+		&models.UserFact{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
@@ -65,21 +80,48 @@ func (db *DB) SaveSession(session *models.Session) error {
 
 func (db *DB) GetSession(jid string) (*models.Session, error) {
 	var session models.Session
-	err := db.Where("jid = ?", jid).First(&session).Error
+	err := db.Where(&models.Session{JID: jid}).First(&session).Error
 	if err != nil {
 		return nil, err
 	}
 	return &session, nil
 }
 
+// GetLatestSession returns the most recently saved session, or nil when
+// there is none
+func (db *DB) GetLatestSession() (*models.Session, error) {
+	var sessions []models.Session
+	if err := db.Order("updated_at desc").Limit(1).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+	return &sessions[0], nil
+}
+
 // Message operations
 func (db *DB) SaveMessage(message *models.Message) error {
 	return db.Create(message).Error
 }
 
+// SetMessageContent fills in the content of a message saved before it was
+// generated. Delivery details the outbox recorded meanwhile are kept.
+func (db *DB) SetMessageContent(messageID, content, routeReason string) error {
+	return db.Model(&models.Message{}).
+		Where("message_id = ?", messageID).
+		Updates(map[string]interface{}{"content": content, "route_reason": routeReason}).Error
+}
+
+// DeleteMessage removes a message by its ID
+func (db *DB) DeleteMessage(messageID string) error {
+	return db.Where("message_id = ?", messageID).Delete(&models.Message{}).Error
+}
+
 func (db *DB) GetMessages(jid string, limit int) ([]models.Message, error) {
 	var messages []models.Message
-	err := db.Where("from_jid = ? OR to_jid = ?", jid, jid).
+	// GORM's naming strategy maps FromJID/ToJID to from_j_id/to_j_id
+	err := db.Where("from_j_id = ? OR to_j_id = ?", jid, jid).
 		Order("timestamp DESC").
 		Limit(limit).
 		Find(&messages).Error
@@ -89,7 +131,7 @@ func (db *DB) GetMessages(jid string, limit int) ([]models.Message, error) {
 // Conversation operations
 func (db *DB) GetOrCreateConversation(jid string) (*models.Conversation, error) {
 	var conversation models.Conversation
-	err := db.Where("jid = ?", jid).First(&conversation).Error
+	err := db.Where(&models.Conversation{JID: jid}).First(&conversation).Error
 	if err == gorm.ErrRecordNotFound {
 		conversation = models.Conversation{
 			JID:          jid,
@@ -100,8 +142,132 @@ func (db *DB) GetOrCreateConversation(jid string) (*models.Conversation, error)
 	return &conversation, err
 }
 
+// UpdateConversation saves a conversation. The handoff columns are left
+// alone, since a handoff may start or end while a reply is being generated
+// with an older copy; they change through the handoff operations only.
 func (db *DB) UpdateConversation(conversation *models.Conversation) error {
-	return db.Save(conversation).Error
+	return db.Omit(handoffColumns...).Save(conversation).Error
+}
+
+// Handoff operations
+
+var handoffColumns = []string{"handoff", "handoff_source", "handoff_reason", "handoff_operator", "handoff_started_at"}
+
+// StartHandoff hands a conversation over to a human. It reports false when
+// a handoff was already active.
+func (db *DB) StartHandoff(jid, source, reason, operator string) (bool, error) {
+	result := db.Model(&models.Conversation{}).
+		Where("j_id = ? AND handoff = ?", jid, false).
+		Updates(map[string]interface{}{
+			"handoff":            true,
+			"handoff_source":     source,
+			"handoff_reason":     reason,
+			"handoff_operator":   operator,
+			"handoff_started_at": time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// AssignHandoff records the operator answering an active handoff. It
+// reports false when the conversation is not handed off or another
+// operator already answers it.
+func (db *DB) AssignHandoff(jid, operator string) (bool, error) {
+	result := db.Model(&models.Conversation{}).
+		Where("j_id = ? AND handoff = ?", jid, true).
+		Where("handoff_operator = '' OR handoff_operator IS NULL OR handoff_operator = ?", operator).
+		Update("handoff_operator", operator)
+	return result.RowsAffected > 0, result.Error
+}
+
+// EndHandoff gives a conversation back to the bot and returns it as it was
+// during the handoff, or nil when no handoff was active
+func (db *DB) EndHandoff(jid string) (*models.Conversation, error) {
+	conversation, err := db.GetHandoff(jid)
+	if conversation == nil || err != nil {
+		return nil, err
+	}
+
+	result := db.Model(&models.Conversation{}).
+		Where("j_id = ? AND handoff = ?", jid, true).
+		Updates(map[string]interface{}{
+			"handoff":            false,
+			"handoff_operator":   "",
+			"handoff_started_at": nil,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return conversation, nil
+}
+
+// GetHandoff returns a conversation if it is handed off, or nil
+func (db *DB) GetHandoff(jid string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := db.Where("j_id = ? AND handoff = ?", jid, true).First(&conversation).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// GetHandoffs returns the conversations currently handed off, oldest first
+func (db *DB) GetHandoffs() ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	err := db.Where("handoff = ?", true).Order("handoff_started_at ASC").Find(&conversations).Error
+	return conversations, err
+}
+
+// GetMessagesSince returns the messages of a conversation sent after the
+// given time, oldest first
+func (db *DB) GetMessagesSince(jid string, since time.Time) ([]models.Message, error) {
+	var messages []models.Message
+	err := db.Where("(from_j_id = ? OR to_j_id = ?) AND timestamp >= ?", jid, jid, since).
+		Order("timestamp ASC").
+		Find(&messages).Error
+	return messages, err
+}
+
+// User fact operations
+
+// AddUserFact remembers a fact about a user and forgets their oldest facts
+// beyond the newest keep
+func (db *DB) AddUserFact(jid, fact string, keep int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.UserFact{JID: jid, Fact: fact}).Error; err != nil {
+			return err
+		}
+
+		var ids []string
+		if err := tx.Model(&models.UserFact{}).
+			Where("j_id = ?", jid).
+			Order("created_at DESC").
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) <= keep {
+			return nil
+		}
+		return tx.Where("id IN ?", ids[keep:]).Delete(&models.UserFact{}).Error
+	})
+}
+
+// GetUserFacts returns the facts remembered about a user, oldest first
+func (db *DB) GetUserFacts(jid string) ([]string, error) {
+	var facts []string
+	err := db.Model(&models.UserFact{}).
+		Where("j_id = ?", jid).
+		Order("created_at ASC").
+		Pluck("fact", &facts).Error
+	return facts, err
+}
+
+// DeleteUserFacts forgets everything remembered about a user
+func (db *DB) DeleteUserFacts(jid string) (int64, error) {
+	result := db.Where("j_id = ?", jid).Delete(&models.UserFact{})
+	return result.RowsAffected, result.Error
 }
 
 // Tool execution operations
@@ -113,4 +279,702 @@ func (db *DB) GetToolExecutions(messageID string) ([]models.ToolExecution, error
 	var executions []models.ToolExecution
 	err := db.Where("message_id = ?", messageID).Find(&executions).Error
 	return executions, err
+}
+
+// LLM call operations
+func (db *DB) SaveLLMCall(call *models.LLMCall) error {
+	return db.Create(call).Error
+}
+
+// Moderation operations
+func (db *DB) SaveModerationDecision(decision *models.ModerationDecision) error {
+	return db.Create(decision).Error
+}
+
+// Security event operations
+func (db *DB) SaveSecurityEvent(event *models.SecurityEvent) error {
+	return db.Create(event).Error
+}
+
+// Response cache operations
+func (db *DB) SaveCachedResponse(entry *models.CachedResponse) error {
+	return db.Create(entry).Error
+}
+
+// FindCachedResponse returns the live entry for a question key, or nil
+func (db *DB) FindCachedResponse(scope, key string, now time.Time) (*models.CachedResponse, error) {
+	var entries []models.CachedResponse
+	err := db.Where(&models.CachedResponse{Scope: scope, Key: key}).
+		Where("expires_at > ?", now).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&entries).Error
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// GetEmbeddedCachedResponses returns the most recent live entries of a scope
+// that have an embedding, for similarity search
+func (db *DB) GetEmbeddedCachedResponses(scope string, now time.Time, limit int) ([]models.CachedResponse, error) {
+	var entries []models.CachedResponse
+	err := db.Where(&models.CachedResponse{Scope: scope}).
+		Where("expires_at > ? AND embedding <> ''", now).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&entries).Error
+	return entries, err
+}
+
+func (db *DB) IncrementCachedResponseHits(id uuid.UUID) error {
+	return db.Model(&models.CachedResponse{}).
+		Where("id = ?", id).
+		UpdateColumn("hits", gorm.Expr("hits + 1")).Error
+}
+
+func (db *DB) DeleteExpiredCachedResponses(now time.Time) (int64, error) {
+	result := db.Where("expires_at <= ?", now).Delete(&models.CachedResponse{})
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) CountCachedResponses(now time.Time) (int64, error) {
+	var count int64
+	err := db.Model(&models.CachedResponse{}).Where("expires_at > ?", now).Count(&count).Error
+	return count, err
+}
+
+// Queue operations
+
+// EnqueueMessages stores messages for processing and returns how many were
+// skipped as duplicates. A message with a DedupKey is a duplicate when the
+// key was recorded at or after since; older keys are refreshed.
+func (db *DB) EnqueueMessages(messages []models.QueuedMessage, since time.Time) (int, error) {
+	duplicates := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		accepted := make([]models.QueuedMessage, 0, len(messages))
+		for _, message := range messages {
+			if message.DedupKey != "" {
+				key := &models.InboundKey{
+					Key:       message.DedupKey,
+					Gateway:   message.Gateway,
+					SenderJID: message.SenderJID,
+					CreatedAt: message.CreatedAt,
+				}
+				result := tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "key"}},
+					DoUpdates: clause.AssignmentColumns([]string{"created_at"}),
+					Where: clause.Where{Exprs: []clause.Expression{
+						clause.Lt{Column: clause.Column{Table: "inbound_keys", Name: "created_at"}, Value: since},
+					}},
+				}).Create(key)
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					duplicates++
+					continue
+				}
+			}
+			accepted = append(accepted, message)
+		}
+
+		if len(accepted) == 0 {
+			return nil
+		}
+		return tx.Create(&accepted).Error
+	})
+	return duplicates, err
+}
+
+// OldestPendingMessage returns when the sender's oldest message waiting to
+// be claimed was queued, or the zero time when there is none
+func (db *DB) OldestPendingMessage(sender string) (time.Time, error) {
+	var oldest []time.Time
+	err := db.Model(&models.QueuedMessage{}).
+		Where("sender_jid = ? AND status = ?", sender, models.QueuePending).
+		Order("created_at").
+		Limit(1).
+		Pluck("created_at", &oldest).Error
+	if err != nil || len(oldest) == 0 {
+		return time.Time{}, err
+	}
+	return oldest[0], nil
+}
+
+// ClaimQueuedMessages locks the due messages of up to limit senders for a
+// worker, oldest sender first and each sender's messages in arrival order.
+// A sender is skipped while any of its messages is being processed or not
+// yet due, so one sender's messages are never handled out of order or in
+// parallel. Messages whose visibility timeout expired are claimed again. On
+// Postgres each sender is locked before its messages are claimed, so
+// several replicas can claim from the same table without racing for one
+// sender or blocking each other.
+func (db *DB) ClaimQueuedMessages(worker string, limit int, visibility time.Duration, now time.Time) ([]models.QueuedMessage, error) {
+	var claimed []models.QueuedMessage
+	active := []string{models.QueuePending, models.QueueProcessing}
+
+	// The queue is polled every second, so keep its queries out of the log
+	quiet := db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Warn)})
+	err := quiet.Transaction(func(tx *gorm.DB) error {
+		busy := tx.Model(&models.QueuedMessage{}).
+			Select("sender_jid").
+			Where("status IN ? AND available_at > ?", active, now)
+
+		var senders []string
+		err := tx.Model(&models.QueuedMessage{}).
+			Select("sender_jid").
+			Where("status IN ? AND available_at <= ?", active, now).
+			Where("sender_jid NOT IN (?)", busy).
+			Group("sender_jid").
+			Order("MIN(created_at)").
+			Limit(limit).
+			Pluck("sender_jid", &senders).Error
+		if err != nil || len(senders) == 0 {
+			return err
+		}
+		if senders, err = lockIdle(tx, &models.QueuedMessage{}, "sender_jid", senders, busy); err != nil || len(senders) == 0 {
+			return err
+		}
+
+		query := tx.Where("sender_jid IN ? AND status IN ? AND available_at <= ?", senders, active, now).
+			Order("created_at")
+		if tx.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		if err := query.Find(&claimed).Error; err != nil || len(claimed) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(claimed))
+		for i := range claimed {
+			ids[i] = claimed[i].ID
+			claimed[i].Status = models.QueueProcessing
+			claimed[i].Attempts++
+			claimed[i].LockedBy = worker
+		}
+		return tx.Model(&models.QueuedMessage{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":       models.QueueProcessing,
+				"available_at": now.Add(visibility),
+				"locked_by":    worker,
+				"attempts":     gorm.Expr("attempts + 1"),
+			}).Error
+	})
+	return claimed, err
+}
+
+// lockIdle takes a transaction-scoped advisory lock per key on Postgres and
+// returns the keys it locked that are still not busy. Another replica may
+// have picked the same keys before this transaction could see its claim:
+// while that claim's transaction runs it holds the lock and the key is
+// skipped, and once it commits the repeated busy check drops the key. Other
+// databases run a single instance and get the keys back unchanged.
+func lockIdle(tx *gorm.DB, model interface{}, column string, keys []string, busy *gorm.DB) ([]string, error) {
+	if tx.Dialector.Name() != "postgres" {
+		return keys, nil
+	}
+
+	locked := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var ok bool
+		// The column names the lock namespace, keeping queue and outbox locks apart
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(hashtext(?), hashtext(?))", column, key).Scan(&ok).Error; err != nil {
+			return nil, err
+		}
+		if ok {
+			locked = append(locked, key)
+		}
+	}
+	if len(locked) == 0 {
+		return nil, nil
+	}
+
+	var idle []string
+	err := tx.Model(model).
+		Distinct(column).
+		Where(column+" IN ?", locked).
+		Where(column+" NOT IN (?)", busy).
+		Pluck(column, &idle).Error
+	return idle, err
+}
+
+func (db *DB) CompleteQueuedMessage(id uuid.UUID, now time.Time) error {
+	return db.Model(&models.QueuedMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": models.QueueDone, "completed_at": now, "last_error": ""}).Error
+}
+
+// RetryQueuedMessage makes a failed message available again at retryAt
+func (db *DB) RetryQueuedMessage(id uuid.UUID, lastError string, retryAt time.Time) error {
+	return db.Model(&models.QueuedMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": models.QueuePending, "available_at": retryAt, "last_error": lastError}).Error
+}
+
+// ReleaseQueuedMessages returns claimed messages that were not attempted,
+// without counting the claim as an attempt
+func (db *DB) ReleaseQueuedMessages(ids []uuid.UUID, availableAt time.Time) error {
+	return db.Model(&models.QueuedMessage{}).
+		Where("id IN ?", ids).
+		Updates(map[string]interface{}{
+			"status":       models.QueuePending,
+			"available_at": availableAt,
+			"attempts":     gorm.Expr("attempts - 1"),
+		}).Error
+}
+
+// DeadLetterQueuedMessage parks a message that will not be retried
+func (db *DB) DeadLetterQueuedMessage(id uuid.UUID, lastError string, now time.Time) error {
+	return db.Model(&models.QueuedMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": models.QueueDead, "completed_at": now, "last_error": lastError}).Error
+}
+
+// DeleteCompletedQueuedMessages removes processed messages finished before
+// the given time. Dead letters are kept for inspection.
+func (db *DB) DeleteCompletedQueuedMessages(before time.Time) (int64, error) {
+	result := db.Where("status = ? AND completed_at < ?", models.QueueDone, before).Delete(&models.QueuedMessage{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteInboundKeys forgets idempotency keys recorded before the given time
+func (db *DB) DeleteInboundKeys(before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&models.InboundKey{})
+	return result.RowsAffected, result.Error
+}
+
+// CountQueuedMessages returns the number of messages in each state
+func (db *DB) CountQueuedMessages() (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := db.Model(&models.QueuedMessage{}).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, err
+}
+
+// Outbox operations
+
+// CreateOutboundMessages stores the parts of one reply together, so a
+// reply is never sent half-queued
+func (db *DB) CreateOutboundMessages(messages []models.OutboundMessage) error {
+	return db.Create(&messages).Error
+}
+
+// ClaimOutboundMessages locks the due messages of up to limit recipients
+// for a sender worker, each recipient's in the order they were queued. Like
+// ClaimQueuedMessages, a recipient is skipped while one of its messages is
+// being sent or waiting for a retry, so replies never overtake each other,
+// and on Postgres recipients are locked the same way before claiming.
+func (db *DB) ClaimOutboundMessages(worker string, limit int, visibility time.Duration, now time.Time) ([]models.OutboundMessage, error) {
+	var claimed []models.OutboundMessage
+	active := []string{models.OutboxPending, models.OutboxSending}
+
+	quiet := db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Warn)})
+	err := quiet.Transaction(func(tx *gorm.DB) error {
+		busy := tx.Model(&models.OutboundMessage{}).
+			Select("recipient_jid").
+			Where("status IN ? AND available_at > ?", active, now)
+
+		var recipients []string
+		err := tx.Model(&models.OutboundMessage{}).
+			Select("recipient_jid").
+			Where("status IN ? AND available_at <= ?", active, now).
+			Where("recipient_jid NOT IN (?)", busy).
+			Group("recipient_jid").
+			Order("MIN(created_at)").
+			Limit(limit).
+			Pluck("recipient_jid", &recipients).Error
+		if err != nil || len(recipients) == 0 {
+			return err
+		}
+		if recipients, err = lockIdle(tx, &models.OutboundMessage{}, "recipient_jid", recipients, busy); err != nil || len(recipients) == 0 {
+			return err
+		}
+
+		query := tx.Where("recipient_jid IN ? AND status IN ? AND available_at <= ?", recipients, active, now).
+			Order("created_at")
+		if tx.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		if err := query.Find(&claimed).Error; err != nil || len(claimed) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(claimed))
+		for i := range claimed {
+			ids[i] = claimed[i].ID
+			claimed[i].Status = models.OutboxSending
+			claimed[i].Attempts++
+			claimed[i].LockedBy = worker
+		}
+		return tx.Model(&models.OutboundMessage{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":       models.OutboxSending,
+				"available_at": now.Add(visibility),
+				"locked_by":    worker,
+				"attempts":     gorm.Expr("attempts + 1"),
+			}).Error
+	})
+	return claimed, err
+}
+
+// ErrLeaseLost is returned when an outgoing message is no longer claimed by
+// the worker updating it, because its visibility timeout expired and
+// another worker claimed it
+var ErrLeaseLost = errors.New("outgoing message was claimed by another worker")
+
+// ExtendOutboundLease keeps a worker's claimed messages from being claimed
+// again until the given time. It returns how many of them the worker still
+// holds.
+func (db *DB) ExtendOutboundLease(ids []uuid.UUID, worker string, until time.Time) (int64, error) {
+	result := db.Model(&models.OutboundMessage{}).
+		Where("id IN ? AND locked_by = ? AND status = ?", ids, worker, models.OutboxSending).
+		Update("available_at", until)
+	return result.RowsAffected, result.Error
+}
+
+// MarkOutboundSent records a delivered message and copies the provider ID
+// to the conversation message it belongs to. It returns ErrLeaseLost when
+// the worker that claimed the message no longer holds it.
+func (db *DB) MarkOutboundSent(message *models.OutboundMessage, providerID string, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.OutboundMessage{}).
+			Where("id = ? AND locked_by = ? AND status = ?", message.ID, message.LockedBy, models.OutboxSending).
+			Updates(map[string]interface{}{
+				"status":      models.OutboxSent,
+				"provider_id": providerID,
+				"sent_at":     now,
+				"last_error":  "",
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLeaseLost
+		}
+		if providerID == "" {
+			return nil
+		}
+
+		// Start the delivery history, so messages the gateway never reports
+		// on still count as sent
+		status := &models.MessageStatus{
+			ProviderID:   providerID,
+			MessageID:    message.MessageID,
+			RecipientJID: message.RecipientJID,
+			Status:       models.DeliverySent,
+			Timestamp:    now,
+		}
+		if err := tx.Create(status).Error; err != nil || message.MessageID == "" {
+			return err
+		}
+		return tx.Model(&models.Message{}).
+			Where("message_id = ?", message.MessageID).
+			Updates(map[string]interface{}{
+				"provider_message_id": providerID,
+				"delivery_status":     gorm.Expr("CASE WHEN delivery_status IS NULL OR delivery_status = '' THEN ? ELSE delivery_status END", models.DeliverySent),
+			}).Error
+	})
+}
+
+// RetryOutboundMessage makes a failed send available again at retryAt. It
+// returns ErrLeaseLost when worker no longer holds the message.
+func (db *DB) RetryOutboundMessage(id uuid.UUID, worker, lastError string, retryAt time.Time) error {
+	result := db.Model(&models.OutboundMessage{}).
+		Where("id = ? AND locked_by = ? AND status = ?", id, worker, models.OutboxSending).
+		Updates(map[string]interface{}{"status": models.OutboxPending, "available_at": retryAt, "last_error": lastError})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return result.Error
+}
+
+// ReleaseOutboundMessages returns claimed messages that were not attempted,
+// without counting the claim as an attempt. Messages worker no longer holds
+// are left alone.
+func (db *DB) ReleaseOutboundMessages(ids []uuid.UUID, worker string, availableAt time.Time) error {
+	return db.Model(&models.OutboundMessage{}).
+		Where("id IN ? AND locked_by = ? AND status = ?", ids, worker, models.OutboxSending).
+		Updates(map[string]interface{}{
+			"status":       models.OutboxPending,
+			"available_at": availableAt,
+			"attempts":     gorm.Expr("attempts - 1"),
+		}).Error
+}
+
+// FailOutboundMessage gives up on a message. It returns ErrLeaseLost when
+// worker no longer holds the message.
+func (db *DB) FailOutboundMessage(id uuid.UUID, worker, lastError string) error {
+	result := db.Model(&models.OutboundMessage{}).
+		Where("id = ? AND locked_by = ? AND status = ?", id, worker, models.OutboxSending).
+		Updates(map[string]interface{}{"status": models.OutboxFailed, "last_error": lastError})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return result.Error
+}
+
+// SentByBot reports whether the gateway message ID belongs to a message the
+// bot sent
+func (db *DB) SentByBot(providerID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.OutboundMessage{}).Where("provider_id = ?", providerID).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	err = db.Model(&models.Message{}).Where("provider_message_id = ? AND is_from_me = ?", providerID, true).Count(&count).Error
+	return count > 0, err
+}
+
+// DeleteSentOutboundMessages removes messages sent before the given time.
+// Failed messages are kept for inspection.
+func (db *DB) DeleteSentOutboundMessages(before time.Time) (int64, error) {
+	result := db.Where("status = ? AND sent_at < ?", models.OutboxSent, before).Delete(&models.OutboundMessage{})
+	return result.RowsAffected, result.Error
+}
+
+// CountOutboundMessages returns the number of outbox messages in each state
+func (db *DB) CountOutboundMessages() (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := db.Model(&models.OutboundMessage{}).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, err
+}
+
+// Delivery status operations
+
+// RecordMessageStatus stores a delivery update for the sent message with
+// the status's provider ID and advances the state of the conversation
+// message it belongs to. States only move forward, since gateways may
+// report them out of order. It returns false when no sent message has the
+// provider ID.
+func (db *DB) RecordMessageStatus(status *models.MessageStatus) (bool, error) {
+	matched := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var sent []models.OutboundMessage
+		if err := tx.Where("provider_id = ?", status.ProviderID).Limit(1).Find(&sent).Error; err != nil {
+			return err
+		}
+		if len(sent) > 0 {
+			status.MessageID = sent[0].MessageID
+			status.RecipientJID = sent[0].RecipientJID
+		} else {
+			// The outbox row may have been cleaned up already
+			var messages []models.Message
+			if err := tx.Where("provider_message_id = ?", status.ProviderID).Limit(1).Find(&messages).Error; err != nil {
+				return err
+			}
+			if len(messages) == 0 {
+				return nil
+			}
+			status.MessageID = messages[0].MessageID
+			status.RecipientJID = messages[0].ToJID
+		}
+		matched = true
+
+		if err := tx.Create(status).Error; err != nil || status.MessageID == "" {
+			return err
+		}
+
+		update := tx.Model(&models.Message{}).Where("message_id = ?", status.MessageID)
+		switch status.Status {
+		case models.DeliveryRead:
+			return update.Where("delivery_status IS NULL OR delivery_status <> ?", models.DeliveryRead).
+				Updates(map[string]interface{}{
+					"delivery_status": models.DeliveryRead,
+					"read_at":         status.Timestamp,
+					"delivered_at":    gorm.Expr("COALESCE(delivered_at, ?)", status.Timestamp),
+				}).Error
+		case models.DeliveryDelivered:
+			return update.Where("delivery_status IS NULL OR delivery_status NOT IN ?", []string{models.DeliveryDelivered, models.DeliveryRead}).
+				Updates(map[string]interface{}{
+					"delivery_status": models.DeliveryDelivered,
+					"delivered_at":    status.Timestamp,
+				}).Error
+		case models.DeliveryFailed:
+			return update.Where("delivery_status IS NULL OR delivery_status IN ?", []string{"", models.DeliverySent}).
+				Update("delivery_status", models.DeliveryFailed).Error
+		default:
+			return update.Where("delivery_status IS NULL OR delivery_status = ''").
+				Update("delivery_status", status.Status).Error
+		}
+	})
+	return matched, err
+}
+
+// DeliveryTotals counts distinct sent messages by the states they reached.
+// Read messages count as delivered too; failed ones never arrived.
+type DeliveryTotals struct {
+	Sent      int64 `json:"sent"`
+	Delivered int64 `json:"delivered"`
+	Read      int64 `json:"read"`
+	Failed    int64 `json:"failed"`
+}
+
+// GetDeliveryTotals counts messages with delivery updates since the given
+// time
+func (db *DB) GetDeliveryTotals(since time.Time) (DeliveryTotals, error) {
+	var totals DeliveryTotals
+	count := func(states []string, into *int64) error {
+		query := db.Model(&models.MessageStatus{}).Where("created_at >= ?", since)
+		if len(states) > 0 {
+			query = query.Where("status IN ?", states)
+		}
+		return query.Distinct("provider_id").Count(into).Error
+	}
+
+	if err := count(nil, &totals.Sent); err != nil {
+		return totals, err
+	}
+	if err := count([]string{models.DeliveryDelivered, models.DeliveryRead}, &totals.Delivered); err != nil {
+		return totals, err
+	}
+	if err := count([]string{models.DeliveryRead}, &totals.Read); err != nil {
+		return totals, err
+	}
+
+	// A failure reported for a message that arrived anyway does not count
+	arrived := db.Model(&models.MessageStatus{}).
+		Select("provider_id").
+		Where("status IN ?", []string{models.DeliveryDelivered, models.DeliveryRead})
+	err := db.Model(&models.MessageStatus{}).
+		Where("created_at >= ? AND status = ?", since, models.DeliveryFailed).
+		Where("provider_id NOT IN (?)", arrived).
+		Distinct("provider_id").
+		Count(&totals.Failed).Error
+	return totals, err
+}
+
+// Media operations
+
+// GetMediaByHash returns the stored file with the given content hash, or
+// nil when there is none
+func (db *DB) GetMediaByHash(hash string) (*models.Media, error) {
+	var media []models.Media
+	if err := db.Where("hash = ?", hash).Limit(1).Find(&media).Error; err != nil {
+		return nil, err
+	}
+	if len(media) == 0 {
+		return nil, nil
+	}
+	return &media[0], nil
+}
+
+func (db *DB) GetMedia(id uuid.UUID) (*models.Media, error) {
+	var media models.Media
+	err := db.First(&media, "id = ?", id).Error
+	return &media, err
+}
+
+// CreateMedia records a stored file. When the same content was recorded
+// concurrently, the existing row is returned instead.
+func (db *DB) CreateMedia(media *models.Media) (*models.Media, error) {
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoNothing: true,
+	}).Create(media)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return db.GetMediaByHash(media.Hash)
+	}
+	return media, nil
+}
+
+// LinkMessageMedia records that a message carried a stored file
+func (db *DB) LinkMessageMedia(messageID string, mediaID uuid.UUID) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MessageMedia{
+		MessageID: messageID,
+		MediaID:   mediaID,
+	}).Error
+}
+
+// MediaTotals counts stored files and their combined size
+type MediaTotals struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (db *DB) GetMediaTotals() (MediaTotals, error) {
+	var totals MediaTotals
+	err := db.Model(&models.Media{}).
+		Select("COUNT(*) AS files, COALESCE(SUM(size), 0) AS bytes").
+		Scan(&totals).Error
+	return totals, err
+}
+
+// UsageTotals aggregates token usage and cost for a group of LLM calls
+type UsageTotals struct {
+	Name             string  `json:"name"`
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+const usageColumns = "COUNT(*) AS calls, " +
+	"COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(cached_tokens), 0) AS cached_tokens, " +
+	"COALESCE(SUM(total_tokens), 0) AS total_tokens, " +
+	"COALESCE(SUM(cost), 0) AS cost"
+
+// GetUsageTotals returns the overall LLM usage since the given time
+func (db *DB) GetUsageTotals(since time.Time) (*UsageTotals, error) {
+	var totals UsageTotals
+	err := db.Model(&models.LLMCall{}).
+		Select(usageColumns).
+		Where("created_at >= ?", since).
+		Scan(&totals).Error
+	return &totals, err
+}
+
+// GetUsageBy returns LLM usage since the given time grouped by one of
+// "day", "model" or "sender"
+func (db *DB) GetUsageBy(group string, since time.Time) ([]UsageTotals, error) {
+	var expr string
+	switch group {
+	case "day":
+		expr = "CAST(DATE(created_at) AS TEXT)"
+	case "model":
+		expr = "model"
+	case "sender":
+		expr = "sender_jid"
+	default:
+		return nil, fmt.Errorf("unsupported usage grouping: %s", group)
+	}
+
+	var totals []UsageTotals
+	err := db.Model(&models.LLMCall{}).
+		Select(expr+" AS name, "+usageColumns).
+		Where("created_at >= ?", since).
+		Group(expr).
+		Order("name").
+		Scan(&totals).Error
+	return totals, err
 }
\ No newline at end of file
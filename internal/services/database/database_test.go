@@ -0,0 +1,132 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example-tool-call/internal/models"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	return db
+}
+
+// sendReply saves an assistant message the way the handler does, before
+// its content is known, and delivers it through the outbox
+func sendReply(t *testing.T, db *DB, messageID, providerID string) {
+	t.Helper()
+	now := time.Now()
+	if err := db.SaveMessage(&models.Message{
+		MessageID:   messageID,
+		FromJID:     "bot",
+		ToJID:       "628123@s.whatsapp.net",
+		MessageType: "text",
+		IsFromMe:    true,
+		Timestamp:   now,
+	}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if err := db.CreateOutboundMessages([]models.OutboundMessage{{
+		RecipientJID: "628123@s.whatsapp.net",
+		MessageID:    messageID,
+		Kind:         "text",
+		Text:         "hello",
+		Status:       models.OutboxPending,
+		AvailableAt:  now,
+	}}); err != nil {
+		t.Fatalf("CreateOutboundMessages: %v", err)
+	}
+	claimed, err := db.ClaimOutboundMessages("worker", 1, time.Minute, now)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("claimed %d messages (%v)", len(claimed), err)
+	}
+	if err := db.MarkOutboundSent(&claimed[0], providerID, now); err != nil {
+		t.Fatalf("MarkOutboundSent: %v", err)
+	}
+}
+
+func getMessage(t *testing.T, db *DB, messageID string) models.Message {
+	t.Helper()
+	var message models.Message
+	if err := db.Where("message_id = ?", messageID).First(&message).Error; err != nil {
+		t.Fatalf("load message: %v", err)
+	}
+	return message
+}
+
+func TestRecordMessageStatusOnlyMovesForward(t *testing.T) {
+	db := newTestDB(t)
+	sendReply(t, db, "reply-1", "provider-1")
+
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{models.DeliveryDelivered, models.DeliveryDelivered},
+		{models.DeliverySent, models.DeliveryDelivered},
+		{models.DeliveryRead, models.DeliveryRead},
+		{models.DeliveryDelivered, models.DeliveryRead},
+		{models.DeliveryFailed, models.DeliveryRead},
+	}
+	for _, tt := range tests {
+		matched, err := db.RecordMessageStatus(&models.MessageStatus{
+			ProviderID: "provider-1",
+			Status:     tt.status,
+			Timestamp:  time.Now(),
+		})
+		if err != nil || !matched {
+			t.Fatalf("RecordMessageStatus(%s) = %v, %v", tt.status, matched, err)
+		}
+		if got := getMessage(t, db, "reply-1").DeliveryStatus; got != tt.want {
+			t.Errorf("after %s: status %q, want %q", tt.status, got, tt.want)
+		}
+	}
+
+	message := getMessage(t, db, "reply-1")
+	if message.DeliveredAt == nil || message.ReadAt == nil {
+		t.Errorf("delivered at %v, read at %v", message.DeliveredAt, message.ReadAt)
+	}
+
+	matched, err := db.RecordMessageStatus(&models.MessageStatus{ProviderID: "unknown", Status: models.DeliveryRead, Timestamp: time.Now()})
+	if err != nil || matched {
+		t.Errorf("unknown provider ID: matched %v, %v", matched, err)
+	}
+}
+
+func TestRecordMessageStatusFailure(t *testing.T) {
+	db := newTestDB(t)
+	sendReply(t, db, "reply-1", "provider-1")
+
+	if _, err := db.RecordMessageStatus(&models.MessageStatus{ProviderID: "provider-1", Status: models.DeliveryFailed, Timestamp: time.Now()}); err != nil {
+		t.Fatalf("RecordMessageStatus: %v", err)
+	}
+	if got := getMessage(t, db, "reply-1").DeliveryStatus; got != models.DeliveryFailed {
+		t.Errorf("status %q, want failed", got)
+	}
+}
+
+func TestSetMessageContentKeepsDelivery(t *testing.T) {
+	db := newTestDB(t)
+	// The reply is delivered and read before the handler fills it in
+	sendReply(t, db, "reply-1", "provider-1")
+	if _, err := db.RecordMessageStatus(&models.MessageStatus{ProviderID: "provider-1", Status: models.DeliveryRead, Timestamp: time.Now()}); err != nil {
+		t.Fatalf("RecordMessageStatus: %v", err)
+	}
+
+	if err := db.SetMessageContent("reply-1", "hello", "default tier"); err != nil {
+		t.Fatalf("SetMessageContent: %v", err)
+	}
+	message := getMessage(t, db, "reply-1")
+	if message.Content != "hello" || message.RouteReason != "default tier" {
+		t.Errorf("content %q, route reason %q", message.Content, message.RouteReason)
+	}
+	if message.ProviderMessageID != "provider-1" || message.DeliveryStatus != models.DeliveryRead {
+		t.Errorf("provider ID %q, status %q, want provider-1 read", message.ProviderMessageID, message.DeliveryStatus)
+	}
+}
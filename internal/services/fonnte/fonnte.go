@@ -2,34 +2,175 @@ package fonnte
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/messenger"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	// ErrInvalidToken is returned when Fonnte rejects the API key
+	ErrInvalidToken = errors.New("fonnte: invalid token")
+
+	// ErrDisconnected is returned when the sending device is not connected
+	ErrDisconnected = errors.New("fonnte: device disconnected")
+
+	// ErrQuota is returned when the account has no message quota left
+	ErrQuota = errors.New("fonnte: quota exceeded")
+)
+
+// APIError is a request Fonnte answered but refused. It unwraps to one of
+// the sentinel errors when the reason is recognized, and to
+// messenger.ErrPermanent when retrying cannot help.
+type APIError struct {
+	StatusCode int
+	Reason     string
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("fonnte API error (status %d): %s", e.StatusCode, e.Reason)
+}
+
+func (e *APIError) Unwrap() []error {
+	switch e.kind {
+	case nil:
+		return nil
+	case ErrInvalidToken, ErrQuota:
+		return []error{e.kind, messenger.ErrPermanent}
+	default:
+		return []error{e.kind}
+	}
+}
+
+func newAPIError(statusCode int, reason string) *APIError {
+	err := &APIError{StatusCode: statusCode, Reason: reason}
+	lower := strings.ToLower(reason)
+	switch {
+	case strings.Contains(lower, "token") || statusCode == http.StatusUnauthorized:
+		err.kind = ErrInvalidToken
+	case strings.Contains(lower, "disconnect"):
+		err.kind = ErrDisconnected
+	case strings.Contains(lower, "quota"):
+		err.kind = ErrQuota
+	}
+	return err
+}
+
 type Service struct {
-	apiKey string
-	client *http.Client
-	logger *logrus.Logger
+	apiKey      string
+	baseURL     string
+	countryCode string
+	typing      bool
+	client      *http.Client
+	logger      *logrus.Logger
 }
 
-type SendMessageRequest struct {
-	Target  string `json:"target"`
-	Message string `json:"message"`
-	Type    string `json:"type,omitempty"`
+// Message is one request to the Fonnte /send endpoint. Target and at least
+// one of Message, URL, Location or Choices are required.
+type Message struct {
+	// Target is a number or group ID; Targets sends the same message to
+	// several of them
+	Target  string
+	Targets []string
+
+	Message string
+
+	// URL attaches an image, video, audio file or document. Filename
+	// names documents.
+	URL      string
+	Filename string
+
+	// Location is sent as "latitude,longitude"
+	Location string
+
+	// Choices turns the message into a poll named PollName. Select is
+	// "single" or "multiple".
+	Choices  []string
+	Select   string
+	PollName string
+
+	// Delay waits between recipients of a multi-target send, as seconds
+	// or a random range such as "2-5"
+	Delay string
+
+	// Schedule queues the message on Fonnte's side until the given time
+	Schedule time.Time
+
+	// CountryCode replaces leading zeros in Target; it defaults to the
+	// configured code
+	CountryCode string
+
+	// Typing shows the typing indicator before the message is delivered
+	Typing bool
+}
+
+type sendRequest struct {
+	Target      string `json:"target,omitempty"`
+	Message     string `json:"message,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Location    string `json:"location,omitempty"`
+	Choices     string `json:"choices,omitempty"`
+	Select      string `json:"select,omitempty"`
+	PollName    string `json:"pollname,omitempty"`
+	Delay       string `json:"delay,omitempty"`
+	Schedule    int64  `json:"schedule,omitempty"`
+	CountryCode string `json:"countryCode,omitempty"`
+	Typing      bool   `json:"typing,omitempty"`
+	Data        string `json:"data,omitempty"`
 }
 
 type SendMessageResponse struct {
-	Status  bool   `json:"status"`
-	Message string `json:"message"`
-	ID      string `json:"id,omitempty"`
+	Status    bool       `json:"status"`
+	Detail    string     `json:"detail,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Process   string     `json:"process,omitempty"`
+	RequestID int64      `json:"requestid,omitempty"`
+	IDs       stringList `json:"id,omitempty"`
+	Targets   stringList `json:"target,omitempty"`
+}
+
+// ID returns the message ID of the first recipient
+func (r *SendMessageResponse) ID() string {
+	if len(r.IDs) == 0 {
+		return ""
+	}
+	return r.IDs[0]
+}
+
+// stringList accepts both a single value and an array, since Fonnte
+// answers single-target sends either way
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		list = []json.RawMessage{data}
+	}
+
+	*l = (*l)[:0]
+	for _, raw := range list {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			// IDs are sometimes numbers
+			s = string(raw)
+		}
+		*l = append(*l, s)
+	}
+	return nil
 }
 
 type WebhookMessage struct {
+	InboxID   string `json:"inboxid"`
 	Device    string `json:"device"`
 	Sender    string `json:"sender"`
 	Message   string `json:"message"`
@@ -37,12 +178,17 @@ type WebhookMessage struct {
 	Name      string `json:"name"`
 	Location  string `json:"location"`
 	File      string `json:"file"`
+	URL       string `json:"url"`
 	Filename  string `json:"filename"`
+	Extension string `json:"extension"`
 }
 
-func New(apiKey string, logger *logrus.Logger) *Service {
+func New(cfg config.FontteConfig, logger *logrus.Logger) *Service {
 	return &Service{
-		apiKey: apiKey,
+		apiKey:      cfg.APIKey,
+		baseURL:     strings.TrimRight(cfg.BaseURL, "/"),
+		countryCode: cfg.CountryCode,
+		typing:      cfg.Typing,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -50,81 +196,107 @@ func New(apiKey string, logger *logrus.Logger) *Service {
 	}
 }
 
+// SendMessage sends a text message
 func (s *Service) SendMessage(target, message string) (*SendMessageResponse, error) {
-	req := SendMessageRequest{
-		Target:  target,
-		Message: message,
-		Type:    "text",
-	}
+	return s.Send(context.Background(), Message{Target: target, Message: message})
+}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
+// SendImage sends an image by URL with a caption
+func (s *Service) SendImage(target, imageURL, caption string) (*SendMessageResponse, error) {
+	return s.Send(context.Background(), Message{Target: target, URL: imageURL, Message: caption})
+}
 
-	httpReq, err := http.NewRequest("POST", "https://api.fonnte.com/send", bytes.NewBuffer(jsonData))
+// Send delivers one message, to one or several targets
+func (s *Service) Send(ctx context.Context, msg Message) (*SendMessageResponse, error) {
+	req, err := s.buildRequest(msg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	httpReq.Header.Set("Authorization", s.apiKey)
-	httpReq.Header.Set("Content-Type", "application/json")
-
 	s.logger.WithFields(logrus.Fields{
-		"target":  target,
-		"message": message,
+		"target":   req.Target,
+		"message":  msg.Message,
+		"url":      msg.URL,
+		"schedule": req.Schedule,
 	}).Debug("Sending message via Fonnte")
 
-	start := time.Now()
-	resp, err := s.client.Do(httpReq)
-	duration := time.Since(start)
+	return s.do(ctx, req)
+}
 
-	if err != nil {
-		s.logger.WithFields(logrus.Fields{
-			"error":    err.Error(),
-			"duration": duration,
-		}).Error("Fonnte request failed")
-		return nil, fmt.Errorf("request failed: %w", err)
+// SendBulk delivers a different message to each target in one request.
+// Delay on each message spaces out the deliveries.
+func (s *Service) SendBulk(ctx context.Context, msgs []Message) (*SendMessageResponse, error) {
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("no messages to send")
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	data := make([]sendRequest, 0, len(msgs))
+	for i, msg := range msgs {
+		req, err := s.buildRequest(msg)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		data = append(data, req)
 	}
 
-	var response SendMessageResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bulk messages: %w", err)
 	}
 
-	s.logger.WithFields(logrus.Fields{
-		"duration": duration,
-		"status":   response.Status,
-		"id":       response.ID,
-	}).Info("Fonnte message sent")
-
-	if !response.Status {
-		return nil, fmt.Errorf("fonnte API error: %s", response.Message)
-	}
+	s.logger.WithField("messages", len(msgs)).Debug("Sending bulk messages via Fonnte")
 
-	return &response, nil
+	return s.do(ctx, sendRequest{Data: string(encoded)})
 }
 
-func (s *Service) SendImage(target, imageURL, caption string) (*SendMessageResponse, error) {
-	req := map[string]interface{}{
-		"target":  target,
-		"file":    imageURL,
-		"caption": caption,
-		"type":    "image",
+func (s *Service) buildRequest(msg Message) (sendRequest, error) {
+	targets := msg.Targets
+	if msg.Target != "" {
+		targets = append([]string{msg.Target}, targets...)
+	}
+	if len(targets) == 0 {
+		return sendRequest{}, fmt.Errorf("target is required")
+	}
+	if msg.Message == "" && msg.URL == "" && msg.Location == "" && len(msg.Choices) == 0 {
+		return sendRequest{}, fmt.Errorf("message, url, location or choices is required")
+	}
+	if len(msg.Choices) > 0 && len(msg.Choices) < 2 {
+		return sendRequest{}, fmt.Errorf("a poll needs at least two choices")
 	}
 
+	req := sendRequest{
+		Target:      strings.Join(targets, ","),
+		Message:     msg.Message,
+		URL:         msg.URL,
+		Filename:    msg.Filename,
+		Location:    msg.Location,
+		Choices:     strings.Join(msg.Choices, ","),
+		Select:      msg.Select,
+		PollName:    msg.PollName,
+		Delay:       msg.Delay,
+		CountryCode: msg.CountryCode,
+		Typing:      msg.Typing || s.typing,
+	}
+	if req.CountryCode == "" {
+		req.CountryCode = s.countryCode
+	}
+	if len(msg.Choices) > 0 && req.Select == "" {
+		req.Select = "single"
+	}
+	if !msg.Schedule.IsZero() {
+		req.Schedule = msg.Schedule.Unix()
+	}
+	return req, nil
+}
+
+// do posts a request to /send and turns refusals into APIError
+func (s *Service) do(ctx context.Context, req sendRequest) (*SendMessageResponse, error) {
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", "https://api.fonnte.com/send", bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/send", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -132,12 +304,6 @@ func (s *Service) SendImage(target, imageURL, caption string) (*SendMessageRespo
 	httpReq.Header.Set("Authorization", s.apiKey)
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	s.logger.WithFields(logrus.Fields{
-		"target":    target,
-		"image_url": imageURL,
-		"caption":   caption,
-	}).Debug("Sending image via Fonnte")
-
 	start := time.Now()
 	resp, err := s.client.Do(httpReq)
 	duration := time.Since(start)
@@ -146,7 +312,7 @@ func (s *Service) SendImage(target, imageURL, caption string) (*SendMessageRespo
 		s.logger.WithFields(logrus.Fields{
 			"error":    err.Error(),
 			"duration": duration,
-		}).Error("Fonnte image request failed")
+		}).Error("Fonnte request failed")
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
@@ -158,18 +324,25 @@ func (s *Service) SendImage(target, imageURL, caption string) (*SendMessageRespo
 
 	var response SendMessageResponse
 	if err := json.Unmarshal(body, &response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, newAPIError(resp.StatusCode, strings.TrimSpace(string(body)))
+		}
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	s.logger.WithFields(logrus.Fields{
 		"duration": duration,
 		"status":   response.Status,
-		"id":       response.ID,
-	}).Info("Fonnte image sent")
+		"id":       response.ID(),
+	}).Info("Fonnte message sent")
 
-	if !response.Status {
-		return nil, fmt.Errorf("fonnte API error: %s", response.Message)
+	if !response.Status || resp.StatusCode != http.StatusOK {
+		reason := response.Reason
+		if reason == "" {
+			reason = response.Detail
+		}
+		return nil, newAPIError(resp.StatusCode, reason)
 	}
 
 	return &response, nil
-}
\ No newline at end of file
+}
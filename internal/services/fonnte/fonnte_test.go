@@ -0,0 +1,255 @@
+package fonnte
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestService starts a fake Fonnte API that answers every request with
+// status and body, and returns the decoded request bodies it received
+func newTestService(t *testing.T, status int, body string) (*Service, *[]map[string]interface{}) {
+	t.Helper()
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/send" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "test-token" {
+			t.Errorf("Authorization = %q, want test-token", got)
+		}
+		var decoded map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&decoded); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		requests = append(requests, decoded)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := New(config.FontteConfig{APIKey: "test-token", BaseURL: server.URL + "/", CountryCode: "62"}, logger)
+	return s, &requests
+}
+
+func TestSendSingleTarget(t *testing.T) {
+	s, requests := newTestService(t, http.StatusOK, `{"status":true,"detail":"success! message in queue","id":["80367170"],"process":"pending","target":["6281234567890"]}`)
+
+	resp, err := s.Send(context.Background(), Message{Target: "081234567890", Message: "hello"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.ID() != "80367170" {
+		t.Errorf("ID = %q, want 80367170", resp.ID())
+	}
+
+	req := (*requests)[0]
+	if req["target"] != "081234567890" || req["message"] != "hello" || req["countryCode"] != "62" {
+		t.Errorf("unexpected request %v", req)
+	}
+	if _, ok := req["data"]; ok {
+		t.Errorf("single send must not set data: %v", req)
+	}
+}
+
+func TestSendMultipleTargets(t *testing.T) {
+	s, requests := newTestService(t, http.StatusOK, `{"status":true,"id":["1","2","3"],"target":["6281","6282","6283"]}`)
+
+	resp, err := s.Send(context.Background(), Message{
+		Target:  "6281",
+		Targets: []string{"6282", "6283"},
+		Message: "promo",
+		Delay:   "2-5",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(resp.IDs) != 3 || len(resp.Targets) != 3 {
+		t.Errorf("got ids %v targets %v, want three of each", resp.IDs, resp.Targets)
+	}
+
+	req := (*requests)[0]
+	if req["target"] != "6281,6282,6283" || req["delay"] != "2-5" {
+		t.Errorf("unexpected request %v", req)
+	}
+}
+
+func TestSendBulkData(t *testing.T) {
+	s, requests := newTestService(t, http.StatusOK, `{"status":true,"id":["1","2"]}`)
+
+	_, err := s.SendBulk(context.Background(), []Message{
+		{Target: "6281", Message: "hi Ana", Delay: "2"},
+		{Target: "6282", Message: "hi Budi", URL: "https://example.com/a.jpg"},
+	})
+	if err != nil {
+		t.Fatalf("SendBulk: %v", err)
+	}
+
+	req := (*requests)[0]
+	if _, ok := req["target"]; ok {
+		t.Errorf("bulk send must only set data: %v", req)
+	}
+	encoded, ok := req["data"].(string)
+	if !ok {
+		t.Fatalf("data is not a JSON string: %v", req["data"])
+	}
+	var data []map[string]interface{}
+	if err := json.Unmarshal([]byte(encoded), &data); err != nil {
+		t.Fatalf("decode data: %v", err)
+	}
+	if len(data) != 2 {
+		t.Fatalf("got %d bulk messages, want 2", len(data))
+	}
+	if data[0]["target"] != "6281" || data[0]["message"] != "hi Ana" || data[0]["delay"] != "2" {
+		t.Errorf("unexpected first message %v", data[0])
+	}
+	if data[1]["target"] != "6282" || data[1]["url"] != "https://example.com/a.jpg" {
+		t.Errorf("unexpected second message %v", data[1])
+	}
+
+	if _, err := s.SendBulk(context.Background(), []Message{{Target: "6281"}}); err == nil {
+		t.Error("SendBulk accepted a message without content")
+	}
+}
+
+func TestSendSchedule(t *testing.T) {
+	s, requests := newTestService(t, http.StatusOK, `{"status":true,"id":["1"]}`)
+
+	at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := s.Send(context.Background(), Message{Target: "6281", Message: "later", Schedule: at}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// JSON numbers decode as float64
+	if got := (*requests)[0]["schedule"]; got != float64(at.Unix()) {
+		t.Errorf("schedule = %v, want %d", got, at.Unix())
+	}
+}
+
+func TestSendPoll(t *testing.T) {
+	s, requests := newTestService(t, http.StatusOK, `{"status":true,"id":["1"]}`)
+
+	if _, err := s.Send(context.Background(), Message{Target: "6281", PollName: "Lunch?", Choices: []string{"yes"}}); err == nil {
+		t.Error("Send accepted a poll with one choice")
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("invalid poll reached the API")
+	}
+
+	if _, err := s.Send(context.Background(), Message{Target: "6281", PollName: "Lunch?", Choices: []string{"yes", "no"}}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	req := (*requests)[0]
+	if req["choices"] != "yes,no" || req["select"] != "single" || req["pollname"] != "Lunch?" {
+		t.Errorf("unexpected poll request %v", req)
+	}
+}
+
+func TestSendValidation(t *testing.T) {
+	s, requests := newTestService(t, http.StatusOK, `{"status":true}`)
+
+	if _, err := s.Send(context.Background(), Message{Message: "no target"}); err == nil {
+		t.Error("Send accepted a message without target")
+	}
+	if _, err := s.Send(context.Background(), Message{Target: "6281"}); err == nil {
+		t.Error("Send accepted a message without content")
+	}
+	if len(*requests) != 0 {
+		t.Errorf("invalid messages reached the API")
+	}
+}
+
+func TestStringList(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`"80367170"`, []string{"80367170"}},
+		{`80367170`, []string{"80367170"}},
+		{`["1","2"]`, []string{"1", "2"}},
+		{`[1,2]`, []string{"1", "2"}},
+		{`[]`, []string{}},
+	}
+	for _, tt := range tests {
+		var list stringList
+		if err := json.Unmarshal([]byte(tt.input), &list); err != nil {
+			t.Errorf("%s: %v", tt.input, err)
+			continue
+		}
+		if len(list) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.input, list, tt.want)
+			continue
+		}
+		for i := range list {
+			if list[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.input, list, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"invalid token", http.StatusOK, `{"status":false,"reason":"invalid token"}`, ErrInvalidToken},
+		{"disconnected", http.StatusOK, `{"status":false,"reason":"device disconnected"}`, ErrDisconnected},
+		{"quota", http.StatusOK, `{"status":false,"detail":"insufficient quota"}`, ErrQuota},
+		{"unauthorized", http.StatusUnauthorized, `Unauthorized`, ErrInvalidToken},
+		{"unauthorized json", http.StatusUnauthorized, `{"status":false,"reason":"unknown"}`, ErrInvalidToken},
+		{"other", http.StatusOK, `{"status":false,"reason":"target invalid"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestService(t, tt.status, tt.body)
+
+			_, err := s.Send(context.Background(), Message{Target: "6281", Message: "hello"})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want *APIError", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			for _, sentinel := range []error{ErrInvalidToken, ErrDisconnected, ErrQuota} {
+				if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %v", sentinel, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPermanentErrors(t *testing.T) {
+	tests := []struct {
+		reason    string
+		permanent bool
+	}{
+		{"invalid token", true},
+		{"insufficient quota", true},
+		{"device disconnected", false},
+		{"target invalid", false},
+	}
+	for _, tt := range tests {
+		s, _ := newTestService(t, http.StatusOK, `{"status":false,"reason":"`+tt.reason+`"}`)
+		_, err := s.Send(context.Background(), Message{Target: "6281", Message: "hello"})
+		if got := errors.Is(err, messenger.ErrPermanent); got != tt.permanent {
+			t.Errorf("%s: permanent = %v, want %v", tt.reason, got, tt.permanent)
+		}
+	}
+}
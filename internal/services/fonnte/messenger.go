@@ -0,0 +1,148 @@
+package fonnte
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"example-tool-call/internal/services/messenger"
+)
+
+// Name implements messenger.Messenger
+func (s *Service) Name() string {
+	return messenger.GatewayFonnte
+}
+
+// SendText implements messenger.Messenger
+func (s *Service) SendText(ctx context.Context, to, text string) (string, error) {
+	resp, err := s.Send(ctx, Message{Target: to, Message: text})
+	if err != nil {
+		return "", err
+	}
+	return resp.ID(), nil
+}
+
+// SendMedia implements messenger.Messenger. Fonnte detects the media type
+// from the file URL.
+func (s *Service) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	resp, err := s.Send(ctx, Message{
+		Target:   to,
+		Message:  media.Caption,
+		URL:      media.URL,
+		Filename: media.Filename,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.ID(), nil
+}
+
+// ParseWebhook implements messenger.Messenger. Fonnte does not sign its
+// webhooks, so any well-formed payload is accepted.
+func (s *Service) ParseWebhook(r *http.Request) ([]messenger.Inbound, error) {
+	var webhook WebhookMessage
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	// Status updates posted to the message webhook have no sender
+	if webhook.Sender == "" {
+		return nil, nil
+	}
+	return []messenger.Inbound{webhook.Inbound()}, nil
+}
+
+// statusWebhook is the payload of Fonnte's message status webhook. Status
+// is the sending result and State how far delivery got.
+type statusWebhook struct {
+	Device string     `json:"device"`
+	ID     stringList `json:"id"`
+	Status string     `json:"status"`
+	State  string     `json:"state"`
+}
+
+// ParseStatus implements messenger.StatusParser. The status webhook is set
+// separately from the message webhook in the Fonnte device settings.
+func (s *Service) ParseStatus(r *http.Request) ([]messenger.Status, error) {
+	var webhook statusWebhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		return nil, fmt.Errorf("invalid status payload: %w", err)
+	}
+
+	state, ok := deliveryState(webhook.State)
+	if !ok {
+		state, ok = deliveryState(webhook.Status)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	statuses := make([]messenger.Status, 0, len(webhook.ID))
+	for _, id := range webhook.ID {
+		status := messenger.Status{
+			ProviderID: id,
+			State:      state,
+			Timestamp:  time.Now(),
+		}
+		if state == messenger.StateFailed {
+			status.Error = webhook.Status
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func deliveryState(value string) (messenger.DeliveryState, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "sent":
+		return messenger.StateSent, true
+	case "delivered":
+		return messenger.StateDelivered, true
+	case "read", "played":
+		return messenger.StateRead, true
+	case "failed", "invalid", "expired":
+		return messenger.StateFailed, true
+	}
+	return "", false
+}
+
+// Inbound converts the webhook payload to the gateway-neutral message.
+// Fonnte does not send a message type, so it is derived from the fields
+// present: a location, then an attachment, then text.
+func (w WebhookMessage) Inbound() messenger.Inbound {
+	inbound := messenger.Inbound{
+		ID:        w.InboxID,
+		Type:      messenger.TypeText,
+		Gateway:   messenger.GatewayFonnte,
+		Device:    w.Device,
+		Sender:    w.Sender,
+		Member:    w.Member,
+		Name:      w.Name,
+		Message:   w.Message,
+		MediaURL:  w.URL,
+		Filename:  w.Filename,
+		Location:  w.Location,
+		Timestamp: time.Now(), // Fonnte does not say when a message was sent
+	}
+	if inbound.MediaURL == "" {
+		inbound.MediaURL = w.File
+	}
+
+	filename := w.Filename
+	if filename == "" && w.Extension != "" {
+		filename = "file." + strings.TrimPrefix(w.Extension, ".")
+	}
+	if filename == "" {
+		filename = inbound.MediaURL
+	}
+
+	switch {
+	case w.Location != "":
+		inbound.Type = messenger.TypeLocation
+	case inbound.MediaURL != "" || w.Extension != "":
+		inbound.Type = messenger.TypeForFile(filename, "")
+	}
+	return inbound
+}
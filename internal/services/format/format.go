@@ -0,0 +1,249 @@
+package format
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"example-tool-call/internal/config"
+)
+
+// Formatter turns model output into text WhatsApp renders well
+type Formatter struct {
+	cfg config.FormatConfig
+}
+
+func New(cfg config.FormatConfig) *Formatter {
+	return &Formatter{cfg: cfg}
+}
+
+// Text converts a reply and splits it into the messages to send, numbered
+// "(1/3)" when there is more than one
+func (f *Formatter) Text(text string) []string {
+	return f.parts(text, f != nil && f.cfg.NumberParts)
+}
+
+// Stream converts one streamed part of a reply. It is split like Text but
+// never numbered, since the rest of the reply is still being written.
+func (f *Formatter) Stream(text string) []string {
+	return f.parts(text, false)
+}
+
+func (f *Formatter) parts(text string, number bool) []string {
+	if f == nil {
+		return []string{text}
+	}
+	if f.cfg.Markdown {
+		text = ToWhatsApp(text)
+	}
+	if f.cfg.MaxLength <= 0 || utf8.RuneCountInString(text) <= f.cfg.MaxLength {
+		return []string{text}
+	}
+
+	if !number {
+		return Split(text, f.cfg.MaxLength)
+	}
+
+	// Leave room for the "(nn/nn) " prefix
+	parts := Split(text, f.cfg.MaxLength-8)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(parts), parts[i])
+	}
+	return parts
+}
+
+// Caption converts a media caption. Captions are not split.
+func (f *Formatter) Caption(text string) string {
+	if f == nil || !f.cfg.Markdown {
+		return text
+	}
+	return ToWhatsApp(text)
+}
+
+var (
+	headingRe   = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	ruleRe      = regexp.MustCompile(`^(?:-{3,}|\*{3,}|_{3,})$`)
+	bulletRe    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	tableSepRe  = regexp.MustCompile(`^\|?\s*:?-{2,}:?\s*(?:\|\s*:?-{2,}:?\s*)*\|?$`)
+	codeRe      = regexp.MustCompile("`([^`\n]+)`")
+	imageRe     = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	linkRe      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	boldStarRe  = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*`)
+	boldUnderRe = regexp.MustCompile(`__(\S(?:.*?\S)?)__`)
+	italicRe    = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+	strikeRe    = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	blankRunRe  = regexp.MustCompile(`\n{3,}`)
+)
+
+// ToWhatsApp converts CommonMark to WhatsApp formatting: *bold*, _italic_,
+// ~strike~ and ```monospace```. Headings become bold lines, bullets "•",
+// links "text (url)" and tables one block of "*header*: value" lines per
+// row. Code blocks are kept as they are.
+func ToWhatsApp(text string) string {
+	var out []string
+	var table [][]string
+	inCode := false
+
+	flushTable := func() {
+		if len(table) > 0 {
+			out = append(out, flattenTable(table)...)
+			table = nil
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			flushTable()
+			inCode = !inCode
+			out = append(out, "```")
+			continue
+		}
+		if inCode {
+			out = append(out, line)
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "|") {
+			if !tableSepRe.MatchString(trimmed) {
+				table = append(table, tableCells(trimmed))
+			}
+			continue
+		}
+		flushTable()
+
+		switch {
+		case headingRe.MatchString(trimmed):
+			title := inline(headingRe.FindStringSubmatch(trimmed)[1])
+			out = append(out, "*"+strings.Trim(title, "*")+"*")
+		case ruleRe.MatchString(trimmed):
+			out = append(out, "")
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+inline(m[2]))
+		default:
+			out = append(out, inline(line))
+		}
+	}
+	flushTable()
+	if inCode {
+		out = append(out, "```")
+	}
+
+	return strings.TrimSpace(blankRunRe.ReplaceAllString(strings.Join(out, "\n"), "\n\n"))
+}
+
+// inline converts emphasis, code and links within one line. Code spans are
+// set aside first so their contents are left alone.
+func inline(line string) string {
+	var spans []string
+	line = codeRe.ReplaceAllStringFunc(line, func(m string) string {
+		spans = append(spans, "```"+codeRe.FindStringSubmatch(m)[1]+"```")
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+
+	line = imageRe.ReplaceAllString(line, "$2")
+	line = linkRe.ReplaceAllStringFunc(line, func(m string) string {
+		parts := linkRe.FindStringSubmatch(m)
+		if parts[1] == parts[2] {
+			return parts[2]
+		}
+		return parts[1] + " (" + parts[2] + ")"
+	})
+
+	// Bold is marked with \x01 until single-star italics are converted
+	line = boldStarRe.ReplaceAllString(line, "\x01$1\x01")
+	line = boldUnderRe.ReplaceAllString(line, "\x01$1\x01")
+	line = italicRe.ReplaceAllString(line, "_${1}_")
+	line = strikeRe.ReplaceAllString(line, "~$1~")
+	line = strings.ReplaceAll(line, "\x01", "*")
+
+	for i, span := range spans {
+		line = strings.Replace(line, fmt.Sprintf("\x00%d\x00", i), span, 1)
+	}
+	return line
+}
+
+func tableCells(row string) []string {
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	cells := strings.Split(row, "|")
+	for i := range cells {
+		cells[i] = inline(strings.TrimSpace(cells[i]))
+	}
+	return cells
+}
+
+// flattenTable renders each row under the header as "*header*: value"
+// lines, separated by blank lines
+func flattenTable(rows [][]string) []string {
+	header := rows[0]
+	if len(rows) == 1 {
+		return []string{strings.Join(header, " | ")}
+	}
+
+	var out []string
+	for i, row := range rows[1:] {
+		if i > 0 {
+			out = append(out, "")
+		}
+		for j, cell := range row {
+			if cell == "" {
+				continue
+			}
+			if j < len(header) && header[j] != "" {
+				out = append(out, "*"+strings.Trim(header[j], "*")+"*: "+cell)
+			} else {
+				out = append(out, cell)
+			}
+		}
+	}
+	return append(out, "")
+}
+
+// Split breaks text into parts of at most limit characters, preferring
+// paragraph breaks, then line and sentence ends, then spaces
+func Split(text string, limit int) []string {
+	if limit <= 0 {
+		return []string{text}
+	}
+
+	var parts []string
+	for utf8.RuneCountInString(text) > limit {
+		runes := []rune(text)
+		head := string(runes[:limit])
+		cut := splitPoint(head)
+		parts = append(parts, strings.TrimSpace(head[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// splitPoint returns the byte offset to cut head at. Breaks in the first
+// half are ignored so parts are not needlessly short.
+func splitPoint(head string) int {
+	floor := len(head) / 2
+	if i := strings.LastIndex(head, "\n\n"); i > floor {
+		return i
+	}
+	if i := strings.LastIndex(head, "\n"); i > floor {
+		return i
+	}
+	best := -1
+	for _, end := range []string{". ", "! ", "? ", "; "} {
+		if i := strings.LastIndex(head, end); i >= 0 && i+1 > best {
+			best = i + 1
+		}
+	}
+	if best > floor {
+		return best
+	}
+	if i := strings.LastIndex(head, " "); i > floor {
+		return i
+	}
+	return len(head)
+}
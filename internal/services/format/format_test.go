@@ -0,0 +1,38 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"example-tool-call/internal/config"
+)
+
+func TestStreamPartsAreNotNumbered(t *testing.T) {
+	f := New(config.FormatConfig{Markdown: true, MaxLength: 40, NumberParts: true})
+	text := "This is the **first** sentence. This is the second sentence. And a third."
+
+	numbered := f.Text(text)
+	if len(numbered) < 2 || !strings.HasPrefix(numbered[0], "(1/") {
+		t.Fatalf("Text did not number its parts: %q", numbered)
+	}
+
+	streamed := f.Stream(text)
+	if len(streamed) < 2 {
+		t.Fatalf("Stream did not split: %q", streamed)
+	}
+	for _, part := range streamed {
+		if strings.HasPrefix(part, "(") {
+			t.Errorf("streamed part %q is numbered", part)
+		}
+	}
+	if !strings.Contains(streamed[0], "*first*") || strings.Contains(streamed[0], "**") {
+		t.Errorf("streamed part %q was not formatted", streamed[0])
+	}
+}
+
+func TestToWhatsAppKeepsWholeCodeBlock(t *testing.T) {
+	got := ToWhatsApp("Run:\n\n```\nmake **build**\n```")
+	if strings.Count(got, "```") != 2 || !strings.Contains(got, "make **build**") {
+		t.Errorf("code block changed: %q", got)
+	}
+}
@@ -0,0 +1,691 @@
+package handoff
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/i18n"
+	"example-tool-call/internal/services/messenger"
+	openaiService "example-tool-call/internal/services/openai"
+	"example-tool-call/internal/services/outbox"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Sources of a handoff
+const (
+	SourceTool    = "tool"
+	SourceKeyword = "keyword"
+	SourceAdmin   = "admin"
+)
+
+// Message types stored during a handoff
+const (
+	// OperatorType marks messages an operator sent to the user
+	OperatorType = "operator"
+	// SummaryType marks the note that tells the model what happened while
+	// a human was answering
+	SummaryType = "handoff_summary"
+)
+
+// Event types posted to the handoff webhook
+const (
+	EventStarted = "handoff.started"
+	EventMessage = "handoff.message"
+	EventEnded   = "handoff.ended"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook body, hex encoded
+// and prefixed "sha256="
+const SignatureHeader = "X-Handoff-Signature"
+
+// ErrNotActive is returned for conversations that are not handed off
+var ErrNotActive = errors.New("conversation is not handed off")
+
+// recentMessages is how many messages operators see when a handoff starts
+const recentMessages = 6
+
+const summaryPrompt = `You are given the messages a human support agent exchanged with a customer while the assistant was paused. ` +
+	`Summarize for the assistant, who takes over again: what the customer needed, what the agent did, told or promised them, ` +
+	`and anything left open. Answer in at most five short sentences.`
+
+// Request describes a handoff to start
+type Request struct {
+	JID    string
+	Name   string // display name of the user, if known
+	Source string
+	Reason string
+}
+
+// Event is posted to the handoff webhook as JSON
+type Event struct {
+	Type      string    `json:"type"`
+	JID       string    `json:"jid"`
+	Name      string    `json:"name,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Operator  string    `json:"operator,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Service hands conversations over to human operators. While a handoff is
+// active the bot stays quiet: user messages are forwarded to the operators
+// and the webhook, and operator messages are relayed to the user. Handing
+// the conversation back leaves a summary in the history for the model.
+type Service struct {
+	cfg       config.HandoffConfig
+	keywords  []string
+	operators []string
+	openai    *openaiService.Service
+	messenger messenger.Sender
+	db        *database.DB
+	client    *http.Client
+	logger    *logrus.Logger
+
+	started      atomic.Int64
+	released     atomic.Int64
+	forwarded    atomic.Int64
+	relayed      atomic.Int64
+	notifyFailed atomic.Int64
+}
+
+// Stats reports handoffs since startup and how many are active
+type Stats struct {
+	Enabled      bool  `json:"enabled"`
+	Active       int64 `json:"active"`
+	Started      int64 `json:"started"`
+	Released     int64 `json:"released"`
+	Forwarded    int64 `json:"forwarded"` // user messages passed to operators
+	Relayed      int64 `json:"relayed"`   // operator messages passed to users
+	NotifyFailed int64 `json:"notify_failed"`
+}
+
+// New creates the handoff service. Notifications and relayed messages are
+// sent through sender; openai summarizes handoffs and may be nil.
+func New(cfg config.HandoffConfig, openai *openaiService.Service, sender messenger.Sender, db *database.DB, logger *logrus.Logger) *Service {
+	s := &Service{
+		cfg:       cfg,
+		openai:    openai,
+		messenger: sender,
+		db:        db,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		logger:    logger,
+	}
+	for _, keyword := range cfg.Keywords {
+		if keyword = normalize(keyword); keyword != "" {
+			s.keywords = append(s.keywords, keyword)
+		}
+	}
+	for _, operator := range cfg.Operators {
+		if operator = messenger.Number(operator); operator != "" {
+			s.operators = append(s.operators, operator)
+		}
+	}
+	return s
+}
+
+// Enabled reports whether conversations can be handed off
+func (s *Service) Enabled() bool {
+	return s != nil && s.cfg.Enabled
+}
+
+// AdminEnabled reports whether the admin API is available
+func (s *Service) AdminEnabled() bool {
+	return s.Enabled() && s.cfg.AdminToken != ""
+}
+
+// Triggered reports whether text contains one of the handoff keywords
+func (s *Service) Triggered(text string) bool {
+	if !s.Enabled() {
+		return false
+	}
+	text = " " + normalize(text) + " "
+	for _, keyword := range s.keywords {
+		if strings.Contains(text, " "+keyword+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOperator reports whether sender is one of the operator numbers
+func (s *Service) IsOperator(sender string) bool {
+	if !s.Enabled() || strings.HasSuffix(sender, "@g.us") {
+		return false
+	}
+	sender = messenger.Number(sender)
+	for _, operator := range s.operators {
+		if operator == sender {
+			return true
+		}
+	}
+	return false
+}
+
+// Start hands a conversation over to a human and tells the user and the
+// operators. It reports false when the conversation was already handed off.
+// With a single operator the handoff is assigned to them right away.
+func (s *Service) Start(ctx context.Context, req Request) (bool, error) {
+	conversation, err := s.db.GetOrCreateConversation(req.JID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	operator := ""
+	if len(s.operators) == 1 {
+		operator = s.operators[0]
+	}
+	started, err := s.db.StartHandoff(req.JID, req.Source, req.Reason, operator)
+	if err != nil {
+		return false, fmt.Errorf("failed to start handoff: %w", err)
+	}
+	if !started {
+		return false, nil
+	}
+
+	s.started.Add(1)
+	s.logger.WithFields(logrus.Fields{
+		"jid":    req.JID,
+		"source": req.Source,
+		"reason": req.Reason,
+	}).Info("Conversation handed off to a human")
+
+	s.send(ctx, req.JID, i18n.T(conversation.Language, i18n.HandoffStarted))
+
+	text := fmt.Sprintf("[handoff] %s needs a human (%s)", who(req.Name, req.JID), req.Source)
+	if req.Reason != "" {
+		text += ": " + truncate(req.Reason, 300)
+	}
+	if recent := s.recent(req.JID); recent != "" {
+		text += "\n\nRecent messages:\n" + recent
+	}
+	if operator != "" {
+		text += "\n\nReply here to answer them. Send /done when finished."
+	} else {
+		text += fmt.Sprintf("\n\nSend /take %s to answer them.", req.JID)
+	}
+	s.notify(ctx, operator, text)
+
+	s.emit(Event{
+		Type:     EventStarted,
+		JID:      req.JID,
+		Name:     req.Name,
+		Source:   req.Source,
+		Reason:   req.Reason,
+		Operator: operator,
+	})
+	return true, nil
+}
+
+// Forward passes a message the user sent during a handoff to the operator
+// answering it, or to every operator while nobody has taken it
+func (s *Service) Forward(ctx context.Context, conversation *models.Conversation, name, text string) {
+	s.forwarded.Add(1)
+	s.notify(ctx, conversation.HandoffOperator, fmt.Sprintf("[%s] %s", who(name, conversation.JID), text))
+	s.emit(Event{
+		Type:     EventMessage,
+		JID:      conversation.JID,
+		Name:     name,
+		Operator: conversation.HandoffOperator,
+		Text:     text,
+	})
+}
+
+// Relay sends an operator's message to a handed-off user and records it in
+// the conversation history
+func (s *Service) Relay(ctx context.Context, jid, operator, text string) error {
+	conversation, err := s.db.GetHandoff(jid)
+	if err != nil {
+		return fmt.Errorf("failed to get conversation: %w", err)
+	}
+	if conversation == nil {
+		return ErrNotActive
+	}
+
+	// Link the sent message to the stored one so delivery status is kept
+	messageID := fmt.Sprintf("operator_%d", time.Now().UnixNano())
+	ctx = outbox.WithReply(ctx, outbox.Reply{MessageID: messageID})
+	if _, err := s.messenger.SendText(ctx, jid, text); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	message := &models.Message{
+		MessageID:   messageID,
+		FromJID:     "bot",
+		ToJID:       jid,
+		Content:     text,
+		MessageType: OperatorType,
+		IsFromMe:    true,
+		Timestamp:   time.Now(),
+	}
+	if err := s.db.SaveMessage(message); err != nil {
+		s.logger.WithError(err).Error("Failed to save operator message")
+	}
+
+	s.relayed.Add(1)
+	s.logger.WithFields(logrus.Fields{
+		"jid":      jid,
+		"operator": operator,
+	}).Debug("Operator message relayed")
+	return nil
+}
+
+// Release gives a conversation back to the bot. The messages exchanged
+// during the handoff are summarized and stored as a note the model sees in
+// the history, and the summary is returned. by names who ended it.
+func (s *Service) Release(ctx context.Context, jid, by string) (string, error) {
+	conversation, err := s.db.EndHandoff(jid)
+	if err != nil {
+		return "", fmt.Errorf("failed to end handoff: %w", err)
+	}
+	if conversation == nil {
+		return "", ErrNotActive
+	}
+	s.released.Add(1)
+
+	summary := s.summarize(ctx, conversation)
+	note := &models.Message{
+		MessageID:   fmt.Sprintf("handoff_%d", time.Now().UnixNano()),
+		FromJID:     "bot",
+		ToJID:       jid,
+		Content:     "A human agent answered this conversation before you took over again. " + summary,
+		MessageType: SummaryType,
+		IsFromMe:    true,
+		Timestamp:   time.Now(),
+	}
+	if err := s.db.SaveMessage(note); err != nil {
+		s.logger.WithError(err).Error("Failed to save handoff summary")
+	}
+
+	s.send(ctx, jid, i18n.T(conversation.Language, i18n.HandoffEnded))
+
+	// Let the operator know when someone else ended their handoff
+	if operator := conversation.HandoffOperator; operator != "" && operator != messenger.Number(by) {
+		s.notify(ctx, operator, fmt.Sprintf("[handoff] %s was handed back to the bot by %s.", jid, by))
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"jid": jid,
+		"by":  by,
+	}).Info("Conversation handed back to the bot")
+	s.emit(Event{
+		Type:     EventEnded,
+		JID:      jid,
+		Source:   conversation.HandoffSource,
+		Reason:   conversation.HandoffReason,
+		Operator: conversation.HandoffOperator,
+		Summary:  summary,
+	})
+	return summary, nil
+}
+
+// HandleOperator runs a command or relays a message from an operator and
+// returns the answer for the operator, if any. Operators send:
+//
+//	/list            conversations waiting for or answered by a human
+//	/take <number>   answer a conversation
+//	/done [number]   hand a conversation back to the bot
+//	anything else    relayed to the conversation they are answering
+func (s *Service) HandleOperator(ctx context.Context, operator string, msg messenger.Inbound) string {
+	operator = messenger.Number(operator)
+	text := strings.TrimSpace(msg.Message)
+	fields := strings.Fields(text)
+	command := ""
+	if len(fields) > 0 {
+		command = strings.ToLower(fields[0])
+	}
+
+	switch command {
+	case "/list":
+		return s.list()
+	case "/take":
+		if len(fields) < 2 {
+			return "[handoff] Usage: /take <number>"
+		}
+		conversation, err := s.find(fields[1])
+		if err != nil || conversation == nil {
+			return fmt.Sprintf("[handoff] %s is not waiting for a human.", fields[1])
+		}
+		assigned, err := s.db.AssignHandoff(conversation.JID, operator)
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to assign handoff")
+			return "[handoff] Failed to take the conversation."
+		}
+		// Never take a conversation from the operator answering it
+		if !assigned {
+			return fmt.Sprintf("[handoff] %s is already answered by another operator.", conversation.JID)
+		}
+		return fmt.Sprintf("[handoff] You're now answering %s. Send /done when finished.", conversation.JID)
+	case "/done":
+		var conversation *models.Conversation
+		var err error
+		if len(fields) > 1 {
+			conversation, err = s.find(fields[1])
+		} else {
+			conversation, err = s.current(operator)
+		}
+		if err != nil || conversation == nil {
+			return "[handoff] No conversation to hand back."
+		}
+		summary, err := s.Release(ctx, conversation.JID, operator)
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to release handoff")
+			return "[handoff] Failed to hand the conversation back."
+		}
+		return fmt.Sprintf("[handoff] %s is back with the bot.\n\nSummary: %s", conversation.JID, summary)
+	}
+
+	if msg.HasMedia() {
+		return "[handoff] Only text messages can be relayed."
+	}
+	if text == "" {
+		return ""
+	}
+
+	conversation, err := s.current(operator)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to look up handoffs")
+		return "[handoff] Failed to send your message."
+	}
+	if conversation == nil {
+		return "[handoff] You're not answering any conversation. Send /list to see who is waiting."
+	}
+	if err := s.Relay(ctx, conversation.JID, operator, text); err != nil {
+		s.logger.WithError(err).Error("Failed to relay operator message")
+		return "[handoff] Failed to send your message."
+	}
+	return ""
+}
+
+// current returns the conversation an operator answers: the one they took
+// most recently, or the only one nobody has taken yet, which they then take
+func (s *Service) current(operator string) (*models.Conversation, error) {
+	handoffs, err := s.db.GetHandoffs()
+	if err != nil {
+		return nil, err
+	}
+
+	var unassigned []models.Conversation
+	for i := len(handoffs) - 1; i >= 0; i-- {
+		switch handoffs[i].HandoffOperator {
+		case operator:
+			return &handoffs[i], nil
+		case "":
+			unassigned = append(unassigned, handoffs[i])
+		}
+	}
+	if len(unassigned) != 1 {
+		return nil, nil
+	}
+
+	conversation := &unassigned[0]
+	assigned, err := s.db.AssignHandoff(conversation.JID, operator)
+	if err != nil || !assigned {
+		return nil, err
+	}
+	conversation.HandoffOperator = operator
+	return conversation, nil
+}
+
+// find returns the active handoff for a JID or number
+func (s *Service) find(jid string) (*models.Conversation, error) {
+	handoffs, err := s.db.GetHandoffs()
+	if err != nil {
+		return nil, err
+	}
+	for i := range handoffs {
+		if handoffs[i].JID == jid || (messenger.Number(jid) != "" && messenger.Number(handoffs[i].JID) == messenger.Number(jid)) {
+			return &handoffs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// list describes the active handoffs for operators
+func (s *Service) list() string {
+	handoffs, err := s.db.GetHandoffs()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list handoffs")
+		return "[handoff] Failed to list conversations."
+	}
+	if len(handoffs) == 0 {
+		return "[handoff] No conversations are handed off."
+	}
+
+	var b strings.Builder
+	b.WriteString("[handoff] Handed off:")
+	for _, conversation := range handoffs {
+		operator := "waiting"
+		if conversation.HandoffOperator != "" {
+			operator = "answered by " + conversation.HandoffOperator
+		}
+		since := ""
+		if conversation.HandoffStartedAt != nil {
+			since = ", " + time.Since(*conversation.HandoffStartedAt).Round(time.Minute).String()
+		}
+		fmt.Fprintf(&b, "\n- %s (%s, %s%s)", conversation.JID, conversation.HandoffSource, operator, since)
+		if conversation.HandoffReason != "" {
+			b.WriteString(": " + truncate(conversation.HandoffReason, 100))
+		}
+	}
+	return b.String()
+}
+
+// recent returns the last messages of a conversation as a transcript
+func (s *Service) recent(jid string) string {
+	messages, err := s.db.GetMessages(jid, recentMessages)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to get recent messages")
+		return ""
+	}
+	// Newest first; put them back in order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return transcript(messages, 300)
+}
+
+// summarize describes what happened during a handoff, asking the model
+// when one is available and falling back to counting the messages
+func (s *Service) summarize(ctx context.Context, conversation *models.Conversation) string {
+	since := conversation.CreatedAt
+	if conversation.HandoffStartedAt != nil {
+		since = *conversation.HandoffStartedAt
+	}
+	messages, err := s.db.GetMessagesSince(conversation.JID, since)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to get handoff messages")
+	}
+
+	customer, agent := 0, 0
+	for _, message := range messages {
+		switch {
+		case message.MessageType == OperatorType:
+			agent++
+		case !message.IsFromMe:
+			customer++
+		}
+	}
+	fallback := fmt.Sprintf("The customer sent %d and the agent %d messages.", customer, agent)
+	if conversation.HandoffReason != "" {
+		fallback = fmt.Sprintf("The handoff was requested because: %s. %s", truncate(conversation.HandoffReason, 300), fallback)
+	}
+	if s.openai == nil || customer+agent == 0 {
+		return fallback
+	}
+
+	ctx = openaiService.WithCallMeta(ctx, openaiService.CallMeta{
+		MessageID: "handoff_summary",
+		Sender:    conversation.JID,
+	})
+	resp, err := s.openai.GenerateResponseWithModel(ctx, s.cfg.SummaryModel, []openaiService.ChatMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: transcript(messages, 1000)},
+	}, nil)
+	if err != nil || len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		if err != nil {
+			s.logger.WithError(err).Warn("Failed to summarize handoff")
+		}
+		return fallback
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content)
+}
+
+// transcript formats messages one per line, cutting each at limit
+// characters
+func transcript(messages []models.Message, limit int) string {
+	lines := make([]string, 0, len(messages))
+	for _, message := range messages {
+		speaker := "Customer"
+		switch {
+		case message.MessageType == SummaryType:
+			continue
+		case message.MessageType == OperatorType:
+			speaker = "Agent"
+		case message.IsFromMe:
+			speaker = "Bot"
+		case message.MemberName != "":
+			speaker = message.MemberName
+		}
+		lines = append(lines, speaker+": "+truncate(message.Content, limit))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// notify sends text to the given operator, or to every operator when none
+// is given
+func (s *Service) notify(ctx context.Context, operator, text string) {
+	targets := s.operators
+	if operator != "" {
+		targets = []string{operator}
+	}
+	for _, target := range targets {
+		if _, err := s.messenger.SendText(ctx, target, text); err != nil {
+			s.notifyFailed.Add(1)
+			s.logger.WithError(err).WithField("operator", target).Error("Failed to notify operator")
+		}
+	}
+}
+
+// send delivers a notice to the user
+func (s *Service) send(ctx context.Context, jid, text string) {
+	if _, err := s.messenger.SendText(ctx, jid, text); err != nil {
+		s.logger.WithError(err).WithField("jid", jid).Error("Failed to send handoff notice")
+	}
+}
+
+// emit posts an event to the handoff webhook in the background
+func (s *Service) emit(event Event) {
+	if s.cfg.WebhookURL == "" {
+		return
+	}
+	event.Timestamp = time.Now().UTC()
+	body, err := json.Marshal(event)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to encode handoff event")
+		return
+	}
+
+	go func() {
+		if err := s.post(body); err != nil {
+			s.notifyFailed.Add(1)
+			s.logger.WithError(err).WithField("event", event.Type).Error("Failed to post handoff event")
+		}
+	}()
+}
+
+func (s *Service) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(s.cfg.WebhookSecret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Middleware guards the admin API with the HANDOFF_ADMIN_TOKEN bearer token
+func (s *Service) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !s.AdminEnabled() || !hmac.Equal([]byte(token), []byte(s.cfg.AdminToken)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Stats returns handoffs since startup and how many are active
+func (s *Service) Stats() Stats {
+	stats := Stats{Enabled: s.Enabled()}
+	if !stats.Enabled {
+		return stats
+	}
+	stats.Started = s.started.Load()
+	stats.Released = s.released.Load()
+	stats.Forwarded = s.forwarded.Load()
+	stats.Relayed = s.relayed.Load()
+	stats.NotifyFailed = s.notifyFailed.Load()
+
+	handoffs, err := s.db.GetHandoffs()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to count handoffs")
+	}
+	stats.Active = int64(len(handoffs))
+	return stats
+}
+
+// who names a user for operators
+func who(name, jid string) string {
+	if name == "" {
+		return jid
+	}
+	return name + " (" + jid + ")"
+}
+
+// normalize lowercases text and reduces it to words separated by single
+// spaces
+func normalize(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
@@ -0,0 +1,55 @@
+package handoff
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+func TestTakeAnsweredConversation(t *testing.T) {
+	db, err := database.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := New(config.HandoffConfig{Enabled: true, Operators: []string{"6281100000001", "6281100000002"}}, nil, nil, db, logger)
+
+	jid := "6281200000000@s.whatsapp.net"
+	if _, err := db.GetOrCreateConversation(jid); err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	if _, err := db.StartHandoff(jid, "admin", "", ""); err != nil {
+		t.Fatalf("start handoff: %v", err)
+	}
+
+	take := func(operator string) string {
+		return s.HandleOperator(context.Background(), operator, messenger.Inbound{Message: "/take 6281200000000"})
+	}
+
+	if answer := take("6281100000001@s.whatsapp.net"); !strings.Contains(answer, "You're now answering") {
+		t.Fatalf("first take: %q", answer)
+	}
+	if answer := take("6281100000002@s.whatsapp.net"); !strings.Contains(answer, "already answered") {
+		t.Errorf("second take: %q", answer)
+	}
+	// Taking it again is harmless for the operator who answers it
+	if answer := take("6281100000001@s.whatsapp.net"); !strings.Contains(answer, "You're now answering") {
+		t.Errorf("repeated take: %q", answer)
+	}
+
+	conversation, err := db.GetHandoff(jid)
+	if err != nil || conversation == nil {
+		t.Fatalf("get handoff: %v", err)
+	}
+	if conversation.HandoffOperator != "6281100000001" {
+		t.Errorf("operator = %q, want 6281100000001", conversation.HandoffOperator)
+	}
+}
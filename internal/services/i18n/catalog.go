@@ -0,0 +1,150 @@
+package i18n
+
+import "fmt"
+
+const (
+	English    = "en"
+	Indonesian = "id"
+)
+
+// Languages lists the supported language codes
+var Languages = []string{English, Indonesian}
+
+// Key identifies a canned bot message
+type Key string
+
+const (
+	ErrProcessing      Key = "error_processing"
+	ErrNoResponse      Key = "error_no_response"
+	ErrToolFailed      Key = "error_tool_failed"
+	ErrImageGeneration Key = "error_image_generation"
+	ErrImageProcessing Key = "error_image_processing"
+	ErrImageSend       Key = "error_image_send"
+	ToolSucceeded      Key = "tool_succeeded"
+	ImageCaption       Key = "image_caption"
+	BlockedInput       Key = "blocked_input"
+	BlockedOutput      Key = "blocked_output"
+	BlockedImage       Key = "blocked_image"
+	BlockedToolCall    Key = "blocked_tool_call"
+	ModelCurrent       Key = "model_current"
+	ModelUnknown       Key = "model_unknown"
+	ModelAuto          Key = "model_auto"
+	ModelSet           Key = "model_set"
+	ModelSaveFailed    Key = "model_save_failed"
+	LanguageCurrent    Key = "language_current"
+	LanguageUnknown    Key = "language_unknown"
+	LanguageAuto       Key = "language_auto"
+	LanguageSet        Key = "language_set"
+	UnsupportedAudio   Key = "unsupported_audio"
+	ErrTranscription   Key = "error_transcription"
+	UnsupportedMessage Key = "unsupported_message"
+	HandoffStarted     Key = "handoff_started"
+	HandoffEnded       Key = "handoff_ended"
+	FactSaved          Key = "fact_saved"
+	FactList           Key = "fact_list"
+	FactNone           Key = "fact_none"
+	FactForgotten      Key = "fact_forgotten"
+)
+
+var catalog = map[string]map[Key]string{
+	English: {
+		ErrProcessing:      "Sorry, I'm having trouble processing your message right now.",
+		ErrNoResponse:      "Sorry, I couldn't generate a response.",
+		ErrToolFailed:      "Sorry, I couldn't execute the %s tool.",
+		ErrImageGeneration: "Sorry, I couldn't generate the image. Please try again with a different prompt.",
+		ErrImageProcessing: "Sorry, there was an error processing the generated image.",
+		ErrImageSend:       "Sorry, I couldn't send the generated image.",
+		ToolSucceeded:      "Tool %s executed successfully",
+		ImageCaption:       "Here's your generated image!",
+		BlockedInput:       "Sorry, I can't help with that request.",
+		BlockedOutput:      "Sorry, I can't share that response.",
+		BlockedImage:       "Sorry, I can't share that image.",
+		BlockedToolCall:    "Sorry, I can't do that.",
+		ModelCurrent:       "Current model: %s. Available: auto, %s.",
+		ModelUnknown:       "Unknown model %q. Available: auto, %s.",
+		ModelAuto:          "Model selection is back to automatic.",
+		ModelSet:           "This conversation now uses %s.",
+		ModelSaveFailed:    "Sorry, I couldn't change the model right now.",
+		LanguageCurrent:    "Current language: %s. Available: auto, %s.",
+		LanguageUnknown:    "Unknown language %q. Available: auto, %s.",
+		LanguageAuto:       "I'll answer in the language you write in.",
+		LanguageSet:        "I'll answer in English from now on.",
+		UnsupportedAudio:   "Sorry, I can't listen to voice messages yet. Please type your message.",
+		ErrTranscription:   "Sorry, I couldn't make out your voice message. Could you type it instead?",
+		UnsupportedMessage: "Sorry, I can only read text, images, documents and locations.",
+		HandoffStarted:     "I've passed this conversation to a member of our team. They'll reply here shortly.",
+		HandoffEnded:       "You're chatting with the assistant again.",
+		FactSaved:          "Got it, I'll remember that.",
+		FactList:           "Here's what I remember about you:\n%s\n\nSend /forget to clear it.",
+		FactNone:           "I don't remember anything about you yet. Send /remember followed by something I should know.",
+		FactForgotten:      "Done, I've forgotten what you asked me to remember.",
+	},
+	Indonesian: {
+		ErrProcessing:      "Maaf, saya sedang kesulitan memproses pesan Anda saat ini.",
+		ErrNoResponse:      "Maaf, saya tidak bisa membuat balasan.",
+		ErrToolFailed:      "Maaf, saya tidak bisa menjalankan alat %s.",
+		ErrImageGeneration: "Maaf, saya tidak bisa membuat gambarnya. Silakan coba lagi dengan deskripsi yang berbeda.",
+		ErrImageProcessing: "Maaf, terjadi kesalahan saat memproses gambar yang dibuat.",
+		ErrImageSend:       "Maaf, saya tidak bisa mengirim gambar yang dibuat.",
+		ToolSucceeded:      "Alat %s berhasil dijalankan",
+		ImageCaption:       "Ini gambar yang Anda minta!",
+		BlockedInput:       "Maaf, saya tidak bisa membantu permintaan tersebut.",
+		BlockedOutput:      "Maaf, saya tidak bisa membagikan balasan tersebut.",
+		BlockedImage:       "Maaf, saya tidak bisa membagikan gambar tersebut.",
+		BlockedToolCall:    "Maaf, saya tidak bisa melakukannya.",
+		ModelCurrent:       "Model saat ini: %s. Pilihan: auto, %s.",
+		ModelUnknown:       "Model %q tidak dikenal. Pilihan: auto, %s.",
+		ModelAuto:          "Pemilihan model kembali otomatis.",
+		ModelSet:           "Percakapan ini sekarang menggunakan %s.",
+		ModelSaveFailed:    "Maaf, saya tidak bisa mengganti model saat ini.",
+		LanguageCurrent:    "Bahasa saat ini: %s. Pilihan: auto, %s.",
+		LanguageUnknown:    "Bahasa %q tidak dikenal. Pilihan: auto, %s.",
+		LanguageAuto:       "Saya akan menjawab dalam bahasa yang Anda gunakan.",
+		LanguageSet:        "Mulai sekarang saya akan menjawab dalam Bahasa Indonesia.",
+		UnsupportedAudio:   "Maaf, saya belum bisa mendengarkan pesan suara. Silakan ketik pesan Anda.",
+		ErrTranscription:   "Maaf, saya tidak bisa memahami pesan suara Anda. Bisakah Anda mengetiknya?",
+		UnsupportedMessage: "Maaf, saya hanya bisa membaca teks, gambar, dokumen, dan lokasi.",
+		HandoffStarted:     "Percakapan ini sudah saya teruskan ke tim kami. Mereka akan segera membalas di sini.",
+		HandoffEnded:       "Anda kembali terhubung dengan asisten.",
+		FactSaved:          "Baik, saya akan mengingatnya.",
+		FactList:           "Ini yang saya ingat tentang Anda:\n%s\n\nKirim /forget untuk menghapusnya.",
+		FactNone:           "Saya belum mengingat apa pun tentang Anda. Kirim /remember diikuti hal yang perlu saya ketahui.",
+		FactForgotten:      "Selesai, saya sudah melupakan yang Anda minta untuk diingat.",
+	},
+}
+
+var names = map[string]string{
+	English:    "English",
+	Indonesian: "Bahasa Indonesia",
+}
+
+// T returns the message for key in lang, formatted with args. Unknown
+// languages fall back to English.
+func T(lang string, key Key, args ...interface{}) string {
+	messages, ok := catalog[lang]
+	if !ok {
+		messages = catalog[English]
+	}
+	message, ok := messages[key]
+	if !ok {
+		message = catalog[English][key]
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Supported reports whether lang has a message catalog
+func Supported(lang string) bool {
+	_, ok := catalog[lang]
+	return ok
+}
+
+// Name returns the human readable name of a language code
+func Name(lang string) string {
+	if name, ok := names[lang]; ok {
+		return name
+	}
+	return lang
+}
@@ -0,0 +1,61 @@
+package i18n
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopwords are frequent words that identify a language without needing a
+// model. Words shared by both languages are left out.
+var stopwords = map[string]map[string]bool{
+	English: wordSet(`the a an and is are was were am be been i you he she we they it its my your our their
+		me him her us them to of in on at for with from about what how why when where which who can could
+		would should will please thanks thank hello hi hey yes no not do does did don't this that these those
+		have has had make create generate draw picture image photo want need like just some any there here`),
+	Indonesian: wordSet(`yang dan di ke dari ini itu saya aku gue gw kamu anda kami kita mereka dia ia tidak
+		nggak ngga gak enggak bukan ya iya dong sih deh kok nih tuh buat untuk dengan ada mau ingin bisa
+		tolong minta halo hai selamat pagi siang sore malam terima kasih makasih apa apakah bagaimana gimana
+		kenapa mengapa kapan dimana mana berapa sudah udah belum juga akan lagi tentang harga pesan gambar
+		bikin buatkan buatin foto tolongin bantu bantuan kak mas mbak pak bu banget aja saja atau tapi`),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// Detect guesses the language of text from stopword frequencies. It returns
+// the language and a confidence equal to how many more stopwords of that
+// language were found than of the runner-up; an empty language means the
+// text gave no signal.
+func Detect(text string) (string, int) {
+	counts := make(map[string]int, len(stopwords))
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		for lang, words := range stopwords {
+			if words[token] {
+				counts[lang]++
+			}
+		}
+	}
+
+	best, bestCount, runnerUp := "", 0, 0
+	for _, lang := range Languages {
+		count := counts[lang]
+		switch {
+		case count > bestCount:
+			best, runnerUp, bestCount = lang, bestCount, count
+		case count > runnerUp:
+			runnerUp = count
+		}
+	}
+
+	if bestCount == runnerUp {
+		return "", 0
+	}
+	return best, bestCount - runnerUp
+}
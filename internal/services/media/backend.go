@@ -0,0 +1,66 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Backend stores file contents by key
+type Backend interface {
+	// Name is recorded with each file so it can be found after the backend
+	// changes
+	Name() string
+	Put(ctx context.Context, key string, data []byte, mimeType string) error
+	// Open returns the file content. The caller closes it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// fsBackend stores files under a local directory
+type fsBackend struct {
+	dir string
+}
+
+func newFSBackend(dir string) (*fsBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+	return &fsBackend{dir: dir}, nil
+}
+
+func (b *fsBackend) Name() string {
+	return "fs"
+}
+
+// Put writes the file under a temporary name first so it is never served
+// half-written
+func (b *fsBackend) Put(ctx context.Context, key string, data []byte, mimeType string) error {
+	path := filepath.Join(b.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to store media: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".media-*")
+	if err != nil {
+		return fmt.Errorf("failed to store media: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store media: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to store media: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store media: %w", err)
+	}
+	return nil
+}
+
+func (b *fsBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(b.dir, filepath.FromSlash(key)))
+}
@@ -0,0 +1,333 @@
+package media
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Path is the route stored files are served under
+const Path = "/media"
+
+// extensions are the file extensions used for common MIME types, where the
+// system MIME table may pick an unusual one
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"image/gif":       ".gif",
+	"audio/ogg":       ".ogg",
+	"audio/mpeg":      ".mp3",
+	"audio/aac":       ".aac",
+	"audio/mp4":       ".m4a",
+	"video/mp4":       ".mp4",
+	"application/pdf": ".pdf",
+}
+
+// Info describes a file being stored
+type Info struct {
+	Filename  string
+	MimeType  string // detected from the content when empty
+	SourceURL string
+}
+
+// Service stores received and generated files once per content hash and
+// serves them through expiring signed URLs, so links in the history keep
+// working after the original URL expires
+type Service struct {
+	cfg        config.MediaConfig
+	backend    Backend
+	publicURL  string
+	signingKey []byte
+	client     *http.Client
+	db         *database.DB
+	logger     *logrus.Logger
+
+	stored       atomic.Int64
+	deduplicated atomic.Int64
+	served       atomic.Int64
+	rejected     atomic.Int64
+}
+
+// Stats reports files stored and served since startup, and the totals kept
+type Stats struct {
+	Enabled      bool   `json:"enabled"`
+	Backend      string `json:"backend,omitempty"`
+	Stored       int64  `json:"stored"`
+	Deduplicated int64  `json:"deduplicated"`
+	Served       int64  `json:"served"`
+	Rejected     int64  `json:"rejected"` // requests with a bad or expired signature
+	Files        int64  `json:"files"`
+	Bytes        int64  `json:"bytes"`
+}
+
+// New creates the media store. publicURL is where gateways fetch files from;
+// without it files are stored but cannot be sent by URL.
+func New(cfg config.MediaConfig, publicURL string, db *database.DB, logger *logrus.Logger) (*Service, error) {
+	var backend Backend
+	var err error
+	switch cfg.Backend {
+	case "s3":
+		backend, err = newS3Backend(cfg.S3)
+	default:
+		backend, err = newFSBackend(cfg.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey := []byte(cfg.SigningKey)
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, fmt.Errorf("failed to generate media signing key: %w", err)
+		}
+		logger.Warn("MEDIA_SIGNING_KEY is not set; media URLs stop working when the bot restarts")
+	}
+	if cfg.URLTTL <= 0 {
+		cfg.URLTTL = 24 * time.Hour
+	}
+
+	return &Service{
+		cfg:        cfg,
+		backend:    backend,
+		publicURL:  strings.TrimRight(publicURL, "/"),
+		signingKey: signingKey,
+		client:     &http.Client{Timeout: 60 * time.Second},
+		db:         db,
+		logger:     logger,
+	}, nil
+}
+
+// Enabled reports whether files are stored
+func (s *Service) Enabled() bool {
+	return s != nil
+}
+
+// CanServe reports whether stored files can be sent by URL
+func (s *Service) CanServe() bool {
+	return s != nil && s.publicURL != ""
+}
+
+// Store saves content unless a file with the same hash is already stored,
+// in which case that one is returned
+func (s *Service) Store(ctx context.Context, r io.Reader, info Info) (*models.Media, error) {
+	// Read one byte past the limit to tell a full file from a cut-off one;
+	// a MaxSize of zero means no limit
+	if s.cfg.MaxSize > 0 {
+		r = io.LimitReader(r, s.cfg.MaxSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media: %w", err)
+	}
+	if s.cfg.MaxSize > 0 && int64(len(data)) > s.cfg.MaxSize {
+		return nil, fmt.Errorf("media exceeds %d bytes", s.cfg.MaxSize)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	existing, err := s.db.GetMediaByHash(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up media: %w", err)
+	}
+	if existing != nil {
+		s.deduplicated.Add(1)
+		return existing, nil
+	}
+
+	mimeType := info.MimeType
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+	}
+	if parsed, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = parsed
+	}
+
+	filename := ""
+	if info.Filename != "" {
+		filename = path.Base(info.Filename)
+	}
+	media := &models.Media{
+		Hash:      hash,
+		Backend:   s.backend.Name(),
+		Key:       hash[:2] + "/" + hash + extension(filename, mimeType),
+		MimeType:  mimeType,
+		Size:      int64(len(data)),
+		Filename:  filename,
+		SourceURL: info.SourceURL,
+	}
+	if err := s.backend.Put(ctx, media.Key, data, mimeType); err != nil {
+		return nil, err
+	}
+
+	media, err = s.db.CreateMedia(media)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record media: %w", err)
+	}
+
+	s.stored.Add(1)
+	s.logger.WithFields(logrus.Fields{
+		"media_id":  media.ID,
+		"mime_type": mimeType,
+		"size":      media.Size,
+	}).Debug("Media stored")
+	return media, nil
+}
+
+// Fetch downloads a file by URL and stores it
+func (s *Service) Fetch(ctx context.Context, rawURL string, info Info) (*models.Media, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media URL: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download media: status %d", resp.StatusCode)
+	}
+	if info.MimeType == "" {
+		info.MimeType = resp.Header.Get("Content-Type")
+	}
+	info.SourceURL = rawURL
+	return s.Store(ctx, resp.Body, info)
+}
+
+// Link records that a message carried a stored file
+func (s *Service) Link(messageID string, media *models.Media) error {
+	return s.db.LinkMessageMedia(messageID, media.ID)
+}
+
+// Open returns the content of a stored file. The caller closes it.
+func (s *Service) Open(ctx context.Context, media *models.Media) (io.ReadCloser, error) {
+	return s.backend.Open(ctx, media.Key)
+}
+
+// URL returns a signed URL for a stored file that expires after the
+// configured TTL. The path ends in a file name with the right extension,
+// which some gateways use to detect the media type.
+func (s *Service) URL(media *models.Media) string {
+	name := publicName(media)
+	expires := strconv.FormatInt(time.Now().Add(s.cfg.URLTTL).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.signature(media.ID.String(), name, expires)},
+	}
+	return fmt.Sprintf("%s%s/%s/%s?%s", s.publicURL, Path, media.ID, url.PathEscape(name), query.Encode())
+}
+
+func (s *Service) signature(id, name, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(id + "/" + name + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Serve handles GET {Path}/:id/:name for URLs made by URL
+func (s *Service) Serve(c *gin.Context) {
+	id, name := c.Param("id"), c.Param("name")
+	expires := c.Query("expires")
+
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	valid := err == nil && time.Now().Unix() <= expiry &&
+		hmac.Equal([]byte(c.Query("signature")), []byte(s.signature(id, name, expires)))
+	if !valid {
+		s.rejected.Add(1)
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	mediaID, err := uuid.Parse(id)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	media, err := s.db.GetMedia(mediaID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	content, err := s.Open(c.Request.Context(), media)
+	if err != nil {
+		s.logger.WithError(err).WithField("media_id", media.ID).Error("Failed to open media")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer content.Close()
+
+	s.served.Add(1)
+	c.Header("Cache-Control", "private, max-age=3600")
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		c.Header("Content-Type", media.MimeType)
+		http.ServeContent(c.Writer, c.Request, name, media.CreatedAt, seeker)
+		return
+	}
+	c.DataFromReader(http.StatusOK, media.Size, media.MimeType, content, nil)
+}
+
+// Stats returns files stored and served since startup and the totals kept
+func (s *Service) Stats() Stats {
+	stats := Stats{Enabled: s.Enabled()}
+	if !stats.Enabled {
+		return stats
+	}
+	stats.Backend = s.backend.Name()
+	stats.Stored = s.stored.Load()
+	stats.Deduplicated = s.deduplicated.Load()
+	stats.Served = s.served.Load()
+	stats.Rejected = s.rejected.Load()
+
+	totals, err := s.db.GetMediaTotals()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to count media")
+	}
+	stats.Files, stats.Bytes = totals.Files, totals.Bytes
+	return stats
+}
+
+// extension picks the file extension to store content under, preferring
+// the MIME type over the original file name
+func extension(filename, mimeType string) string {
+	if ext, ok := extensions[mimeType]; ok {
+		return ext
+	}
+	if ext := path.Ext(filename); ext != "" && len(ext) <= 8 {
+		return strings.ToLower(ext)
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// publicName is the file name a stored file is served under
+func publicName(media *models.Media) string {
+	ext := path.Ext(media.Key)
+	if name := strings.TrimSuffix(media.Filename, path.Ext(media.Filename)); name != "" && name != "." && name != "/" {
+		return name + ext
+	}
+	return media.Hash[:16] + ext
+}
@@ -0,0 +1,75 @@
+package media
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/database"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestService(t *testing.T, maxSize int64) *Service {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.New("sqlite://" + filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(config.MediaConfig{
+		Dir:        filepath.Join(dir, "media"),
+		MaxSize:    maxSize,
+		SigningKey: "test-key",
+	}, "", db, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func TestStoreSizeLimit(t *testing.T) {
+	content := strings.Repeat("voice note ", 100)
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		wantErr bool
+	}{
+		{"no limit", 0, false},
+		{"within limit", int64(len(content)), false},
+		{"over limit", int64(len(content)) - 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, tt.maxSize)
+			stored, err := s.Store(context.Background(), strings.NewReader(content), Info{MimeType: "text/plain"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Store accepted a file over the limit")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+
+			r, err := s.Open(context.Background(), stored)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read stored file: %v", err)
+			}
+			if string(data) != content {
+				t.Fatalf("stored %d bytes, want %d", len(data), len(content))
+			}
+		})
+	}
+}
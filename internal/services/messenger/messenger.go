@@ -0,0 +1,254 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Gateway names selectable with WHATSAPP_GATEWAY
+const (
+	GatewayFonnte    = "fonnte"
+	GatewayMeta      = "meta"
+	GatewayWhatsmeow = "whatsmeow"
+)
+
+var (
+	// ErrInvalidSignature is returned by ParseWebhook when a payload is not
+	// signed by the gateway
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrNoWebhook is returned by ParseWebhook for gateways that receive
+	// messages over their own connection
+	ErrNoWebhook = errors.New("gateway does not use webhooks")
+
+	// ErrPermanent is wrapped by send errors that retrying cannot fix, such
+	// as a rejected API token or an exhausted quota
+	ErrPermanent = errors.New("permanent send failure")
+)
+
+// Messenger sends and receives WhatsApp messages through one gateway
+type Messenger interface {
+	// Name returns the gateway name, also used in the webhook path
+	Name() string
+
+	Sender
+
+	// ParseWebhook authenticates and decodes an inbound webhook request. It
+	// returns no messages for payloads that carry none, such as status
+	// updates.
+	ParseWebhook(r *http.Request) ([]Inbound, error)
+}
+
+// Sender delivers outgoing messages. Every Messenger is one; the outbox
+// wraps one to deliver in the background.
+type Sender interface {
+	// SendText sends a text message and returns the gateway message ID
+	SendText(ctx context.Context, to, text string) (string, error)
+
+	// SendMedia sends an image, audio, video or document by URL and returns
+	// the gateway message ID
+	SendMedia(ctx context.Context, to string, media Media) (string, error)
+}
+
+// Verifier is implemented by gateways that confirm webhook subscriptions
+// with a GET challenge
+type Verifier interface {
+	// VerifyWebhook returns the challenge to echo back, or false when the
+	// request does not carry the expected verify token
+	VerifyWebhook(query url.Values) (string, bool)
+}
+
+// Listener is implemented by gateways that receive messages over their own
+// connection instead of a webhook
+type Listener interface {
+	// Listen connects and calls handle for every inbound message until ctx
+	// is done
+	Listen(ctx context.Context, handle func(Inbound)) error
+}
+
+// Downloader is implemented by gateways whose inbound media is referenced
+// by MediaID and has to be fetched through their API
+type Downloader interface {
+	// Download opens the media attached to msg. The caller closes it.
+	Download(ctx context.Context, msg Inbound) (io.ReadCloser, error)
+}
+
+// StatusParser is implemented by gateways that report the delivery status
+// of sent messages by webhook
+type StatusParser interface {
+	// ParseStatus authenticates and decodes a status webhook request. It
+	// returns no updates for payloads that carry none.
+	ParseStatus(r *http.Request) ([]Status, error)
+}
+
+// StatusNotifier is implemented by gateways that report delivery status
+// over their own connection
+type StatusNotifier interface {
+	// OnStatus registers the function called for every status update
+	OnStatus(handle func(Status))
+}
+
+// DeliveryState is how far a sent message got
+type DeliveryState string
+
+const (
+	StateSent      DeliveryState = "sent"
+	StateDelivered DeliveryState = "delivered"
+	StateRead      DeliveryState = "read"
+	StateFailed    DeliveryState = "failed"
+)
+
+// Status is a delivery update for a message the bot sent, identified by the
+// ID the gateway returned when sending it
+type Status struct {
+	ProviderID string
+	State      DeliveryState
+	Recipient  string
+	Error      string
+	Timestamp  time.Time
+}
+
+// MediaType is the kind of media attached to a message
+type MediaType string
+
+const (
+	MediaImage    MediaType = "image"
+	MediaAudio    MediaType = "audio"
+	MediaVideo    MediaType = "video"
+	MediaDocument MediaType = "document"
+)
+
+// MessageType is the kind of an inbound message
+type MessageType string
+
+const (
+	TypeText        MessageType = "text"
+	TypeImage       MessageType = "image"
+	TypeVideo       MessageType = "video"
+	TypeAudio       MessageType = "audio"
+	TypeDocument    MessageType = "document"
+	TypeLocation    MessageType = "location"
+	TypeUnsupported MessageType = "unsupported"
+)
+
+// TypeForFile guesses the message type of an attachment from its MIME type
+// or, failing that, its file extension
+func TypeForFile(filename, mimeType string) MessageType {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return TypeImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return TypeVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return TypeAudio
+	case mimeType != "":
+		return TypeDocument
+	}
+
+	switch strings.ToLower(strings.TrimPrefix(path.Ext(filename), ".")) {
+	case "jpg", "jpeg", "png", "gif", "webp":
+		return TypeImage
+	case "mp4", "3gp", "mov", "mkv":
+		return TypeVideo
+	case "mp3", "ogg", "oga", "opus", "m4a", "aac", "wav", "amr":
+		return TypeAudio
+	default:
+		return TypeDocument
+	}
+}
+
+// Media is an outgoing attachment
+type Media struct {
+	Type     MediaType
+	URL      string
+	Caption  string
+	Filename string
+	MimeType string
+}
+
+// Inbound is a message received from any gateway. For group messages Sender
+// is the group and Member is the author, matching Fonnte's webhook.
+type Inbound struct {
+	ID        string
+	Type      MessageType
+	Gateway   string
+	Device    string // number of the bot account that received the message
+	Sender    string
+	Member    string
+	Name      string
+	Message   string
+	MediaURL  string
+	MediaID   string // gateway media reference when no URL is available
+	Filename  string
+	Location  string
+	Timestamp time.Time
+
+	// QuotedID and QuotedSender identify the message this one replies to,
+	// for gateways that report it
+	QuotedID     string
+	QuotedSender string
+	// Mentions are the numbers mentioned in the message, for gateways that
+	// report them; others only carry "@number" in the text
+	Mentions []string
+}
+
+// IsGroup reports whether the message was sent in a group. Group JIDs end
+// in @g.us, and gateways set Member to the author.
+func (m Inbound) IsGroup() bool {
+	return m.Member != "" || strings.HasSuffix(m.Sender, "@g.us")
+}
+
+// HasMedia reports whether the message carries media or a location
+func (m Inbound) HasMedia() bool {
+	return m.MediaURL != "" || m.MediaID != "" || m.Location != ""
+}
+
+// Number reduces a phone number or JID to the digits of its user part, so
+// "62812@s.whatsapp.net", "62812:3@s.whatsapp.net" and "+62 812" compare
+// equal
+func Number(jid string) string {
+	user, _, _ := strings.Cut(jid, "@")
+	user, _, _ = strings.Cut(user, ":")
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, user)
+}
+
+// mediaClient fetches inbound media by URL
+var mediaClient = &http.Client{Timeout: 60 * time.Second}
+
+// OpenMedia opens the media attached to msg, through the gateway when it is
+// a Downloader and the message carries a MediaID, otherwise from MediaURL.
+// The caller closes it.
+func OpenMedia(ctx context.Context, gateway Messenger, msg Inbound) (io.ReadCloser, error) {
+	if downloader, ok := gateway.(Downloader); ok && msg.MediaID != "" {
+		return downloader.Download(ctx, msg)
+	}
+	if msg.MediaURL == "" {
+		return nil, fmt.Errorf("message has no downloadable media")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, msg.MediaURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media URL: %w", err)
+	}
+	resp, err := mediaClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download media: status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,454 @@
+package meta
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+// Service talks to the WhatsApp Cloud API through the Meta Graph API
+type Service struct {
+	cfg    config.MetaConfig
+	client *http.Client
+	logger *logrus.Logger
+}
+
+func New(cfg config.MetaConfig, logger *logrus.Logger) *Service {
+	return &Service{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger: logger,
+	}
+}
+
+// Name implements messenger.Messenger
+func (s *Service) Name() string {
+	return messenger.GatewayMeta
+}
+
+type sendRequest struct {
+	MessagingProduct string        `json:"messaging_product"`
+	RecipientType    string        `json:"recipient_type"`
+	To               string        `json:"to"`
+	Type             string        `json:"type"`
+	Text             *textContent  `json:"text,omitempty"`
+	Image            *mediaContent `json:"image,omitempty"`
+	Audio            *mediaContent `json:"audio,omitempty"`
+	Video            *mediaContent `json:"video,omitempty"`
+	Document         *mediaContent `json:"document,omitempty"`
+}
+
+type textContent struct {
+	Body       string `json:"body"`
+	PreviewURL bool   `json:"preview_url"`
+}
+
+type mediaContent struct {
+	Link     string `json:"link"`
+	Caption  string `json:"caption,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+type sendResponse struct {
+	Messages []struct {
+		ID string `json:"id"`
+	} `json:"messages"`
+	Error *graphError `json:"error,omitempty"`
+}
+
+type graphError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    int    `json:"code"`
+}
+
+// SendText implements messenger.Messenger
+func (s *Service) SendText(ctx context.Context, to, text string) (string, error) {
+	return s.send(ctx, sendRequest{
+		To:   to,
+		Type: "text",
+		Text: &textContent{Body: text, PreviewURL: true},
+	})
+}
+
+// SendMedia implements messenger.Messenger. Audio messages cannot carry a
+// caption, so it is dropped for them.
+func (s *Service) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	content := &mediaContent{Link: media.URL, Caption: media.Caption}
+	req := sendRequest{To: to, Type: string(media.Type)}
+
+	switch media.Type {
+	case messenger.MediaImage:
+		req.Image = content
+	case messenger.MediaAudio:
+		content.Caption = ""
+		req.Audio = content
+	case messenger.MediaVideo:
+		req.Video = content
+	case messenger.MediaDocument:
+		content.Filename = media.Filename
+		req.Document = content
+	default:
+		return "", fmt.Errorf("unsupported media type %q", media.Type)
+	}
+
+	return s.send(ctx, req)
+}
+
+func (s *Service) send(ctx context.Context, req sendRequest) (string, error) {
+	req.MessagingProduct = "whatsapp"
+	req.RecipientType = "individual"
+	req.To = normalizeNumber(req.To)
+
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/messages", strings.TrimRight(s.cfg.BaseURL, "/"), s.cfg.APIVersion, s.cfg.PhoneNumberID)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Authorization", "Bearer "+s.cfg.AccessToken)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	s.logger.WithFields(logrus.Fields{
+		"target": req.To,
+		"type":   req.Type,
+	}).Debug("Sending message via Meta Cloud API")
+
+	start := time.Now()
+	resp, err := s.client.Do(httpReq)
+	duration := time.Since(start)
+
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"duration": duration,
+		}).Error("Meta Cloud API request failed")
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response sendResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.Error != nil {
+		err := fmt.Errorf("meta API error %d: %s", response.Error.Code, response.Error.Message)
+		// An invalid or expired access token fails every send until replaced
+		if resp.StatusCode == http.StatusUnauthorized || response.Error.Code == 190 {
+			err = fmt.Errorf("%w: %w", err, messenger.ErrPermanent)
+		}
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || len(response.Messages) == 0 {
+		return "", fmt.Errorf("meta API error: status %d", resp.StatusCode)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"duration": duration,
+		"id":       response.Messages[0].ID,
+	}).Info("Meta message sent")
+
+	return response.Messages[0].ID, nil
+}
+
+// VerifyWebhook implements messenger.Verifier for the subscription
+// handshake Meta performs when the webhook URL is configured
+func (s *Service) VerifyWebhook(query url.Values) (string, bool) {
+	if query.Get("hub.mode") != "subscribe" || s.cfg.VerifyToken == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(query.Get("hub.verify_token")), []byte(s.cfg.VerifyToken)) {
+		return "", false
+	}
+	return query.Get("hub.challenge"), true
+}
+
+// webhookPayload is the subset of the Cloud API notification the bot uses
+type webhookPayload struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		Changes []struct {
+			Field string `json:"field"`
+			Value struct {
+				Metadata struct {
+					DisplayPhoneNumber string `json:"display_phone_number"`
+					PhoneNumberID      string `json:"phone_number_id"`
+				} `json:"metadata"`
+				Contacts []struct {
+					WaID    string `json:"wa_id"`
+					Profile struct {
+						Name string `json:"name"`
+					} `json:"profile"`
+				} `json:"contacts"`
+				Messages []webhookMessage `json:"messages"`
+				Statuses []webhookStatus  `json:"statuses"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type webhookMessage struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Text      struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Image    *webhookMedia `json:"image"`
+	Audio    *webhookMedia `json:"audio"`
+	Video    *webhookMedia `json:"video"`
+	Document *webhookMedia `json:"document"`
+	Sticker  *webhookMedia `json:"sticker"`
+	Location *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"location"`
+	Button *struct {
+		Text string `json:"text"`
+	} `json:"button"`
+	Interactive *struct {
+		ButtonReply *struct {
+			Title string `json:"title"`
+		} `json:"button_reply"`
+		ListReply *struct {
+			Title string `json:"title"`
+		} `json:"list_reply"`
+	} `json:"interactive"`
+}
+
+type webhookStatus struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Timestamp   string `json:"timestamp"`
+	RecipientID string `json:"recipient_id"`
+	Errors      []struct {
+		Code  int    `json:"code"`
+		Title string `json:"title"`
+	} `json:"errors"`
+}
+
+type webhookMedia struct {
+	ID       string `json:"id"`
+	Caption  string `json:"caption"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mime_type"`
+}
+
+// ParseWebhook implements messenger.Messenger. Payloads must be signed with
+// the app secret in X-Hub-Signature-256. Status notifications carry no
+// messages and yield none.
+func (s *Service) ParseWebhook(r *http.Request) ([]messenger.Inbound, error) {
+	payload, err := s.decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var inbound []messenger.Inbound
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			value := change.Value
+			names := make(map[string]string, len(value.Contacts))
+			for _, contact := range value.Contacts {
+				names[contact.WaID] = contact.Profile.Name
+			}
+
+			for _, msg := range value.Messages {
+				// Reactions are not messages to answer
+				if msg.Type == "reaction" {
+					continue
+				}
+				inbound = append(inbound, s.toInbound(msg, value.Metadata.DisplayPhoneNumber, names[msg.From]))
+			}
+		}
+	}
+	return inbound, nil
+}
+
+// ParseStatus implements messenger.StatusParser. Meta reports statuses on
+// the message webhook, in the same signed payload.
+func (s *Service) ParseStatus(r *http.Request) ([]messenger.Status, error) {
+	payload, err := s.decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []messenger.Status
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			for _, st := range change.Value.Statuses {
+				status := messenger.Status{
+					ProviderID: st.ID,
+					State:      messenger.DeliveryState(st.Status),
+					Recipient:  st.RecipientID,
+					Timestamp:  time.Now(),
+				}
+				if seconds, err := strconv.ParseInt(st.Timestamp, 10, 64); err == nil {
+					status.Timestamp = time.Unix(seconds, 0)
+				}
+				if len(st.Errors) > 0 {
+					status.Error = fmt.Sprintf("%d: %s", st.Errors[0].Code, st.Errors[0].Title)
+				}
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	return statuses, nil
+}
+
+// decode reads a webhook body, checks its signature and unmarshals it
+func (s *Service) decode(r *http.Request) (*webhookPayload, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook: %w", err)
+	}
+
+	if !s.validSignature(body, r.Header.Get("X-Hub-Signature-256")) {
+		return nil, messenger.ErrInvalidSignature
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	return &payload, nil
+}
+
+func (s *Service) validSignature(body []byte, header string) bool {
+	if s.cfg.AppSecret == "" {
+		return false
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.cfg.AppSecret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+func (s *Service) toInbound(msg webhookMessage, device, name string) messenger.Inbound {
+	inbound := messenger.Inbound{
+		ID:        msg.ID,
+		Type:      messenger.TypeText,
+		Gateway:   messenger.GatewayMeta,
+		Device:    normalizeNumber(device),
+		Sender:    msg.From,
+		Name:      name,
+		Message:   msg.Text.Body,
+		Timestamp: time.Now(),
+	}
+	if seconds, err := strconv.ParseInt(msg.Timestamp, 10, 64); err == nil {
+		inbound.Timestamp = time.Unix(seconds, 0)
+	}
+
+	// Cloud API media is referenced by ID and has to be fetched with the
+	// access token, so no URL is available here
+	attach := func(kind messenger.MessageType, media *webhookMedia) {
+		inbound.Type = kind
+		inbound.MediaID = media.ID
+		inbound.Filename = media.Filename
+		inbound.Message = media.Caption
+	}
+
+	switch {
+	case msg.Image != nil:
+		attach(messenger.TypeImage, msg.Image)
+	case msg.Sticker != nil:
+		attach(messenger.TypeImage, msg.Sticker)
+	case msg.Video != nil:
+		attach(messenger.TypeVideo, msg.Video)
+	case msg.Audio != nil:
+		attach(messenger.TypeAudio, msg.Audio)
+	case msg.Document != nil:
+		attach(messenger.TypeDocument, msg.Document)
+	case msg.Location != nil:
+		inbound.Type = messenger.TypeLocation
+		inbound.Location = fmt.Sprintf("%f,%f", msg.Location.Latitude, msg.Location.Longitude)
+	case msg.Button != nil:
+		inbound.Message = msg.Button.Text
+	case msg.Interactive != nil && msg.Interactive.ButtonReply != nil:
+		inbound.Message = msg.Interactive.ButtonReply.Title
+	case msg.Interactive != nil && msg.Interactive.ListReply != nil:
+		inbound.Message = msg.Interactive.ListReply.Title
+	case msg.Type != "text":
+		inbound.Type = messenger.TypeUnsupported
+	}
+
+	return inbound
+}
+
+// Download implements messenger.Downloader. The media ID is resolved to a
+// short-lived URL, which also needs the access token to fetch.
+func (s *Service) Download(ctx context.Context, msg messenger.Inbound) (io.ReadCloser, error) {
+	endpoint := fmt.Sprintf("%s/%s/%s", strings.TrimRight(s.cfg.BaseURL, "/"), s.cfg.APIVersion, url.PathEscape(msg.MediaID))
+	resp, err := s.get(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up media: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var media struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&media); err != nil || media.URL == "" {
+		return nil, fmt.Errorf("invalid media lookup response")
+	}
+
+	resp, err = s.get(ctx, media.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// get makes an authenticated GET request and fails on non-200 responses
+func (s *Service) get(ctx context.Context, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.AccessToken)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// normalizeNumber strips the formatting Meta uses for display numbers
+func normalizeNumber(number string) string {
+	return strings.NewReplacer("+", "", " ", "", "-", "").Replace(number)
+}
@@ -0,0 +1,144 @@
+package meta
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestService(t *testing.T, cfg config.MetaConfig) *Service {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return New(cfg, logger)
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := `{"object":"whatsapp_business_account"}`
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		want   bool
+	}{
+		{"signed", "app-secret", sign("app-secret", body), true},
+		{"other secret", "app-secret", sign("other-secret", body), false},
+		{"other body", "app-secret", sign("app-secret", body+" "), false},
+		{"not hex", "app-secret", "sha256=not-hex", false},
+		{"missing", "app-secret", "", false},
+		{"no app secret", "", sign("", body), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, config.MetaConfig{AppSecret: tt.secret})
+			if got := s.validSignature([]byte(body), tt.header); got != tt.want {
+				t.Fatalf("validSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		query     url.Values
+		challenge string
+		ok        bool
+	}{
+		{
+			name:      "subscribe",
+			token:     "verify-me",
+			query:     url.Values{"hub.mode": {"subscribe"}, "hub.verify_token": {"verify-me"}, "hub.challenge": {"1158201444"}},
+			challenge: "1158201444",
+			ok:        true,
+		},
+		{
+			name:  "wrong token",
+			token: "verify-me",
+			query: url.Values{"hub.mode": {"subscribe"}, "hub.verify_token": {"guess"}, "hub.challenge": {"1158201444"}},
+		},
+		{
+			name:  "other mode",
+			token: "verify-me",
+			query: url.Values{"hub.mode": {"unsubscribe"}, "hub.verify_token": {"verify-me"}, "hub.challenge": {"1158201444"}},
+		},
+		{
+			name:  "no token configured",
+			query: url.Values{"hub.mode": {"subscribe"}, "hub.verify_token": {""}, "hub.challenge": {"1158201444"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, config.MetaConfig{VerifyToken: tt.token})
+			challenge, ok := s.VerifyWebhook(tt.query)
+			if challenge != tt.challenge || ok != tt.ok {
+				t.Fatalf("VerifyWebhook = %q, %v, want %q, %v", challenge, ok, tt.challenge, tt.ok)
+			}
+		})
+	}
+}
+
+const statusPayload = `{"object":"whatsapp_business_account","entry":[{"changes":[{"field":"messages","value":{
+	"metadata":{"display_phone_number":"15550001111","phone_number_id":"1234"},
+	"statuses":[
+		{"id":"wamid.1","status":"delivered","timestamp":"1760000000","recipient_id":"6281234567890"},
+		{"id":"wamid.2","status":"failed","timestamp":"1760000060","recipient_id":"6281234567890",
+			"errors":[{"code":131047,"title":"Re-engagement message"}]}
+	]}}]}]}`
+
+func TestParseStatus(t *testing.T) {
+	s := newTestService(t, config.MetaConfig{AppSecret: "app-secret"})
+
+	request := func(signature string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(statusPayload))
+		r.Header.Set("X-Hub-Signature-256", signature)
+		return r
+	}
+
+	statuses, err := s.ParseStatus(request(sign("app-secret", statusPayload)))
+	if err != nil {
+		t.Fatalf("ParseStatus: %v", err)
+	}
+	want := []messenger.Status{
+		{ProviderID: "wamid.1", State: messenger.StateDelivered, Recipient: "6281234567890", Timestamp: time.Unix(1760000000, 0)},
+		{ProviderID: "wamid.2", State: messenger.StateFailed, Recipient: "6281234567890", Timestamp: time.Unix(1760000060, 0),
+			Error: "131047: Re-engagement message"},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %d statuses, want %d: %+v", len(statuses), len(want), statuses)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("status %d = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+
+	// The same notification carries no messages to answer
+	inbound, err := s.ParseWebhook(request(sign("app-secret", statusPayload)))
+	if err != nil || len(inbound) != 0 {
+		t.Fatalf("ParseWebhook = %+v, %v, want no messages", inbound, err)
+	}
+
+	if _, err := s.ParseStatus(request(sign("other-secret", statusPayload))); !errors.Is(err, messenger.ErrInvalidSignature) {
+		t.Fatalf("unsigned payload: got %v, want ErrInvalidSignature", err)
+	}
+}
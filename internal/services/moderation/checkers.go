@@ -0,0 +1,189 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	openaiService "example-tool-call/internal/services/openai"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultJudgePrompt = `You are a content moderator for a business WhatsApp account.
+Decide whether the message below is unsafe to receive or send. Answer with JSON only, in the form
+{"flagged": true|false, "categories": ["category", ...], "reason": "short explanation"}.
+Use lowercase category names such as hate, harassment, self-harm, sexual, violence, illegal, spam or off-brand.`
+
+// OpenAIChecker uses an OpenAI-compatible /moderations endpoint
+type OpenAIChecker struct {
+	openai    *openaiService.Service
+	model     string
+	threshold float64
+}
+
+func NewOpenAIChecker(openai *openaiService.Service, model string, threshold float64) *OpenAIChecker {
+	return &OpenAIChecker{openai: openai, model: model, threshold: threshold}
+}
+
+func (c *OpenAIChecker) Name() string {
+	return "openai"
+}
+
+func (c *OpenAIChecker) Check(ctx context.Context, text string) ([]Flag, error) {
+	resp, err := c.openai.Moderate(ctx, c.model, text)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags []Flag
+	for _, result := range resp.Results {
+		// The response has one boolean and one score field per category;
+		// decode both as maps so new categories need no code change
+		var flagged map[string]bool
+		var scores map[string]float64
+		if err := remarshal(result.Categories, &flagged); err != nil {
+			return nil, err
+		}
+		if err := remarshal(result.CategoryScores, &scores); err != nil {
+			return nil, err
+		}
+
+		for category, score := range scores {
+			if flagged[category] || (c.threshold > 0 && score >= c.threshold) {
+				flags = append(flags, Flag{Category: category, Score: score})
+			}
+		}
+	}
+	return flags, nil
+}
+
+func remarshal(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// BlocklistChecker flags text matching local keyword or regex lists
+type BlocklistChecker struct {
+	patterns map[string][]*regexp.Regexp
+}
+
+// NewBlocklistChecker builds a checker from category to pattern lists. A
+// pattern wrapped in slashes (/.../) is a regular expression, anything else
+// is a case-insensitive whole-word keyword.
+func NewBlocklistChecker(categories map[string][]string) (*BlocklistChecker, error) {
+	c := &BlocklistChecker{patterns: make(map[string][]*regexp.Regexp, len(categories))}
+	for category, entries := range categories {
+		for _, entry := range entries {
+			expr := `(?i)\b` + regexp.QuoteMeta(entry) + `\b`
+			if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+				expr = entry[1 : len(entry)-1]
+			}
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("blocklist %s: invalid pattern %q: %w", category, entry, err)
+			}
+			c.patterns[category] = append(c.patterns[category], pattern)
+		}
+	}
+	return c, nil
+}
+
+// LoadBlocklistFile reads a YAML file mapping categories to pattern lists
+func LoadBlocklistFile(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	var categories map[string][]string
+	if err := yaml.Unmarshal(data, &categories); err != nil {
+		return nil, fmt.Errorf("failed to parse blocklist: %w", err)
+	}
+	return categories, nil
+}
+
+func (c *BlocklistChecker) Name() string {
+	return "blocklist"
+}
+
+func (c *BlocklistChecker) Check(ctx context.Context, text string) ([]Flag, error) {
+	var flags []Flag
+	for category, patterns := range c.patterns {
+		for _, pattern := range patterns {
+			if match := pattern.FindString(text); match != "" {
+				flags = append(flags, Flag{Category: category, Score: 1, Detail: match})
+				break
+			}
+		}
+	}
+	return flags, nil
+}
+
+// JudgeChecker asks a language model to classify the text
+type JudgeChecker struct {
+	openai *openaiService.Service
+	model  string
+	prompt string
+}
+
+func NewJudgeChecker(openai *openaiService.Service, model, prompt string) *JudgeChecker {
+	if prompt == "" {
+		prompt = defaultJudgePrompt
+	}
+	return &JudgeChecker{openai: openai, model: model, prompt: prompt}
+}
+
+func (c *JudgeChecker) Name() string {
+	return "judge"
+}
+
+type judgeVerdict struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories"`
+	Reason     string   `json:"reason"`
+}
+
+func (c *JudgeChecker) Check(ctx context.Context, text string) ([]Flag, error) {
+	resp, err := c.openai.GenerateResponseWithModel(ctx, c.model, []openaiService.ChatMessage{
+		{Role: "system", Content: c.prompt},
+		{Role: "user", Content: text},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("judge returned no choices")
+	}
+
+	var verdict judgeVerdict
+	if err := json.Unmarshal([]byte(extractJSON(resp.Choices[0].Message.Content)), &verdict); err != nil {
+		return nil, fmt.Errorf("failed to parse judge verdict: %w", err)
+	}
+	if !verdict.Flagged {
+		return nil, nil
+	}
+
+	if len(verdict.Categories) == 0 {
+		verdict.Categories = []string{"unsafe"}
+	}
+	flags := make([]Flag, 0, len(verdict.Categories))
+	for _, category := range verdict.Categories {
+		flags = append(flags, Flag{Category: strings.ToLower(category), Score: 1, Detail: verdict.Reason})
+	}
+	return flags, nil
+}
+
+// extractJSON strips code fences or prose some models wrap around JSON
+func extractJSON(content string) string {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return content
+	}
+	return content[start : end+1]
+}
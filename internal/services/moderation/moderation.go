@@ -0,0 +1,303 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	openaiService "example-tool-call/internal/services/openai"
+	"github.com/sirupsen/logrus"
+)
+
+// Stage is the point in the pipeline where content is screened
+type Stage string
+
+const (
+	StageInput  Stage = "input"
+	StageOutput Stage = "output"
+	StageImage  Stage = "image"
+)
+
+// Action is what happens to content that was flagged
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionWarn   Action = "warn"
+	ActionNotify Action = "notify"
+	ActionBlock  Action = "block"
+)
+
+// Checker screens text and reports the categories it flags
+type Checker interface {
+	Name() string
+	Check(ctx context.Context, text string) ([]Flag, error)
+}
+
+// Flag is a single category raised by a checker
+type Flag struct {
+	Checker  string  `json:"checker"`
+	Category string  `json:"category"`
+	Score    float64 `json:"score,omitempty"`
+	Detail   string  `json:"detail,omitempty"`
+}
+
+// Subject identifies the conversation the content belongs to
+type Subject struct {
+	Sender    string
+	MessageID string
+}
+
+// Result is the combined outcome of all checkers
+type Result struct {
+	Action   Action
+	Notified bool
+	Flags    []Flag
+}
+
+// Blocked reports whether the content must not be processed or sent
+func (r Result) Blocked() bool {
+	return r.Action == ActionBlock
+}
+
+type Service struct {
+	checkers      []Checker
+	actions       map[string]Action
+	defaultAction Action
+	stages        map[Stage]bool
+	adminNumber   string
+	failClosed    bool
+	messenger     messenger.Sender
+	db            *database.DB
+	logger        *logrus.Logger
+}
+
+// New creates a moderation service with the checkers enabled in cfg. When
+// moderation is disabled the service allows everything.
+func New(cfg config.ModerationConfig, openai *openaiService.Service, messenger messenger.Sender, db *database.DB, logger *logrus.Logger) (*Service, error) {
+	s := &Service{
+		actions:       make(map[string]Action, len(cfg.Actions)),
+		defaultAction: ActionBlock,
+		stages:        make(map[Stage]bool, len(cfg.Stages)),
+		adminNumber:   cfg.AdminNumber,
+		failClosed:    cfg.FailClosed,
+		messenger:     messenger,
+		db:            db,
+		logger:        logger,
+	}
+
+	if !cfg.Enabled {
+		return s, nil
+	}
+
+	if cfg.DefaultAction != "" {
+		action, err := parseAction(cfg.DefaultAction)
+		if err != nil {
+			return nil, err
+		}
+		s.defaultAction = action
+	}
+
+	for category, name := range cfg.Actions {
+		action, err := parseAction(name)
+		if err != nil {
+			return nil, fmt.Errorf("category %s: %w", category, err)
+		}
+		s.actions[strings.ToLower(category)] = action
+	}
+
+	for _, stage := range cfg.Stages {
+		s.stages[Stage(strings.ToLower(stage))] = true
+	}
+
+	if cfg.OpenAI.Enabled {
+		s.AddChecker(NewOpenAIChecker(openai, cfg.OpenAI.Model, cfg.OpenAI.Threshold))
+	}
+
+	if cfg.Blocklist.File != "" || len(cfg.Blocklist.Categories) > 0 {
+		categories := make(map[string][]string, len(cfg.Blocklist.Categories))
+		for category, entries := range cfg.Blocklist.Categories {
+			categories[category] = append(categories[category], entries...)
+		}
+		if cfg.Blocklist.File != "" {
+			fromFile, err := LoadBlocklistFile(cfg.Blocklist.File)
+			if err != nil {
+				return nil, err
+			}
+			for category, entries := range fromFile {
+				categories[category] = append(categories[category], entries...)
+			}
+		}
+
+		checker, err := NewBlocklistChecker(categories)
+		if err != nil {
+			return nil, err
+		}
+		s.AddChecker(checker)
+	}
+
+	if cfg.Judge.Enabled {
+		s.AddChecker(NewJudgeChecker(openai, cfg.Judge.Model, cfg.Judge.Prompt))
+	}
+
+	logger.WithField("checkers", len(s.checkers)).Info("Moderation enabled")
+	return s, nil
+}
+
+// AddChecker registers an additional checker
+func (s *Service) AddChecker(checker Checker) {
+	s.checkers = append(s.checkers, checker)
+}
+
+func parseAction(name string) (Action, error) {
+	switch action := Action(strings.ToLower(name)); action {
+	case ActionAllow, ActionWarn, ActionNotify, ActionBlock:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown moderation action %q", name)
+	}
+}
+
+// Check runs every checker on the text, applies the configured actions and
+// records the decision for audit
+func (s *Service) Check(ctx context.Context, stage Stage, subject Subject, text string) Result {
+	result := Result{Action: ActionAllow}
+	if s == nil || len(s.checkers) == 0 || !s.stages[stage] || strings.TrimSpace(text) == "" {
+		return result
+	}
+
+	notify := false
+	for _, checker := range s.checkers {
+		flags, err := checker.Check(ctx, text)
+		if err != nil {
+			s.logger.WithError(err).WithField("checker", checker.Name()).Error("Moderation check failed")
+			if s.failClosed {
+				result.Flags = append(result.Flags, Flag{Checker: checker.Name(), Category: "error", Detail: err.Error()})
+				result.Action = ActionBlock
+			}
+			continue
+		}
+
+		for _, flag := range flags {
+			flag.Checker = checker.Name()
+			result.Flags = append(result.Flags, flag)
+
+			action := s.actionFor(flag.Category)
+			if action == ActionNotify {
+				notify = true
+				continue
+			}
+			if severity(action) > severity(result.Action) {
+				result.Action = action
+			}
+		}
+	}
+
+	if notify {
+		result.Notified = s.notifyAdmin(stage, subject, result, text)
+	}
+
+	s.record(stage, subject, result, text)
+	return result
+}
+
+func (s *Service) actionFor(category string) Action {
+	category = strings.ToLower(category)
+	if action, ok := s.actions[category]; ok {
+		return action
+	}
+	// "violence/graphic" falls back to the action for "violence"
+	if parent, _, found := strings.Cut(category, "/"); found {
+		if action, ok := s.actions[parent]; ok {
+			return action
+		}
+	}
+	return s.defaultAction
+}
+
+func severity(action Action) int {
+	switch action {
+	case ActionWarn:
+		return 1
+	case ActionBlock:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (s *Service) notifyAdmin(stage Stage, subject Subject, result Result, text string) bool {
+	if s.adminNumber == "" || s.messenger == nil {
+		s.logger.Warn("Moderation notify action triggered but no admin number is configured")
+		return false
+	}
+
+	message := fmt.Sprintf("[moderation] %s content from %s flagged (%s), action: %s\n\n%s",
+		stage, subject.Sender, strings.Join(categories(result.Flags), ", "), result.Action, truncate(text, 500))
+	if _, err := s.messenger.SendText(context.Background(), s.adminNumber, message); err != nil {
+		s.logger.WithError(err).Error("Failed to notify admin about moderation decision")
+		return false
+	}
+	return true
+}
+
+func (s *Service) record(stage Stage, subject Subject, result Result, text string) {
+	fields := logrus.Fields{
+		"stage":      stage,
+		"sender":     subject.Sender,
+		"action":     result.Action,
+		"categories": categories(result.Flags),
+	}
+	switch {
+	case result.Action == ActionBlock:
+		s.logger.WithFields(fields).Warn("Content blocked by moderation")
+	case len(result.Flags) > 0:
+		s.logger.WithFields(fields).Info("Content flagged by moderation")
+	}
+
+	decision := &models.ModerationDecision{
+		MessageID:  subject.MessageID,
+		SenderJID:  subject.Sender,
+		Stage:      string(stage),
+		Action:     string(result.Action),
+		Notified:   result.Notified,
+		Categories: strings.Join(categories(result.Flags), ","),
+		Content:    text,
+	}
+	if len(result.Flags) > 0 {
+		if flagBytes, err := json.Marshal(result.Flags); err == nil {
+			decision.Flags = string(flagBytes)
+		}
+	}
+
+	if err := s.db.SaveModerationDecision(decision); err != nil {
+		s.logger.WithError(err).Error("Failed to save moderation decision")
+	}
+}
+
+func categories(flags []Flag) []string {
+	seen := make(map[string]bool, len(flags))
+	names := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		if !seen[flag.Category] {
+			seen[flag.Category] = true
+			names = append(names, flag.Category)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "…"
+}
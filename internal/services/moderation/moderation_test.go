@@ -0,0 +1,117 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeSender records the texts it is asked to send
+type fakeSender struct {
+	sent []string
+}
+
+func (f *fakeSender) SendText(ctx context.Context, to, text string) (string, error) {
+	f.sent = append(f.sent, to+": "+text)
+	return "sent-1", nil
+}
+
+func (f *fakeSender) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	return "sent-2", nil
+}
+
+type failingChecker struct{}
+
+func (failingChecker) Name() string { return "failing" }
+
+func (failingChecker) Check(ctx context.Context, text string) ([]Flag, error) {
+	return nil, errors.New("unavailable")
+}
+
+func newTestService(t *testing.T, cfg config.ModerationConfig, sender messenger.Sender) (*Service, *database.DB) {
+	t.Helper()
+	db, err := database.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(cfg, nil, sender, db, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s, db
+}
+
+func TestCheckActions(t *testing.T) {
+	sender := &fakeSender{}
+	s, db := newTestService(t, config.ModerationConfig{
+		Enabled:       true,
+		Stages:        []string{"input"},
+		Actions:       map[string]string{"violence": "block", "spam": "warn", "self-harm": "notify"},
+		DefaultAction: "allow",
+		AdminNumber:   "6280000",
+		Blocklist: config.ModerationBlocklistConfig{Categories: map[string][]string{
+			"violence/graphic": {"gore"},
+			"spam":             {"/buy\\s+now/"},
+			"self-harm":        {"hurt myself"},
+			"profanity":        {"darn"},
+		}},
+	}, sender)
+	subject := Subject{Sender: "6281", MessageID: "msg-1"}
+
+	tests := []struct {
+		name   string
+		stage  Stage
+		text   string
+		action Action
+	}{
+		{"clean", StageInput, "hello there", ActionAllow},
+		{"parent category", StageInput, "so much GORE", ActionBlock},
+		{"regex", StageInput, "buy   now!", ActionWarn},
+		{"whole words only", StageInput, "gorey details", ActionAllow},
+		{"default action", StageInput, "darn it", ActionAllow},
+		{"most severe wins", StageInput, "buy now, gore inside", ActionBlock},
+		{"notify does not block", StageInput, "I want to hurt myself", ActionAllow},
+		{"stage not screened", StageOutput, "gore", ActionAllow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Check(context.Background(), tt.stage, subject, tt.text); got.Action != tt.action {
+				t.Fatalf("Check(%q) = %s, want %s (flags %+v)", tt.text, got.Action, tt.action, got.Flags)
+			}
+		})
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("admin got %d notifications, want 1: %v", len(sender.sent), sender.sent)
+	}
+	var decisions int64
+	if err := db.Model(&models.ModerationDecision{}).Count(&decisions).Error; err != nil {
+		t.Fatalf("count decisions: %v", err)
+	}
+	// Every screened text is recorded; the output stage is not screened
+	if decisions != int64(len(tests)-1) {
+		t.Fatalf("recorded %d decisions, want %d", decisions, len(tests)-1)
+	}
+}
+
+func TestCheckerFailure(t *testing.T) {
+	for _, failClosed := range []bool{false, true} {
+		s, _ := newTestService(t, config.ModerationConfig{Enabled: true, Stages: []string{"output"}, FailClosed: failClosed}, nil)
+		s.AddChecker(failingChecker{})
+
+		result := s.Check(context.Background(), StageOutput, Subject{Sender: "6281"}, "hello")
+		if result.Blocked() != failClosed {
+			t.Errorf("fail closed %v: blocked = %v", failClosed, result.Blocked())
+		}
+	}
+}
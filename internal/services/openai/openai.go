@@ -4,17 +4,62 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/database"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
 )
 
+// client is the part of the go-openai client the service uses. It is
+// implemented by *openai.Client, the scripted backend and the recorder.
+type client interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
+	Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error)
+}
+
+// chatStream yields the chunks of a streamed completion until io.EOF
+type chatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// streamer is implemented by clients that support streamed completions
+type streamer interface {
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error)
+}
+
+// apiClient is the go-openai client as a streamer
+type apiClient struct {
+	*openai.Client
+}
+
+func (c apiClient) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	stream, err := c.Client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
 type Service struct {
-	client   *openai.Client
-	model    string
+	client    client
+	model     string
 	maxTokens int
-	logger   *logrus.Logger
+	provider  string
+	pricing   map[string]config.ModelPrice
+	db        *database.DB
+	logger    *logrus.Logger
+
+	// Structured output settings; nativeUnsupported holds the
+	// provider/model pairs that said they do not support response_format
+	// json_schema
+	structuredNative  bool
+	structuredRetries int
+	nativeUnsupported sync.Map
 }
 
 type ToolCall struct {
@@ -25,45 +70,71 @@ type ToolCall struct {
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Untrusted marks content that did not come from the user or the bot,
+	// such as tool output or document text, and Source says where it came from
+	Untrusted bool   `json:"untrusted,omitempty"`
+	Source    string `json:"source,omitempty"`
 }
 
-func New(apiKey, baseURL, model string, maxTokens int, logger *logrus.Logger) *Service {
-	config := openai.DefaultConfig(apiKey)
-	
-	// Set custom base URL if provided
-	if baseURL != "" {
-		config.BaseURL = baseURL
-		logger.WithField("base_url", baseURL).Info("Using custom OpenAI-compatible API endpoint")
+// New creates the LLM service. With provider "scripted" completions come
+// from the rules file in cfg.ScriptFile instead of an API; otherwise the
+// OpenAI-compatible API is used, and every completion is appended to
+// cfg.RecordFile when set so it can be replayed by the scripted provider.
+func New(cfg config.OpenAIConfig, db *database.DB, logger *logrus.Logger) (*Service, error) {
+	var c client
+	if cfg.Provider == ScriptedProvider {
+		scripted, err := NewScripted(cfg.ScriptFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.WithField("script_file", cfg.ScriptFile).Info("Using scripted LLM provider")
+		c = scripted
+	} else {
+		clientConfig := openai.DefaultConfig(cfg.APIKey)
+
+		// Set custom base URL if provided
+		if cfg.BaseURL != "" {
+			clientConfig.BaseURL = cfg.BaseURL
+			logger.WithField("base_url", cfg.BaseURL).Info("Using custom OpenAI-compatible API endpoint")
+		}
+
+		c = apiClient{openai.NewClientWithConfig(clientConfig)}
+		if cfg.RecordFile != "" {
+			logger.WithField("record_file", cfg.RecordFile).Info("Recording completions")
+			c = newRecorder(c, cfg.RecordFile, logger)
+		}
 	}
-	
-	client := openai.NewClientWithConfig(config)
+
 	return &Service{
-		client:    client,
-		model:     model,
-		maxTokens: maxTokens,
+		client:    c,
+		model:     cfg.Model,
+		maxTokens: cfg.MaxTokens,
+		provider:  cfg.Provider,
+		pricing:   cfg.Pricing,
+		db:        db,
 		logger:    logger,
-	}
+
+		structuredNative:  cfg.JSONSchema,
+		structuredRetries: cfg.StructuredRetries,
+	}, nil
+}
+
+// Model returns the default model used by GenerateResponse
+func (s *Service) Model() string {
+	return s.model
 }
 
 func (s *Service) GenerateResponse(ctx context.Context, messages []ChatMessage, tools []openai.Tool) (*openai.ChatCompletionResponse, error) {
-	// Convert our messages to OpenAI format
-	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
-	for i, msg := range messages {
-		openaiMessages[i] = openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
+	return s.GenerateResponseWithModel(ctx, s.model, messages, tools)
+}
 
-	req := openai.ChatCompletionRequest{
-		Model:     s.model,
-		Messages:  openaiMessages,
-		MaxTokens: s.maxTokens,
-		Tools:     tools,
-	}
+// GenerateResponseWithModel is GenerateResponse with an explicit model,
+// used when the model is chosen per message
+func (s *Service) GenerateResponseWithModel(ctx context.Context, model string, messages []ChatMessage, tools []openai.Tool) (*openai.ChatCompletionResponse, error) {
+	req := s.buildRequest(model, messages, tools)
 
 	s.logger.WithFields(logrus.Fields{
-		"model":      s.model,
+		"model":      req.Model,
 		"messages":   len(messages),
 		"tools":      len(tools),
 		"max_tokens": s.maxTokens,
@@ -73,6 +144,8 @@ func (s *Service) GenerateResponse(ctx context.Context, messages []ChatMessage,
 	resp, err := s.client.CreateChatCompletion(ctx, req)
 	duration := time.Since(start)
 
+	s.recordCall(ctx, req.Model, resp, duration, err)
+
 	if err != nil {
 		s.logger.WithFields(logrus.Fields{
 			"error":    err.Error(),
@@ -84,12 +157,35 @@ func (s *Service) GenerateResponse(ctx context.Context, messages []ChatMessage,
 	s.logger.WithFields(logrus.Fields{
 		"duration":     duration,
 		"usage_tokens": resp.Usage.TotalTokens,
+		"cost":         s.Cost(resp.Model, resp.Usage),
 		"choices":      len(resp.Choices),
 	}).Info("OpenAI request completed")
 
 	return &resp, nil
 }
 
+func (s *Service) buildRequest(model string, messages []ChatMessage, tools []openai.Tool) openai.ChatCompletionRequest {
+	if model == "" {
+		model = s.model
+	}
+
+	// Convert our messages to OpenAI format
+	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		openaiMessages[i] = openai.ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+	}
+
+	return openai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  openaiMessages,
+		MaxTokens: s.maxTokens,
+		Tools:     tools,
+	}
+}
+
 func (s *Service) GetAvailableTools() []openai.Tool {
 	return []openai.Tool{
 		{
@@ -132,4 +228,50 @@ func (s *Service) ParseToolCall(toolCall openai.ToolCall) (*ToolCall, error) {
 		Name:       toolCall.Function.Name,
 		Parameters: params,
 	}, nil
-}
\ No newline at end of file
+}
+
+// Embed returns the embedding vector of text. Usage is recorded like a
+// completion so embedding cost shows up in the usage statistics.
+func (s *Service) Embed(ctx context.Context, model, text string) ([]float32, error) {
+	start := time.Now()
+	resp, err := s.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: []string{text},
+		Model: openai.EmbeddingModel(model),
+	})
+	duration := time.Since(start)
+
+	s.recordCall(ctx, model, openai.ChatCompletionResponse{Model: string(resp.Model), Usage: resp.Usage}, duration, err)
+
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI embedding request failed: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("OpenAI returned no embeddings")
+	}
+	return resp.Data[0].Embedding, nil
+}
+
+// Moderate classifies text with the OpenAI-compatible /moderations endpoint
+func (s *Service) Moderate(ctx context.Context, model, text string) (*openai.ModerationResponse, error) {
+	start := time.Now()
+	resp, err := s.client.Moderations(ctx, openai.ModerationRequest{
+		Model: model,
+		Input: text,
+	})
+	duration := time.Since(start)
+
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"duration": duration,
+		}).Error("OpenAI moderation request failed")
+		return nil, fmt.Errorf("OpenAI moderation request failed: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"duration": duration,
+		"results":  len(resp.Results),
+	}).Debug("OpenAI moderation request completed")
+
+	return &resp, nil
+}
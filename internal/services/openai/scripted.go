@@ -0,0 +1,366 @@
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptedProvider is the provider name that selects the scripted backend
+const ScriptedProvider = "scripted"
+
+const scriptedEmbeddingSize = 64
+
+// Scripted is a deterministic backend for local development and tests. It
+// replays recorded completions and answers everything else from regex
+// rules matched against the last user message.
+type Scripted struct {
+	rules        []scriptedRule
+	defaultReply string
+
+	mu         sync.Mutex
+	recordings map[string][]openai.ChatCompletionResponse
+	calls      int
+}
+
+type scriptFile struct {
+	Recordings   string           `yaml:"recordings"`
+	DefaultReply string           `yaml:"default_reply"`
+	Rules        []scriptRuleSpec `yaml:"rules"`
+}
+
+type scriptRuleSpec struct {
+	Match    string              `yaml:"match"`
+	Reply    string              `yaml:"reply"`
+	ToolCall *scriptToolCallSpec `yaml:"tool_call"`
+}
+
+type scriptToolCallSpec struct {
+	Name      string                 `yaml:"name"`
+	Arguments map[string]interface{} `yaml:"arguments"`
+}
+
+type scriptedRule struct {
+	scriptRuleSpec
+	pattern *regexp.Regexp
+}
+
+// recording is one line of a recordings file
+type recording struct {
+	Messages []ChatMessage                 `json:"messages"`
+	Response openai.ChatCompletionResponse `json:"response"`
+}
+
+// NewScripted loads a rules file. The recordings file it names, if any, is
+// resolved relative to the rules file.
+func NewScripted(path string) (*Scripted, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script file: %w", err)
+	}
+
+	var file scriptFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse script file: %w", err)
+	}
+
+	s := &Scripted{
+		defaultReply: file.DefaultReply,
+		recordings:   make(map[string][]openai.ChatCompletionResponse),
+	}
+	if s.defaultReply == "" {
+		s.defaultReply = "This is a scripted reply."
+	}
+
+	for i, spec := range file.Rules {
+		if spec.Reply == "" && spec.ToolCall == nil {
+			return nil, fmt.Errorf("script rule %d: reply or tool_call is required", i)
+		}
+		pattern, err := regexp.Compile(spec.Match)
+		if err != nil {
+			return nil, fmt.Errorf("script rule %d: invalid match: %w", i, err)
+		}
+		s.rules = append(s.rules, scriptedRule{scriptRuleSpec: spec, pattern: pattern})
+	}
+
+	if file.Recordings != "" {
+		recordingsPath := file.Recordings
+		if !filepath.IsAbs(recordingsPath) {
+			recordingsPath = filepath.Join(filepath.Dir(path), recordingsPath)
+		}
+		if err := s.loadRecordings(recordingsPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Scripted) loadRecordings(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open recordings: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("recordings line %d: %w", line, err)
+		}
+		key := lastUserMessage(rec.Messages)
+		s.recordings[key] = append(s.recordings[key], rec.Response)
+	}
+	return scanner.Err()
+}
+
+// CreateChatCompletion answers from recordings first, then from the first
+// matching rule, then with the default reply
+func (s *Scripted) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	messages := make([]ChatMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages[i] = ChatMessage{Role: msg.Role, Content: msg.Content}
+	}
+	text := lastUserMessage(messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+
+	// Recorded completions are replayed in order; the last one repeats
+	if queue := s.recordings[text]; len(queue) > 0 {
+		resp := queue[0]
+		if len(queue) > 1 {
+			s.recordings[text] = queue[1:]
+		}
+		return resp, nil
+	}
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: s.defaultReply}
+	finishReason := openai.FinishReasonStop
+
+	for _, rule := range s.rules {
+		match := rule.pattern.FindStringSubmatchIndex(text)
+		if match == nil {
+			continue
+		}
+
+		message.Content = string(rule.pattern.ExpandString(nil, rule.Reply, text, match))
+		if rule.ToolCall != nil {
+			arguments, err := json.Marshal(expandArguments(rule.pattern, text, match, rule.ToolCall.Arguments))
+			if err != nil {
+				return openai.ChatCompletionResponse{}, fmt.Errorf("failed to encode scripted tool arguments: %w", err)
+			}
+			message.ToolCalls = []openai.ToolCall{{
+				ID:   fmt.Sprintf("call_scripted_%d", s.calls),
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      rule.ToolCall.Name,
+					Arguments: string(arguments),
+				},
+			}}
+			finishReason = openai.FinishReasonToolCalls
+		}
+		break
+	}
+
+	return openai.ChatCompletionResponse{
+		ID:     fmt.Sprintf("scripted-%d", s.calls),
+		Object: "chat.completion",
+		Model:  req.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Index:        0,
+			Message:      message,
+			FinishReason: finishReason,
+		}},
+	}, nil
+}
+
+// CreateEmbeddings returns bag-of-words hash vectors, so texts sharing words
+// are similar and identical texts have identical embeddings
+func (s *Scripted) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	req := conv.Convert()
+	inputs, ok := req.Input.([]string)
+	if !ok {
+		return openai.EmbeddingResponse{}, fmt.Errorf("scripted embeddings support string input only")
+	}
+
+	resp := openai.EmbeddingResponse{Object: "list", Model: req.Model}
+	for i, input := range inputs {
+		resp.Data = append(resp.Data, openai.Embedding{
+			Object:    "embedding",
+			Embedding: hashEmbedding(input),
+			Index:     i,
+		})
+	}
+	return resp, nil
+}
+
+// Moderations flags nothing
+func (s *Scripted) Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{
+		ID:      "scripted-moderation",
+		Model:   req.Model,
+		Results: []openai.Result{{}},
+	}, nil
+}
+
+func lastUserMessage(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == openai.ChatMessageRoleUser {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// expandArguments substitutes $1, ${name} and so on in string arguments
+func expandArguments(pattern *regexp.Regexp, text string, match []int, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return string(pattern.ExpandString(nil, v, text, match))
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			expanded[key] = expandArguments(pattern, text, match, item)
+		}
+		return expanded
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded[i] = expandArguments(pattern, text, match, item)
+		}
+		return expanded
+	default:
+		return v
+	}
+}
+
+func hashEmbedding(text string) []float32 {
+	vector := make([]float32, scriptedEmbeddingSize)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%scriptedEmbeddingSize]++
+	}
+
+	var norm float64
+	for _, x := range vector {
+		norm += float64(x * x)
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vector {
+			vector[i] = float32(float64(vector[i]) / norm)
+		}
+	}
+	return vector
+}
+
+// recorder appends every successful completion to a JSON lines file in the
+// format the scripted backend replays
+type recorder struct {
+	client
+	path   string
+	logger *logrus.Logger
+	mu     sync.Mutex
+}
+
+func newRecorder(c client, path string, logger *logrus.Logger) *recorder {
+	return &recorder{client: c, path: path, logger: logger}
+}
+
+func (r *recorder) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	resp, err := r.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+
+	r.record(req, resp)
+	return resp, nil
+}
+
+// CreateChatCompletionStream streams from the underlying client and records
+// the merged completion once the stream is complete, so recordings replay
+// the same way whether or not streaming was enabled
+func (r *recorder) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	streaming, ok := r.client.(streamer)
+	if !ok {
+		return nil, fmt.Errorf("recorded client does not support streaming")
+	}
+	stream, err := streaming.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingStream{chatStream: stream, recorder: r, req: req}, nil
+}
+
+func (r *recorder) record(req openai.ChatCompletionRequest, resp openai.ChatCompletionResponse) {
+	rec := recording{Response: resp}
+	for _, msg := range req.Messages {
+		rec.Messages = append(rec.Messages, ChatMessage{Role: msg.Role, Content: msg.Content})
+	}
+	if err := r.append(rec); err != nil {
+		r.logger.WithError(err).Error("Failed to record completion")
+	}
+}
+
+func (r *recorder) append(rec recording) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// recordingStream merges the chunks it passes on and records the result when
+// the stream ends. Streams that fail or are closed early are not recorded.
+type recordingStream struct {
+	chatStream
+	recorder *recorder
+	req      openai.ChatCompletionRequest
+	merged   streamMerger
+	done     bool
+}
+
+func (s *recordingStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	chunk, err := s.chatStream.Recv()
+	if errors.Is(err, io.EOF) && !s.done {
+		s.done = true
+		s.recorder.record(s.req, s.merged.response())
+	}
+	if err == nil {
+		s.merged.add(chunk)
+	}
+	return chunk, err
+}
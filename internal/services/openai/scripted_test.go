@@ -0,0 +1,81 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example-tool-call/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRecorderStream(t *testing.T) {
+	chunks := []string{
+		`{"id":"chatcmpl-1","model":"test-model","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`,
+		`{"id":"chatcmpl-1","model":"test-model","choices":[{"index":0,"delta":{"content":" there"}}]}`,
+		`{"id":"chatcmpl-1","model":"test-model","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"id":"chatcmpl-1","model":"test-model","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			io.WriteString(w, "data: "+chunk+"\n\n")
+		}
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "recordings.jsonl")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(config.OpenAIConfig{
+		APIKey:     "test",
+		BaseURL:    server.URL + "/v1",
+		Model:      "test-model",
+		Provider:   "openai",
+		RecordFile: path,
+	}, nil, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var streamed strings.Builder
+	messages := []ChatMessage{{Role: "user", Content: "hi"}}
+	resp, err := s.GenerateResponseStream(context.Background(), "", messages, nil, func(delta string) {
+		streamed.WriteString(delta)
+	})
+	if err != nil {
+		t.Fatalf("GenerateResponseStream: %v", err)
+	}
+	if streamed.String() != "Hello there" || resp.Choices[0].Message.Content != "Hello there" {
+		t.Fatalf("streamed %q, response %q", streamed.String(), resp.Choices[0].Message.Content)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read recordings: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("recorded %d lines, want 1", len(lines))
+	}
+	var rec recording
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("decode recording: %v", err)
+	}
+	if len(rec.Messages) != 1 || rec.Messages[0].Content != "hi" {
+		t.Errorf("recorded messages %+v", rec.Messages)
+	}
+	if got := rec.Response.Choices[0]; got.Message.Content != "Hello there" || got.FinishReason != "stop" {
+		t.Errorf("recorded choice %+v", got)
+	}
+	if rec.Response.Usage.TotalTokens != 5 {
+		t.Errorf("recorded usage %+v", rec.Response.Usage)
+	}
+}
@@ -0,0 +1,174 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+// DeltaFunc receives each piece of assistant text as it is streamed
+type DeltaFunc func(content string)
+
+// GenerateResponseStream streams a completion, calling onDelta for every
+// content delta, and returns the assembled response including any tool calls
+// so callers can treat it exactly like a GenerateResponse result
+func (s *Service) GenerateResponseStream(ctx context.Context, model string, messages []ChatMessage, tools []openai.Tool, onDelta DeltaFunc) (*openai.ChatCompletionResponse, error) {
+	req := s.buildRequest(model, messages, tools)
+	req.Stream = true
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+
+	s.logger.WithFields(logrus.Fields{
+		"model":      req.Model,
+		"messages":   len(messages),
+		"tools":      len(tools),
+		"max_tokens": s.maxTokens,
+	}).Debug("Sending streaming request to OpenAI")
+
+	start := time.Now()
+	resp, err := s.stream(ctx, req, onDelta)
+	duration := time.Since(start)
+
+	s.recordCall(ctx, req.Model, resp, duration, err)
+
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"duration": duration,
+		}).Error("OpenAI streaming request failed")
+		return nil, fmt.Errorf("OpenAI streaming request failed: %w", err)
+	}
+
+	toolCalls := 0
+	if len(resp.Choices) > 0 {
+		toolCalls = len(resp.Choices[0].Message.ToolCalls)
+	}
+	s.logger.WithFields(logrus.Fields{
+		"duration":     duration,
+		"usage_tokens": resp.Usage.TotalTokens,
+		"cost":         s.Cost(resp.Model, resp.Usage),
+		"tool_calls":   toolCalls,
+	}).Info("OpenAI streaming request completed")
+
+	return &resp, nil
+}
+
+func (s *Service) stream(ctx context.Context, req openai.ChatCompletionRequest, onDelta DeltaFunc) (openai.ChatCompletionResponse, error) {
+	streaming, ok := s.client.(streamer)
+	if !ok {
+		// Deliver the whole completion as a single delta
+		req.Stream, req.StreamOptions = false, nil
+		resp, err := s.client.CreateChatCompletion(ctx, req)
+		if err == nil && len(resp.Choices) > 0 && onDelta != nil && resp.Choices[0].Message.Content != "" {
+			onDelta(resp.Choices[0].Message.Content)
+		}
+		return resp, err
+	}
+
+	stream, err := streaming.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	var merged streamMerger
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		if delta := merged.add(chunk); delta != "" && onDelta != nil {
+			onDelta(delta)
+		}
+	}
+	return merged.response(), nil
+}
+
+// streamMerger assembles streamed chunks into the response a non-streamed
+// request would have returned. Only the first choice is kept.
+type streamMerger struct {
+	resp         openai.ChatCompletionResponse
+	content      strings.Builder
+	toolCalls    []openai.ToolCall
+	finishReason openai.FinishReason
+}
+
+// add folds a chunk into the response and returns its content delta
+func (m *streamMerger) add(chunk openai.ChatCompletionStreamResponse) string {
+	if m.resp.ID == "" {
+		m.resp.ID = chunk.ID
+		m.resp.Model = chunk.Model
+		m.resp.Created = chunk.Created
+	}
+	if chunk.Usage != nil {
+		m.resp.Usage = *chunk.Usage
+	}
+
+	var delta string
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			m.content.WriteString(choice.Delta.Content)
+			delta += choice.Delta.Content
+		}
+		m.toolCalls = mergeToolCallDeltas(m.toolCalls, choice.Delta.ToolCalls)
+		if choice.FinishReason != "" {
+			m.finishReason = choice.FinishReason
+		}
+	}
+	return delta
+}
+
+// response returns the completion assembled so far
+func (m *streamMerger) response() openai.ChatCompletionResponse {
+	resp := m.resp
+	resp.Object = "chat.completion"
+	resp.Choices = []openai.ChatCompletionChoice{{
+		Index: 0,
+		Message: openai.ChatCompletionMessage{
+			Role:      openai.ChatMessageRoleAssistant,
+			Content:   m.content.String(),
+			ToolCalls: m.toolCalls,
+		},
+		FinishReason: m.finishReason,
+	}}
+	return resp
+}
+
+// mergeToolCallDeltas folds streamed tool call fragments into complete calls.
+// The first fragment of a call carries its ID and name, and later fragments
+// with the same index append to its arguments.
+func mergeToolCallDeltas(calls []openai.ToolCall, deltas []openai.ToolCall) []openai.ToolCall {
+	for _, delta := range deltas {
+		index := len(calls)
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		for len(calls) <= index {
+			calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+
+		call := &calls[index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		if delta.Function.Name != "" {
+			call.Function.Name += delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
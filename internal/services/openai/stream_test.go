@@ -0,0 +1,41 @@
+package openai
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"example-tool-call/internal/config"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+// emptyClient answers every completion without choices and cannot stream
+type emptyClient struct {
+	client
+}
+
+func (emptyClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return openai.ChatCompletionResponse{Model: req.Model}, nil
+}
+
+func TestStreamWithoutChoices(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(config.OpenAIConfig{APIKey: "test", Model: "test-model", Provider: "openai"}, nil, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s.client = emptyClient{}
+
+	messages := []ChatMessage{{Role: "user", Content: "hi"}}
+	resp, err := s.GenerateResponseStream(context.Background(), "", messages, nil, func(string) {
+		t.Fatal("no delta expected")
+	})
+	if err != nil {
+		t.Fatalf("GenerateResponseStream: %v", err)
+	}
+	if len(resp.Choices) != 0 {
+		t.Fatalf("got %d choices, want none", len(resp.Choices))
+	}
+}
@@ -0,0 +1,306 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/sirupsen/logrus"
+)
+
+const structuredPrompt = "Respond with a single JSON value that conforms to the JSON Schema below. " +
+	"Output the JSON only, with no code fences or commentary.\n\nSchema:\n%s"
+
+const repairPrompt = "Your previous reply was not valid for the schema:\n- %s\n\n" +
+	"Reply again with corrected JSON only."
+
+// Schema describes the JSON shape GenerateStructured must return
+type Schema struct {
+	Name        string
+	Description string
+	Definition  *jsonschema.Definition
+}
+
+// SchemaFor derives a schema from a Go value's type, honouring json and
+// jsonschema struct tags (description, enum, required)
+func SchemaFor(name string, v interface{}) (*Schema, error) {
+	definition, err := jsonschema.GenerateSchemaForType(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate schema for %s: %w", name, err)
+	}
+	return &Schema{Name: name, Definition: definition}, nil
+}
+
+// SchemaFromJSON parses a raw JSON Schema document
+func SchemaFromJSON(name string, raw []byte) (*Schema, error) {
+	var definition jsonschema.Definition
+	if err := json.Unmarshal(raw, &definition); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", name, err)
+	}
+	return &Schema{Name: name, Definition: &definition}, nil
+}
+
+// ValidationError is returned when the model did not produce valid JSON for
+// the schema within the allowed number of attempts
+type ValidationError struct {
+	Schema   string
+	Content  string
+	Problems []string
+	Attempts int
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("structured output for %s invalid after %d attempt(s): %s",
+		e.Schema, e.Attempts, strings.Join(e.Problems, "; "))
+}
+
+// GenerateStructured asks the model for JSON matching schema and decodes it
+// into out. Providers that support response_format json_schema get it
+// natively; otherwise, or if the provider rejects it, the schema is put in
+// the prompt. Either way the reply is validated and the model is asked to
+// repair invalid output up to the configured number of retries.
+func (s *Service) GenerateStructured(ctx context.Context, model string, messages []ChatMessage, schema *Schema, out interface{}) error {
+	if schema == nil || schema.Definition == nil {
+		return fmt.Errorf("schema is required")
+	}
+
+	schemaJSON, err := json.Marshal(schema.Definition)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	if model == "" {
+		model = s.model
+	}
+	nativeKey := s.provider + "/" + model
+	_, unsupported := s.nativeUnsupported.Load(nativeKey)
+	native := s.structuredNative && !unsupported
+	conversation := append([]ChatMessage(nil), messages...)
+	if !native {
+		conversation = append(conversation, ChatMessage{
+			Role:    "system",
+			Content: fmt.Sprintf(structuredPrompt, schemaJSON),
+		})
+	}
+
+	var lastErr *ValidationError
+	for attempt := 1; attempt <= s.structuredRetries+1; attempt++ {
+		req := s.buildRequest(model, conversation, nil)
+		if native {
+			req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+				Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+				JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+					Name:        schema.Name,
+					Description: schema.Description,
+					Schema:      schema.Definition,
+					Strict:      true,
+				},
+			}
+		}
+
+		content, err := s.complete(ctx, req)
+		if err != nil && native && isFormatError(err) {
+			// Retry this attempt with the schema in the prompt. Only a
+			// provider that says it cannot do json_schema at all is
+			// remembered; a schema it refuses only affects this request.
+			if isUnsupportedFormat(err) {
+				s.logger.WithError(err).WithField("model", model).Warn("Provider does not support json_schema response format, falling back to prompt mode")
+				s.nativeUnsupported.Store(nativeKey, struct{}{})
+			} else {
+				s.logger.WithError(err).WithField("model", model).Warn("Provider rejected json_schema request, retrying with the schema in the prompt")
+			}
+			native = false
+			conversation = append(conversation, ChatMessage{
+				Role:    "system",
+				Content: fmt.Sprintf(structuredPrompt, schemaJSON),
+			})
+			attempt--
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		problems := decodeStructured(content, schema.Definition, out)
+		if len(problems) == 0 {
+			return nil
+		}
+
+		lastErr = &ValidationError{Schema: schema.Name, Content: content, Problems: problems, Attempts: attempt}
+		s.logger.WithFields(logrus.Fields{
+			"schema":   schema.Name,
+			"attempt":  attempt,
+			"problems": problems,
+		}).Warn("Structured output failed validation")
+
+		conversation = append(conversation,
+			ChatMessage{Role: "assistant", Content: content},
+			ChatMessage{Role: "user", Content: fmt.Sprintf(repairPrompt, strings.Join(problems, "\n- "))},
+		)
+	}
+
+	return lastErr
+}
+
+func (s *Service) complete(ctx context.Context, req openai.ChatCompletionRequest) (string, error) {
+	start := time.Now()
+	resp, err := s.client.CreateChatCompletion(ctx, req)
+	s.recordCall(ctx, req.Model, resp, time.Since(start), err)
+	if err != nil {
+		return "", fmt.Errorf("OpenAI request failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("OpenAI returned no choices")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// isFormatError reports whether the provider refused the request because of
+// its response_format, for whatever reason
+func isFormatError(err error) bool {
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusBadRequest {
+		return false
+	}
+	message := strings.ToLower(apiErr.Message)
+	return strings.Contains(message, "response_format") || strings.Contains(message, "json_schema")
+}
+
+// isUnsupportedFormat reports whether the provider refused response_format
+// json_schema as unsupported, rather than refusing the schema it was given
+func isUnsupportedFormat(err error) bool {
+	if !isFormatError(err) {
+		return false
+	}
+	var apiErr *openai.APIError
+	errors.As(err, &apiErr)
+	message := strings.ToLower(apiErr.Message)
+	if strings.Contains(message, "invalid schema") {
+		return false
+	}
+	for _, phrase := range []string{"not supported", "unsupported", "does not support", "not available"} {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeStructured validates content against the schema and decodes it into
+// out, returning a list of human readable problems
+func decodeStructured(content string, definition *jsonschema.Definition, out interface{}) []string {
+	content = strings.TrimSpace(content)
+	if start, end := strings.IndexAny(content, "{["), strings.LastIndexAny(content, "}]"); start >= 0 && end > start {
+		// Tolerate code fences or a sentence around the JSON
+		content = content[start : end+1]
+	}
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return []string{fmt.Sprintf("not valid JSON: %v", err)}
+	}
+
+	problems := validateValue(*definition, data, "$", jsonschema.CollectDefs(*definition))
+	if len(problems) > 0 {
+		return problems
+	}
+
+	if err := json.Unmarshal([]byte(content), out); err != nil {
+		return []string{fmt.Sprintf("does not decode into the target type: %v", err)}
+	}
+	return nil
+}
+
+func validateValue(def jsonschema.Definition, data interface{}, path string, defs map[string]jsonschema.Definition) []string {
+	if def.Ref != "" {
+		resolved, ok := defs[def.Ref]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved schema reference %s", path, def.Ref)}
+		}
+		def = resolved
+	}
+
+	if data == nil {
+		if def.Nullable || def.Type == jsonschema.Null || def.Type == "" {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: must not be null", path)}
+	}
+
+	var problems []string
+	switch def.Type {
+	case jsonschema.Object:
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object", path)}
+		}
+		for _, name := range def.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: is required", path, name))
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := def.Properties[name]
+			if !ok {
+				if def.AdditionalProperties == false {
+					problems = append(problems, fmt.Sprintf("%s.%s: is not allowed", path, name))
+				}
+				continue
+			}
+			problems = append(problems, validateValue(prop, obj[name], path+"."+name, defs)...)
+		}
+	case jsonschema.Array:
+		arr, ok := data.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array", path)}
+		}
+		if def.Items != nil {
+			for i, item := range arr {
+				problems = append(problems, validateValue(*def.Items, item, fmt.Sprintf("%s[%d]", path, i), defs)...)
+			}
+		}
+	case jsonschema.String:
+		str, ok := data.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected a string", path)}
+		}
+		if len(def.Enum) > 0 && !containsString(def.Enum, str) {
+			problems = append(problems, fmt.Sprintf("%s: must be one of %s", path, strings.Join(def.Enum, ", ")))
+		}
+	case jsonschema.Number:
+		if _, ok := data.(float64); !ok {
+			return []string{fmt.Sprintf("%s: expected a number", path)}
+		}
+	case jsonschema.Integer:
+		num, ok := data.(float64)
+		if !ok || num != float64(int64(num)) {
+			return []string{fmt.Sprintf("%s: expected an integer", path)}
+		}
+	case jsonschema.Boolean:
+		if _, ok := data.(bool); !ok {
+			return []string{fmt.Sprintf("%s: expected a boolean", path)}
+		}
+	}
+	return problems
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example-tool-call/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+type answer struct {
+	Answer string `json:"answer"`
+}
+
+// newStructuredService starts a fake completions API that refuses
+// response_format with rejection and answers prompt-mode requests with
+// valid JSON. It returns how many requests of each mode it received.
+func newStructuredService(t *testing.T, rejection string) (*Service, *int, *int) {
+	t.Helper()
+	var native, prompt int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResponseFormat *json.RawMessage `json:"response_format"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.ResponseFormat != nil {
+			native++
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{"message": rejection, "type": "invalid_request_error"},
+			})
+			return
+		}
+		prompt++
+		io.WriteString(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"{\"answer\":\"42\"}"},"finish_reason":"stop"}]}`)
+	}))
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(config.OpenAIConfig{
+		APIKey:            "test",
+		BaseURL:           server.URL + "/v1",
+		Model:             "test-model",
+		Provider:          "openai",
+		JSONSchema:        true,
+		StructuredRetries: 1,
+	}, nil, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s, &native, &prompt
+}
+
+func TestStructuredFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		rejection  string
+		wantNative int // native attempts over two calls
+	}{
+		{"unsupported", "response_format json_schema is not supported by this model", 1},
+		{"invalid schema", "Invalid schema for response_format 'answer': 'additionalProperties' is required to be supplied and to be false", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, native, prompt := newStructuredService(t, tt.rejection)
+			schema, err := SchemaFor("answer", answer{})
+			if err != nil {
+				t.Fatalf("SchemaFor: %v", err)
+			}
+
+			for i := 0; i < 2; i++ {
+				var out answer
+				if err := s.GenerateStructured(context.Background(), "", []ChatMessage{{Role: "user", Content: "?"}}, schema, &out); err != nil {
+					t.Fatalf("GenerateStructured: %v", err)
+				}
+				if out.Answer != "42" {
+					t.Fatalf("answer = %q, want 42", out.Answer)
+				}
+			}
+			if *native != tt.wantNative || *prompt != 2 {
+				t.Errorf("got %d native and %d prompt requests, want %d and 2", *native, *prompt, tt.wantNative)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package openai
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"github.com/sashabaranov/go-openai"
+)
+
+// CallMeta identifies which conversation an LLM call was made for
+type CallMeta struct {
+	MessageID string
+	Sender    string
+}
+
+type callMetaKey struct{}
+
+// WithCallMeta attaches call metadata to the context so that usage records
+// can be linked back to the message and sender that triggered them
+func WithCallMeta(ctx context.Context, meta CallMeta) context.Context {
+	return context.WithValue(ctx, callMetaKey{}, meta)
+}
+
+func callMetaFrom(ctx context.Context) CallMeta {
+	meta, _ := ctx.Value(callMetaKey{}).(CallMeta)
+	return meta
+}
+
+// Cost computes the USD cost of a completion from the configured price table.
+// Cached prompt tokens are billed at the cached rate when one is configured.
+func (s *Service) Cost(model string, usage openai.Usage) float64 {
+	price, ok := s.lookupPrice(model)
+	if !ok {
+		return 0
+	}
+
+	cached := 0
+	if usage.PromptTokensDetails != nil {
+		cached = usage.PromptTokensDetails.CachedTokens
+	}
+
+	cachedPrice := price.Cached
+	if cachedPrice == 0 {
+		cachedPrice = price.Prompt
+	}
+
+	cost := float64(usage.PromptTokens-cached)*price.Prompt +
+		float64(cached)*cachedPrice +
+		float64(usage.CompletionTokens)*price.Completion
+	return cost / 1_000_000
+}
+
+func (s *Service) lookupPrice(model string) (config.ModelPrice, bool) {
+	model = strings.ToLower(model)
+	if p, found := s.pricing[model]; found {
+		return p, true
+	}
+
+	// Providers often answer with a dated snapshot name (gpt-4o-2024-08-06),
+	// so fall back to the longest configured prefix
+	best := ""
+	for name := range s.pricing {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return config.ModelPrice{}, false
+	}
+	return s.pricing[best], true
+}
+
+func (s *Service) recordCall(ctx context.Context, model string, resp openai.ChatCompletionResponse, duration time.Duration, err error) {
+	if s.db == nil {
+		return
+	}
+
+	meta := callMetaFrom(ctx)
+	call := &models.LLMCall{
+		MessageID: meta.MessageID,
+		SenderJID: meta.Sender,
+		Provider:  s.provider,
+		Model:     model,
+		Latency:   duration.Milliseconds(),
+		Success:   err == nil,
+	}
+
+	if err != nil {
+		call.ErrorMsg = err.Error()
+	} else {
+		if resp.Model != "" {
+			call.Model = resp.Model
+		}
+		call.PromptTokens = resp.Usage.PromptTokens
+		call.CompletionTokens = resp.Usage.CompletionTokens
+		call.TotalTokens = resp.Usage.TotalTokens
+		if resp.Usage.PromptTokensDetails != nil {
+			call.CachedTokens = resp.Usage.PromptTokensDetails.CachedTokens
+		}
+		if len(resp.Choices) > 0 {
+			call.FinishReason = string(resp.Choices[0].FinishReason)
+		}
+		call.Cost = s.Cost(call.Model, resp.Usage)
+	}
+
+	if dbErr := s.db.SaveLLMCall(call); dbErr != nil {
+		s.logger.WithError(dbErr).Error("Failed to save LLM call")
+	}
+}
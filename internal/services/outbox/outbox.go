@@ -0,0 +1,478 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/format"
+	"example-tool-call/internal/services/messenger"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// kindText marks a text message; media messages use their media type
+const kindText = "text"
+
+// maxRetryDelay caps the exponential backoff between attempts
+const maxRetryDelay = 10 * time.Minute
+
+type replyKey struct{}
+
+// Reply tells the outbox which conversation message a send belongs to and
+// which device's rate limit it counts against; the gateway always sends
+// from its configured account. Streamed marks the parts of a streamed
+// reply, which are not numbered.
+type Reply struct {
+	MessageID string
+	Device    string
+	Streamed  bool
+}
+
+// WithReply attaches reply details to ctx for the sends made with it
+func WithReply(ctx context.Context, reply Reply) context.Context {
+	return context.WithValue(ctx, replyKey{}, reply)
+}
+
+// Streamed marks the sends made with ctx as parts of a streamed reply
+func Streamed(ctx context.Context) context.Context {
+	reply := replyFrom(ctx)
+	reply.Streamed = true
+	return WithReply(ctx, reply)
+}
+
+func replyFrom(ctx context.Context) Reply {
+	reply, _ := ctx.Value(replyKey{}).(Reply)
+	return reply
+}
+
+// Service stores outgoing messages and delivers them in the background
+// through a gateway, rate limited per device and retried with backoff. It
+// implements messenger.Sender, so it can stand in for the gateway.
+type Service struct {
+	cfg     config.OutboxConfig
+	id      string
+	gateway messenger.Messenger
+	format  *format.Formatter
+	db      *database.DB
+	logger  *logrus.Logger
+
+	wake    chan struct{}
+	ctx     context.Context
+	stop    context.CancelFunc
+	stopped chan struct{}
+	wg      sync.WaitGroup
+	busy    atomic.Int64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	sent    atomic.Int64
+	retried atomic.Int64
+	failed  atomic.Int64
+}
+
+func New(cfg config.OutboxConfig, gateway messenger.Messenger, formatter *format.Formatter, db *database.DB, logger *logrus.Logger) *Service {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = 2 * time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = 1
+	}
+
+	hostname, _ := os.Hostname()
+	return &Service{
+		cfg:     cfg,
+		id:      fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8]),
+		gateway: gateway,
+		format:  formatter,
+		db:      db,
+		logger:  logger,
+		wake:    make(chan struct{}, 1),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// SendText implements messenger.Sender. The text is formatted for
+// WhatsApp and long replies are queued as numbered parts, sent in order. It
+// returns the outbox ID of the first part; the gateway ID is recorded once
+// the message is delivered.
+func (s *Service) SendText(ctx context.Context, to, text string) (string, error) {
+	parts := s.format.Text(text)
+	if replyFrom(ctx).Streamed {
+		parts = s.format.Stream(text)
+	}
+	messages := make([]models.OutboundMessage, len(parts))
+	for i, part := range parts {
+		messages[i] = models.OutboundMessage{
+			RecipientJID: to,
+			Kind:         kindText,
+			Text:         part,
+		}
+	}
+	return s.enqueue(ctx, messages)
+}
+
+// SendMedia implements messenger.Sender like SendText. Captions are
+// formatted but not split.
+func (s *Service) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	return s.enqueue(ctx, []models.OutboundMessage{{
+		RecipientJID: to,
+		Kind:         string(media.Type),
+		Text:         s.format.Caption(media.Caption),
+		MediaURL:     media.URL,
+		Filename:     media.Filename,
+		MimeType:     media.MimeType,
+	}})
+}
+
+func (s *Service) enqueue(ctx context.Context, messages []models.OutboundMessage) (string, error) {
+	reply := replyFrom(ctx)
+	now := time.Now()
+	for i := range messages {
+		messages[i].Gateway = s.gateway.Name()
+		messages[i].Device = reply.Device
+		messages[i].MessageID = reply.MessageID
+		messages[i].Status = models.OutboxPending
+		messages[i].AvailableAt = now
+		// Distinct creation times keep the parts in order when claimed
+		messages[i].CreatedAt = now.Add(time.Duration(i) * time.Microsecond)
+	}
+
+	if err := s.db.CreateOutboundMessages(messages); err != nil {
+		return "", fmt.Errorf("failed to queue outgoing message: %w", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return messages[0].ID.String(), nil
+}
+
+// Start launches the sender workers. Messages left over from a previous
+// run are sent as soon as their visibility timeout expires.
+func (s *Service) Start() {
+	s.ctx, s.stop = context.WithCancel(context.Background())
+	s.stopped = make(chan struct{})
+
+	jobs := make(chan []models.OutboundMessage)
+	for i := 0; i < s.cfg.Workers; i++ {
+		s.wg.Add(1)
+		go s.work(jobs)
+	}
+
+	go func() {
+		defer close(s.stopped)
+		defer close(jobs)
+		s.dispatch(jobs)
+	}()
+
+	s.logger.WithFields(logrus.Fields{
+		"worker_id":       s.id,
+		"workers":         s.cfg.Workers,
+		"rate_per_minute": s.cfg.RatePerMinute,
+	}).Info("Outbox started")
+}
+
+// Shutdown stops claiming messages and waits for in-flight sends until ctx
+// is done. Messages waiting for the rate limit are released and sent after
+// the next start.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if s.stop == nil {
+		return nil
+	}
+	s.stop()
+	<-s.stopped
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Info("Outbox drained")
+		return nil
+	case <-ctx.Done():
+		s.logger.WithField("in_flight", s.busy.Load()).Warn("Outbox drain timed out")
+		return ctx.Err()
+	}
+}
+
+// dispatch claims the messages of as many recipients as there are idle
+// workers, then waits for the next poll or a new message
+func (s *Service) dispatch(jobs chan<- []models.OutboundMessage) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		idle := s.cfg.Workers - int(s.busy.Load())
+		if idle > 0 {
+			claimed, err := s.db.ClaimOutboundMessages(s.id, idle, s.cfg.VisibilityTimeout, time.Now())
+			if err != nil {
+				s.logger.WithError(err).Warn("Failed to claim outgoing messages")
+			}
+			batches := byRecipient(claimed)
+			for _, batch := range batches {
+				s.busy.Add(1)
+				jobs <- batch
+			}
+			if len(batches) == idle && s.ctx.Err() == nil {
+				continue
+			}
+		}
+
+		if s.cfg.Retention > 0 && time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+			if n, err := s.db.DeleteSentOutboundMessages(lastCleanup.Add(-s.cfg.Retention)); err != nil {
+				s.logger.WithError(err).Warn("Failed to clean up sent messages")
+			} else if n > 0 {
+				s.logger.WithField("deleted", n).Debug("Cleaned up sent messages")
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// byRecipient splits claimed messages into one batch per recipient,
+// keeping the order in which they were claimed
+func byRecipient(claimed []models.OutboundMessage) [][]models.OutboundMessage {
+	var batches [][]models.OutboundMessage
+	index := make(map[string]int)
+	for _, message := range claimed {
+		i, ok := index[message.RecipientJID]
+		if !ok {
+			i = len(batches)
+			index[message.RecipientJID] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], message)
+	}
+	return batches
+}
+
+func (s *Service) work(jobs <-chan []models.OutboundMessage) {
+	defer s.wg.Done()
+	for batch := range jobs {
+		s.process(batch)
+		s.busy.Add(-1)
+	}
+}
+
+// process sends one recipient's messages in order. When a send fails and
+// will be retried, or the outbox is stopping, the rest are released so
+// they are not delivered before it. Waiting for the rate limit may outlast
+// the visibility timeout, so the claim is renewed before every send; once
+// another worker has claimed the messages, they are left to it.
+func (s *Service) process(batch []models.OutboundMessage) {
+	for i := range batch {
+		message := &batch[i]
+		logger := s.logger.WithFields(logrus.Fields{
+			"outbox_id": message.ID,
+			"recipient": message.RecipientJID,
+			"attempt":   message.Attempts,
+		})
+
+		if err := s.wait(s.ctx, s.device(message)); err != nil {
+			s.release(batch[i:], time.Now())
+			return
+		}
+		if !s.renew(batch[i:]) {
+			logger.Warn("Outgoing messages were claimed by another worker, leaving them to it")
+			return
+		}
+
+		providerID, err := s.send(message)
+		now := time.Now()
+		if err == nil {
+			s.sent.Add(1)
+			if err := s.db.MarkOutboundSent(message, providerID, now); err != nil {
+				logger.WithError(err).Error("Failed to mark outgoing message sent")
+			}
+			continue
+		}
+
+		if message.Attempts >= s.cfg.MaxAttempts || errors.Is(err, messenger.ErrPermanent) {
+			logger.WithError(err).Error("Giving up on outgoing message")
+			s.failed.Add(1)
+			if err := s.db.FailOutboundMessage(message.ID, s.id, err.Error()); err != nil {
+				logger.WithError(err).Error("Failed to mark outgoing message failed")
+			}
+			continue
+		}
+
+		delay := s.backoff(message.Attempts)
+		logger.WithError(err).WithField("retry_in", delay).Warn("Failed to send message, retrying")
+		s.retried.Add(1)
+		if err := s.db.RetryOutboundMessage(message.ID, s.id, err.Error(), now.Add(delay)); err != nil {
+			logger.WithError(err).Error("Failed to reschedule outgoing message")
+		}
+		s.release(batch[i+1:], now.Add(delay))
+		return
+	}
+}
+
+// renew extends the claim on messages by the visibility timeout and
+// reports whether this worker still holds all of them
+func (s *Service) renew(messages []models.OutboundMessage) bool {
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+	held, err := s.db.ExtendOutboundLease(ids, s.id, time.Now().Add(s.cfg.VisibilityTimeout))
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to renew claim on outgoing messages")
+		return false
+	}
+	return held == int64(len(ids))
+}
+
+// send delivers a message through the gateway. It does not use the outbox
+// context, so a send in progress finishes during shutdown instead of being
+// repeated after the restart.
+func (s *Service) send(message *models.OutboundMessage) (string, error) {
+	ctx := context.Background()
+	if message.Kind == kindText {
+		return s.gateway.SendText(ctx, message.RecipientJID, message.Text)
+	}
+	return s.gateway.SendMedia(ctx, message.RecipientJID, messenger.Media{
+		Type:     messenger.MediaType(message.Kind),
+		URL:      message.MediaURL,
+		Caption:  message.Text,
+		Filename: message.Filename,
+		MimeType: message.MimeType,
+	})
+}
+
+func (s *Service) release(messages []models.OutboundMessage, availableAt time.Time) {
+	if len(messages) == 0 {
+		return
+	}
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+	if err := s.db.ReleaseOutboundMessages(ids, s.id, availableAt); err != nil {
+		s.logger.WithError(err).Error("Failed to release outgoing messages")
+	}
+}
+
+// device names the rate limit bucket a message counts against
+func (s *Service) device(message *models.OutboundMessage) string {
+	if message.Device != "" {
+		return message.Device
+	}
+	return message.Gateway
+}
+
+// backoff doubles the retry delay with every attempt
+func (s *Service) backoff(attempts int) time.Duration {
+	delay := s.cfg.RetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// bucket is a token bucket holding up to Burst sends
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait blocks until device may send another message. Without a rate limit
+// it returns immediately. Buckets live in memory, so the limit holds per
+// replica: N replicas together send up to N times the rate.
+func (s *Service) wait(ctx context.Context, device string) error {
+	if s.cfg.RatePerMinute <= 0 {
+		return nil
+	}
+	perSecond := s.cfg.RatePerMinute / 60
+
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		b, ok := s.buckets[device]
+		if !ok {
+			b = &bucket{tokens: float64(s.cfg.Burst), last: now}
+			s.buckets[device] = b
+		}
+		b.tokens += now.Sub(b.last).Seconds() * perSecond
+		if b.tokens > float64(s.cfg.Burst) {
+			b.tokens = float64(s.cfg.Burst)
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			s.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Stats summarizes the outbox
+type Stats struct {
+	Workers  int              `json:"workers"`
+	Busy     int64            `json:"busy"`
+	Sent     int64            `json:"sent"`
+	Retried  int64            `json:"retried"`
+	Failed   int64            `json:"failed"`
+	ByStatus map[string]int64 `json:"by_status"`
+}
+
+// Stats reports deliveries since startup and the messages in each state
+func (s *Service) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+
+	stats := Stats{
+		Workers: s.cfg.Workers,
+		Busy:    s.busy.Load(),
+		Sent:    s.sent.Load(),
+		Retried: s.retried.Load(),
+		Failed:  s.failed.Load(),
+	}
+	counts, err := s.db.CountOutboundMessages()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to count outgoing messages")
+	}
+	stats.ByStatus = counts
+	return stats
+}
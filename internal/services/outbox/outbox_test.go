@@ -0,0 +1,194 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeGateway records sent texts and fails sends with the queued errors
+type fakeGateway struct {
+	errs []error
+	sent []string
+}
+
+func (g *fakeGateway) Name() string { return "fake" }
+
+func (g *fakeGateway) SendText(ctx context.Context, to, text string) (string, error) {
+	if len(g.errs) > 0 {
+		err := g.errs[0]
+		g.errs = g.errs[1:]
+		if err != nil {
+			return "", err
+		}
+	}
+	g.sent = append(g.sent, text)
+	return fmt.Sprintf("provider-%d", len(g.sent)), nil
+}
+
+func (g *fakeGateway) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	return g.SendText(ctx, to, media.Caption)
+}
+
+func (g *fakeGateway) ParseWebhook(r *http.Request) ([]messenger.Inbound, error) {
+	return nil, nil
+}
+
+func newTestService(t *testing.T, gateway *fakeGateway) (*Service, *database.DB) {
+	t.Helper()
+	db, err := database.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := New(config.OutboxConfig{
+		MaxAttempts:       3,
+		RetryDelay:        time.Minute,
+		VisibilityTimeout: time.Minute,
+	}, gateway, nil, db, logger)
+	s.ctx, s.stop = context.WithCancel(context.Background())
+	t.Cleanup(s.stop)
+	return s, db
+}
+
+// queue stores texts as the parts of one reply and claims them
+func queue(t *testing.T, s *Service, texts ...string) []models.OutboundMessage {
+	t.Helper()
+	messages := make([]models.OutboundMessage, len(texts))
+	for i, text := range texts {
+		messages[i] = models.OutboundMessage{RecipientJID: "628123@s.whatsapp.net", Kind: kindText, Text: text}
+	}
+	if _, err := s.enqueue(context.Background(), messages); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	claimed, err := s.db.ClaimOutboundMessages(s.id, 1, s.cfg.VisibilityTimeout, time.Now())
+	if err != nil || len(claimed) != len(texts) {
+		t.Fatalf("claimed %d messages (%v), want %d", len(claimed), err, len(texts))
+	}
+	return claimed
+}
+
+func load(t *testing.T, db *database.DB) []models.OutboundMessage {
+	t.Helper()
+	var messages []models.OutboundMessage
+	if err := db.Order("created_at").Find(&messages).Error; err != nil {
+		t.Fatalf("load messages: %v", err)
+	}
+	return messages
+}
+
+func TestProcessRetriesAndHoldsBackLaterParts(t *testing.T) {
+	gateway := &fakeGateway{errs: []error{errors.New("gateway timeout")}}
+	s, db := newTestService(t, gateway)
+
+	start := time.Now()
+	s.process(queue(t, s, "first", "second"))
+
+	messages := load(t, db)
+	if len(gateway.sent) != 0 {
+		t.Fatalf("sent %q, want nothing", gateway.sent)
+	}
+	for _, message := range messages {
+		if message.Status != models.OutboxPending {
+			t.Errorf("%s: status %q, want pending", message.Text, message.Status)
+		}
+		if message.AvailableAt.Before(start.Add(s.cfg.RetryDelay)) {
+			t.Errorf("%s: available at %v, want after the retry delay", message.Text, message.AvailableAt)
+		}
+	}
+	if messages[0].Attempts != 1 || messages[0].LastError != "gateway timeout" {
+		t.Errorf("failed part: attempts %d, error %q", messages[0].Attempts, messages[0].LastError)
+	}
+	// The second part was released without counting an attempt
+	if messages[1].Attempts != 0 {
+		t.Errorf("held back part: attempts %d, want 0", messages[1].Attempts)
+	}
+}
+
+func TestProcessGivesUpOnPermanentErrors(t *testing.T) {
+	gateway := &fakeGateway{errs: []error{fmt.Errorf("invalid token: %w", messenger.ErrPermanent)}}
+	s, db := newTestService(t, gateway)
+
+	s.process(queue(t, s, "first", "second"))
+
+	messages := load(t, db)
+	if messages[0].Status != models.OutboxFailed || messages[0].Attempts != 1 {
+		t.Errorf("first part: status %q after %d attempts, want failed after 1", messages[0].Status, messages[0].Attempts)
+	}
+	if messages[1].Status != models.OutboxSent || messages[1].ProviderID != "provider-1" {
+		t.Errorf("second part: status %q, provider ID %q", messages[1].Status, messages[1].ProviderID)
+	}
+}
+
+func TestProcessReleasesOnShutdown(t *testing.T) {
+	s, db := newTestService(t, &fakeGateway{})
+	claimed := queue(t, s, "first", "second")
+
+	// Stopping interrupts the wait for an empty rate limit bucket
+	s.stop()
+	s.cfg.RatePerMinute, s.cfg.Burst = 1, 1
+	s.buckets["fake"] = &bucket{last: time.Now()}
+	s.process(claimed)
+
+	for _, message := range load(t, db) {
+		if message.Status != models.OutboxPending || message.Attempts != 0 {
+			t.Errorf("%s: status %q, attempts %d", message.Text, message.Status, message.Attempts)
+		}
+	}
+}
+
+func TestProcessLeavesReclaimedMessages(t *testing.T) {
+	gateway := &fakeGateway{}
+	s, db := newTestService(t, gateway)
+	claimed := queue(t, s, "first")
+
+	// The claim expired while waiting and another worker took the message
+	if err := db.Model(&models.OutboundMessage{}).Where("id = ?", claimed[0].ID).Update("locked_by", "other").Error; err != nil {
+		t.Fatal(err)
+	}
+	s.process(claimed)
+
+	if len(gateway.sent) != 0 {
+		t.Errorf("sent %q, want nothing", gateway.sent)
+	}
+	if message := load(t, db)[0]; message.Status != models.OutboxSending || message.LockedBy != "other" {
+		t.Errorf("status %q locked by %q, want sending by other", message.Status, message.LockedBy)
+	}
+
+	if err := db.MarkOutboundSent(&claimed[0], "provider-1", time.Now()); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("MarkOutboundSent = %v, want ErrLeaseLost", err)
+	}
+	if err := db.RetryOutboundMessage(claimed[0].ID, s.id, "boom", time.Now()); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("RetryOutboundMessage = %v, want ErrLeaseLost", err)
+	}
+	if err := db.FailOutboundMessage(claimed[0].ID, s.id, "boom"); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("FailOutboundMessage = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestRenewKeepsClaim(t *testing.T) {
+	s, db := newTestService(t, &fakeGateway{})
+	s.cfg.VisibilityTimeout = time.Millisecond
+	claimed := queue(t, s, "first")
+	time.Sleep(5 * time.Millisecond)
+
+	s.cfg.VisibilityTimeout = time.Minute
+	if !s.renew(claimed) {
+		t.Fatal("renew lost the claim")
+	}
+	if stolen, err := db.ClaimOutboundMessages("other", 1, time.Minute, time.Now()); err != nil || len(stolen) != 0 {
+		t.Errorf("another worker claimed %d messages (%v)", len(stolen), err)
+	}
+}
@@ -0,0 +1,332 @@
+package persona
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPrompt is used when no personas file is configured
+const DefaultPrompt = "You are a helpful WhatsApp AI assistant. You can generate images when requested. Be friendly and helpful."
+
+const defaultName = "default"
+
+type Service struct {
+	path     string
+	location *time.Location
+	logger   *logrus.Logger
+
+	mu       sync.RWMutex
+	personas map[string]*Persona
+	fallback string
+	assign   Assignments
+	// promptDirs are the directories of the prompt files in use, watched
+	// along with the personas file
+	promptDirs []string
+
+	watcher *fsnotify.Watcher
+}
+
+// Persona is a named system prompt template
+type Persona struct {
+	Name     string
+	Prompt   string
+	template *template.Template
+}
+
+// Assignments map devices, groups and users to persona names
+type Assignments struct {
+	Devices map[string]string `yaml:"devices"`
+	Groups  map[string]string `yaml:"groups"`
+	Users   map[string]string `yaml:"users"`
+}
+
+// PromptData holds the variables available to prompt templates
+type PromptData struct {
+	Sender     string
+	SenderName string
+	Group      string
+	Device     string
+	Time       time.Time
+	Language   string
+	Tools      []string
+	// Facts are what the user asked the bot to remember with /remember,
+	// oldest first
+	Facts []string
+}
+
+// Target identifies who a persona is being resolved for
+type Target struct {
+	Device string
+	Group  string
+	User   string
+}
+
+type fileFormat struct {
+	Default     string                `yaml:"default"`
+	Personas    map[string]personaDef `yaml:"personas"`
+	Assignments Assignments           `yaml:"assignments"`
+}
+
+type personaDef struct {
+	Prompt     string `yaml:"prompt"`
+	PromptFile string `yaml:"prompt_file"`
+}
+
+// New creates a persona service. When path is empty or the file does not
+// exist, a single default persona built from defaultPrompt is used.
+func New(path, defaultPrompt, timezone string, logger *logrus.Logger) (*Service, error) {
+	location := time.Local
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+		location = loc
+	}
+
+	if defaultPrompt == "" {
+		defaultPrompt = DefaultPrompt
+	}
+
+	s := &Service{
+		path:     path,
+		location: location,
+		logger:   logger,
+	}
+
+	fallback, err := newPersona(defaultName, defaultPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default prompt: %w", err)
+	}
+	s.personas = map[string]*Persona{defaultName: fallback}
+	s.fallback = defaultName
+
+	if path == "" {
+		return s, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		logger.WithField("path", path).Info("Personas file not found, using default prompt")
+		return s, nil
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Watch reloads the personas file whenever it, a file next to it or a
+// prompt file it uses changes. Reload errors are logged and the previous
+// personas are kept.
+func (s *Service) Watch() error {
+	if s.path == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	// Watch the directory rather than the file so that editors which replace
+	// the file on save keep triggering reloads
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", s.path, err)
+	}
+	s.watcher = watcher
+	s.watchPromptDirs()
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+				if _, err := os.Stat(s.path); err != nil {
+					continue
+				}
+				if err := s.load(); err != nil {
+					s.logger.WithError(err).Error("Failed to reload personas")
+					continue
+				}
+				s.watchPromptDirs()
+				s.logger.WithField("path", s.path).Info("Personas reloaded")
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				s.logger.WithError(err).Warn("Personas watcher error")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchPromptDirs adds the directories of the current prompt files to the
+// watcher; directories already watched are unaffected
+func (s *Service) watchPromptDirs() {
+	s.mu.RLock()
+	dirs := s.promptDirs
+	s.mu.RUnlock()
+
+	for _, dir := range dirs {
+		if err := s.watcher.Add(dir); err != nil {
+			s.logger.WithError(err).WithField("dir", dir).Warn("Failed to watch prompt directory")
+		}
+	}
+}
+
+func (s *Service) Close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	return s.watcher.Close()
+}
+
+func (s *Service) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read personas file: %w", err)
+	}
+
+	var file fileFormat
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse personas file: %w", err)
+	}
+
+	personas := make(map[string]*Persona, len(file.Personas)+1)
+	var promptDirs []string
+	s.mu.RLock()
+	personas[defaultName] = s.personas[defaultName]
+	s.mu.RUnlock()
+
+	for name, def := range file.Personas {
+		prompt := def.Prompt
+		if def.PromptFile != "" {
+			promptPath := def.PromptFile
+			if !filepath.IsAbs(promptPath) {
+				promptPath = filepath.Join(filepath.Dir(s.path), promptPath)
+			}
+			content, err := os.ReadFile(promptPath)
+			if err != nil {
+				return fmt.Errorf("persona %s: failed to read prompt file: %w", name, err)
+			}
+			prompt = string(content)
+			if dir := filepath.Dir(promptPath); dir != filepath.Dir(s.path) {
+				promptDirs = append(promptDirs, dir)
+			}
+		}
+
+		p, err := newPersona(name, prompt)
+		if err != nil {
+			return fmt.Errorf("persona %s: %w", name, err)
+		}
+		personas[name] = p
+	}
+
+	fallback := defaultName
+	if file.Default != "" {
+		if _, ok := personas[file.Default]; !ok {
+			return fmt.Errorf("default persona %q is not defined", file.Default)
+		}
+		fallback = file.Default
+	}
+
+	for _, assigned := range []map[string]string{file.Assignments.Devices, file.Assignments.Groups, file.Assignments.Users} {
+		for key, name := range assigned {
+			if _, ok := personas[name]; !ok {
+				return fmt.Errorf("persona %q assigned to %s is not defined", name, key)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.personas = personas
+	s.fallback = fallback
+	s.assign = file.Assignments
+	s.promptDirs = promptDirs
+	s.mu.Unlock()
+
+	s.logger.WithFields(logrus.Fields{
+		"personas": len(personas),
+		"default":  fallback,
+	}).Info("Personas loaded")
+
+	return nil
+}
+
+func newPersona(name, prompt string) (*Persona, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+	return &Persona{Name: name, Prompt: prompt, template: tmpl}, nil
+}
+
+// Resolve returns the persona for a target. User assignments take precedence
+// over group assignments, which take precedence over device assignments.
+func (s *Service) Resolve(target Target) *Persona {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candidates := []struct {
+		assigned map[string]string
+		key      string
+	}{
+		{s.assign.Users, target.User},
+		{s.assign.Groups, target.Group},
+		{s.assign.Devices, target.Device},
+	}
+	for _, c := range candidates {
+		if c.key == "" {
+			continue
+		}
+		if name, ok := c.assigned[c.key]; ok {
+			if p, ok := s.personas[name]; ok {
+				return p
+			}
+		}
+	}
+
+	return s.personas[s.fallback]
+}
+
+// Get returns a persona by name
+func (s *Service) Get(name string) (*Persona, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.personas[name]
+	return p, ok
+}
+
+// Render executes the persona template. Time is converted to the configured
+// timezone and defaults to now.
+func (s *Service) Render(p *Persona, data PromptData) (string, error) {
+	if data.Time.IsZero() {
+		data.Time = time.Now()
+	}
+	data.Time = data.Time.In(s.location)
+
+	var buf bytes.Buffer
+	if err := p.template.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render persona %s: %w", p.Name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,90 @@
+package persona
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestWatchReloadsPromptFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "personas.yaml")
+	promptPath := filepath.Join(dir, "prompts", "sales.tmpl")
+	if err := os.MkdirAll(filepath.Dir(promptPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("default: sales\npersonas:\n  sales:\n    prompt_file: prompts/sales.tmpl\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(promptPath, []byte("first"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(path, "", "UTC", logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := s.Watch(); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	defer s.Close()
+
+	render := func() string {
+		prompt, err := s.Render(s.Resolve(Target{}), PromptData{})
+		if err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		return prompt
+	}
+	if got := render(); got != "first" {
+		t.Fatalf("prompt = %q, want first", got)
+	}
+
+	if err := os.WriteFile(promptPath, []byte("second"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for render() != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("prompt file change was not picked up")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRenderFacts(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(filepath.Join("..", "..", "..", "configs", "personas.example.yaml"), "", "UTC", logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p, ok := s.Get("assistant")
+	if !ok {
+		t.Fatal("example has no assistant persona")
+	}
+
+	prompt, err := s.Render(p, PromptData{Facts: []string{"I'm vegetarian", "My name is Sari"}})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "The user asked you to remember:\n- I'm vegetarian\n- My name is Sari"
+	if !strings.HasSuffix(prompt, want) {
+		t.Errorf("prompt = %q, want it to end with %q", prompt, want)
+	}
+
+	prompt, err = s.Render(p, PromptData{})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if strings.Contains(prompt, "remember") {
+		t.Errorf("prompt without facts mentions them: %q", prompt)
+	}
+}
@@ -0,0 +1,487 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRetryDelay caps the exponential backoff between attempts
+const maxRetryDelay = 10 * time.Minute
+
+// Handler processes one inbound message. Returning an error schedules a
+// retry, so it should only fail before anything was sent to the user.
+type Handler func(msg messenger.Inbound) error
+
+// Service is a database-backed queue of inbound messages drained by a pool
+// of workers
+type Service struct {
+	cfg    config.QueueConfig
+	id     string
+	db     *database.DB
+	logger *logrus.Logger
+
+	wake    chan struct{}
+	stop    context.CancelFunc
+	stopped chan struct{}
+	wg      sync.WaitGroup
+	busy    atomic.Int64
+
+	processed  atomic.Int64
+	retried    atomic.Int64
+	dead       atomic.Int64
+	coalesced  atomic.Int64
+	duplicates atomic.Int64
+}
+
+func New(cfg config.QueueConfig, db *database.DB, logger *logrus.Logger) *Service {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = 5 * time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+
+	hostname, _ := os.Hostname()
+	return &Service{
+		cfg:    cfg,
+		id:     fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8]),
+		db:     db,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Enqueue persists messages so they survive a crash before processing.
+// The webhook is acknowledged only after this returns. With debouncing on,
+// messages become due only after the debounce window, and a sender with a
+// message not yet due is held back, so a burst is claimed together. No
+// message is held past DebounceMaxWait after the oldest one still pending.
+// Messages already seen within the dedup window are dropped silently.
+func (s *Service) Enqueue(msgs ...messenger.Inbound) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	deadlines := make(map[string]time.Time)
+	// Keys without a provider ID are remembered only for DedupBucket
+	var rows, byContent []models.QueuedMessage
+	for i, msg := range msgs {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to encode message: %w", err)
+		}
+		// Messages are processed in CreatedAt order; keep the order of a
+		// batch even at the microsecond precision of Postgres timestamps
+		created := now.Add(time.Duration(i) * time.Microsecond)
+		available := created.Add(s.cfg.Debounce)
+		if s.cfg.Debounce > 0 && s.cfg.DebounceMaxWait > 0 {
+			deadline, ok := deadlines[msg.Sender]
+			if !ok {
+				deadline = created.Add(s.cfg.DebounceMaxWait)
+				oldest, err := s.db.OldestPendingMessage(msg.Sender)
+				if err != nil {
+					return fmt.Errorf("failed to look up pending messages: %w", err)
+				}
+				if !oldest.IsZero() && oldest.Add(s.cfg.DebounceMaxWait).Before(deadline) {
+					deadline = oldest.Add(s.cfg.DebounceMaxWait)
+				}
+				deadlines[msg.Sender] = deadline
+			}
+			if available.After(deadline) {
+				available = deadline
+			}
+		}
+		key, content := s.dedupKey(msg)
+		row := models.QueuedMessage{
+			Gateway:     msg.Gateway,
+			SenderJID:   msg.Sender,
+			Payload:     string(payload),
+			Status:      models.QueuePending,
+			AvailableAt: available,
+			DedupKey:    key,
+			CreatedAt:   created,
+		}
+		if content {
+			byContent = append(byContent, row)
+		} else {
+			rows = append(rows, row)
+		}
+	}
+
+	duplicates := 0
+	for _, batch := range []struct {
+		rows   []models.QueuedMessage
+		window time.Duration
+	}{{rows, s.cfg.DedupWindow}, {byContent, s.cfg.DedupBucket}} {
+		if len(batch.rows) == 0 {
+			continue
+		}
+		n, err := s.db.EnqueueMessages(batch.rows, now.Add(-batch.window))
+		if err != nil {
+			return fmt.Errorf("failed to enqueue messages: %w", err)
+		}
+		duplicates += n
+	}
+	if duplicates > 0 {
+		s.duplicates.Add(int64(duplicates))
+		s.logger.WithFields(logrus.Fields{
+			"sender":     msgs[0].Sender,
+			"duplicates": duplicates,
+		}).Info("Skipped duplicate inbound messages")
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dedupKey identifies a message across redeliveries: by the provider's
+// message ID when there is one, otherwise by its content and sender. content
+// reports that the key was derived from the content. Such keys are only
+// remembered for DedupBucket after the first copy arrives, so a retry counts
+// as a duplicate however the receipt times fall.
+func (s *Service) dedupKey(msg messenger.Inbound) (key string, content bool) {
+	if s.cfg.DedupWindow <= 0 {
+		return "", false
+	}
+
+	var parts []string
+	if msg.ID != "" {
+		parts = []string{"id", msg.Gateway, msg.Device, msg.ID}
+	} else {
+		content = true
+		parts = []string{
+			"content", msg.Gateway, msg.Device, msg.Sender, msg.Member, string(msg.Type),
+			msg.Message, msg.MediaURL, msg.MediaID, msg.Filename, msg.Location,
+		}
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:]), content
+}
+
+// Start launches the workers. Messages left over from a previous run are
+// picked up as soon as their visibility timeout expires.
+func (s *Service) Start(handle Handler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	s.stopped = make(chan struct{})
+
+	jobs := make(chan []models.QueuedMessage)
+	for i := 0; i < s.cfg.Workers; i++ {
+		s.wg.Add(1)
+		go s.work(jobs, handle)
+	}
+
+	go func() {
+		defer close(s.stopped)
+		defer close(jobs)
+		s.dispatch(ctx, jobs)
+	}()
+
+	s.logger.WithFields(logrus.Fields{
+		"worker_id": s.id,
+		"workers":   s.cfg.Workers,
+		"debounce":  s.cfg.Debounce,
+	}).Info("Message queue started")
+}
+
+// Shutdown stops claiming new messages and waits for in-flight ones until
+// ctx is done. Messages still running then are retried by another worker
+// after their visibility timeout.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if s.stop == nil {
+		return nil
+	}
+	s.stop()
+	<-s.stopped
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Info("Message queue drained")
+		return nil
+	case <-ctx.Done():
+		s.logger.WithField("in_flight", s.busy.Load()).Warn("Message queue drain timed out")
+		return ctx.Err()
+	}
+}
+
+// dispatch claims the messages of as many senders as there are idle
+// workers, then waits for the next poll or a new message. Each worker gets
+// all claimed messages of one sender, so they are answered in order.
+func (s *Service) dispatch(ctx context.Context, jobs chan<- []models.QueuedMessage) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup, lastKeyCleanup time.Time
+	for {
+		idle := s.cfg.Workers - int(s.busy.Load())
+		if idle > 0 {
+			claimed, err := s.db.ClaimQueuedMessages(s.id, idle, s.cfg.VisibilityTimeout, time.Now())
+			if err != nil {
+				s.logger.WithError(err).Warn("Failed to claim queued messages")
+			}
+			batches := bySender(claimed)
+			for _, batch := range batches {
+				s.busy.Add(1)
+				jobs <- batch
+			}
+			if len(batches) == idle && ctx.Err() == nil {
+				continue
+			}
+		}
+
+		if s.cfg.Retention > 0 && time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+			if n, err := s.db.DeleteCompletedQueuedMessages(lastCleanup.Add(-s.cfg.Retention)); err != nil {
+				s.logger.WithError(err).Warn("Failed to clean up queued messages")
+			} else if n > 0 {
+				s.logger.WithField("deleted", n).Debug("Cleaned up processed messages")
+			}
+		}
+		if s.cfg.DedupWindow > 0 && time.Since(lastKeyCleanup) > time.Hour {
+			lastKeyCleanup = time.Now()
+			if _, err := s.db.DeleteInboundKeys(lastKeyCleanup.Add(-s.cfg.DedupWindow)); err != nil {
+				s.logger.WithError(err).Warn("Failed to clean up inbound keys")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// bySender splits claimed messages into one batch per sender, keeping the
+// order in which they were claimed
+func bySender(claimed []models.QueuedMessage) [][]models.QueuedMessage {
+	var batches [][]models.QueuedMessage
+	index := make(map[string]int)
+	for _, row := range claimed {
+		i, ok := index[row.SenderJID]
+		if !ok {
+			i = len(batches)
+			index[row.SenderJID] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], row)
+	}
+	return batches
+}
+
+func (s *Service) work(jobs <-chan []models.QueuedMessage, handle Handler) {
+	defer s.wg.Done()
+	for batch := range jobs {
+		s.process(batch, handle)
+		s.busy.Add(-1)
+	}
+}
+
+// group is one call to the handler and the rows it answers
+type group struct {
+	msg  messenger.Inbound
+	rows []models.QueuedMessage
+}
+
+// process handles one sender's messages in order. When a group fails and
+// will be retried, the groups after it are released too so they are not
+// answered before it.
+func (s *Service) process(batch []models.QueuedMessage, handle Handler) {
+	groups := s.groups(batch)
+	for i, g := range groups {
+		logger := s.logger.WithFields(logrus.Fields{
+			"queue_id": g.rows[0].ID,
+			"sender":   g.rows[0].SenderJID,
+			"attempt":  g.rows[0].Attempts,
+			"messages": len(g.rows),
+		})
+
+		err := run(handle, g.msg)
+		now := time.Now()
+		if err == nil {
+			for _, row := range g.rows {
+				s.processed.Add(1)
+				if err := s.db.CompleteQueuedMessage(row.ID, now); err != nil {
+					logger.WithError(err).Error("Failed to mark queued message done")
+				}
+			}
+			continue
+		}
+
+		if maxAttempts(g.rows) >= s.cfg.MaxAttempts {
+			logger.WithError(err).Error("Queued message failed too often, moving to dead letters")
+			for _, row := range g.rows {
+				s.deadLetter(row, err)
+			}
+			continue
+		}
+
+		delay := s.backoff(maxAttempts(g.rows))
+		retryAt := now.Add(delay)
+		logger.WithError(err).WithField("retry_in", delay).Warn("Queued message failed, retrying")
+		for _, row := range g.rows {
+			s.retried.Add(1)
+			if err := s.db.RetryQueuedMessage(row.ID, err.Error(), retryAt); err != nil {
+				logger.WithError(err).Error("Failed to reschedule queued message")
+			}
+		}
+
+		var rest []uuid.UUID
+		for _, later := range groups[i+1:] {
+			for _, row := range later.rows {
+				rest = append(rest, row.ID)
+			}
+		}
+		if len(rest) > 0 {
+			if err := s.db.ReleaseQueuedMessages(rest, retryAt); err != nil {
+				logger.WithError(err).Error("Failed to release queued messages")
+			}
+		}
+		return
+	}
+}
+
+// groups decodes a sender's messages. With debouncing on, consecutive text
+// messages from the same author are merged so the burst is answered once.
+// Commands are never merged, since they must start the message.
+func (s *Service) groups(batch []models.QueuedMessage) []group {
+	var groups []group
+	for _, row := range batch {
+		var msg messenger.Inbound
+		if err := json.Unmarshal([]byte(row.Payload), &msg); err != nil {
+			s.logger.WithError(err).WithField("queue_id", row.ID).Error("Dropping undecodable queued message")
+			s.deadLetter(row, err)
+			continue
+		}
+
+		if n := len(groups); s.cfg.Debounce > 0 && n > 0 && mergeable(groups[n-1].msg, msg) {
+			last := &groups[n-1]
+			last.msg.Message += "\n" + msg.Message
+			last.msg.ID = msg.ID
+			last.msg.Timestamp = msg.Timestamp
+			last.rows = append(last.rows, row)
+			s.coalesced.Add(1)
+			continue
+		}
+		groups = append(groups, group{msg: msg, rows: []models.QueuedMessage{row}})
+	}
+	return groups
+}
+
+func mergeable(prev, next messenger.Inbound) bool {
+	isText := func(msg messenger.Inbound) bool {
+		return msg.Type == "" || msg.Type == messenger.TypeText
+	}
+	return isText(prev) && isText(next) &&
+		prev.Member == next.Member &&
+		!strings.HasPrefix(strings.TrimSpace(prev.Message), "/") &&
+		!strings.HasPrefix(strings.TrimSpace(next.Message), "/")
+}
+
+func maxAttempts(rows []models.QueuedMessage) int {
+	attempts := 0
+	for _, row := range rows {
+		if row.Attempts > attempts {
+			attempts = row.Attempts
+		}
+	}
+	return attempts
+}
+
+func (s *Service) deadLetter(row models.QueuedMessage, cause error) {
+	s.dead.Add(1)
+	if err := s.db.DeadLetterQueuedMessage(row.ID, cause.Error(), time.Now()); err != nil {
+		s.logger.WithError(err).WithField("queue_id", row.ID).Error("Failed to dead-letter queued message")
+	}
+}
+
+// backoff doubles the retry delay with every attempt
+func (s *Service) backoff(attempts int) time.Duration {
+	delay := s.cfg.RetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// run calls the handler, turning a panic into an error so the message is
+// retried instead of killing the worker
+func run(handle Handler, msg messenger.Inbound) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handle(msg)
+}
+
+// Stats summarizes the queue
+type Stats struct {
+	Workers    int              `json:"workers"`
+	Busy       int64            `json:"busy"`
+	Processed  int64            `json:"processed"`
+	Retried    int64            `json:"retried"`
+	Dead       int64            `json:"dead"`
+	Coalesced  int64            `json:"coalesced"`
+	Duplicates int64            `json:"duplicates"`
+	ByStatus   map[string]int64 `json:"by_status"`
+}
+
+// Stats reports worker activity since startup and the rows in each state
+func (s *Service) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+
+	stats := Stats{
+		Workers:    s.cfg.Workers,
+		Busy:       s.busy.Load(),
+		Processed:  s.processed.Load(),
+		Retried:    s.retried.Load(),
+		Dead:       s.dead.Load(),
+		Coalesced:  s.coalesced.Load(),
+		Duplicates: s.duplicates.Load(),
+	}
+	counts, err := s.db.CountQueuedMessages()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to count queued messages")
+	}
+	stats.ByStatus = counts
+	return stats
+}
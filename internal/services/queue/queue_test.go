@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDebounceMaxWait(t *testing.T) {
+	db, err := database.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := New(config.QueueConfig{Debounce: 3 * time.Second, DebounceMaxWait: 5 * time.Second}, db, logger)
+
+	// A burst that started four seconds ago must be answered within a
+	// second, however recent the newest message is
+	oldest := time.Now().Add(-4 * time.Second)
+	if _, err := db.EnqueueMessages([]models.QueuedMessage{{
+		SenderJID:   "6281",
+		Payload:     "{}",
+		Status:      models.QueuePending,
+		AvailableAt: oldest.Add(3 * time.Second),
+		CreatedAt:   oldest,
+	}}, time.Time{}); err != nil {
+		t.Fatalf("EnqueueMessages: %v", err)
+	}
+
+	if err := s.Enqueue(messenger.Inbound{Sender: "6281", Type: messenger.TypeText, Message: "still typing"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := s.Enqueue(messenger.Inbound{Sender: "6282", Type: messenger.TypeText, Message: "hello"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	var queued []models.QueuedMessage
+	if err := db.Order("created_at").Find(&queued).Error; err != nil {
+		t.Fatalf("load queue: %v", err)
+	}
+	if len(queued) != 3 {
+		t.Fatalf("got %d queued messages, want 3", len(queued))
+	}
+
+	capped := queued[1]
+	if want := oldest.Add(5 * time.Second); !capped.AvailableAt.Equal(want) {
+		t.Errorf("held until %s, want %s", capped.AvailableAt, want)
+	}
+	other := queued[2]
+	if want := other.CreatedAt.Add(3 * time.Second); !other.AvailableAt.Equal(want) {
+		t.Errorf("other sender held until %s, want %s", other.AvailableAt, want)
+	}
+}
+
+func TestContentDedupFollowsFirstCopy(t *testing.T) {
+	db, err := database.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := New(config.QueueConfig{DedupWindow: 24 * time.Hour, DedupBucket: time.Minute}, db, logger)
+
+	msg := messenger.Inbound{Gateway: messenger.GatewayFonnte, Sender: "6281", Type: messenger.TypeText, Message: "hello"}
+	queued := func() int64 {
+		var count int64
+		if err := db.Model(&models.QueuedMessage{}).Count(&count).Error; err != nil {
+			t.Fatalf("count queue: %v", err)
+		}
+		return count
+	}
+
+	// A retry is a duplicate even when its receipt time lands in another
+	// minute than the first copy's
+	first := msg
+	first.Timestamp = time.Now().Truncate(time.Minute).Add(-time.Second)
+	retry := msg
+	retry.Timestamp = first.Timestamp.Add(2 * time.Second)
+	if err := s.Enqueue(first); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := s.Enqueue(retry); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if n := queued(); n != 1 {
+		t.Fatalf("got %d queued messages after a retry, want 1", n)
+	}
+
+	// Once the bucket has passed the same text is a new message
+	if err := db.Model(&models.InboundKey{}).Where("1 = 1").
+		Update("created_at", time.Now().Add(-2*time.Minute)).Error; err != nil {
+		t.Fatalf("age key: %v", err)
+	}
+	if err := s.Enqueue(msg); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if n := queued(); n != 2 {
+		t.Fatalf("got %d queued messages after the bucket, want 2", n)
+	}
+
+	// Provider IDs are remembered for the whole dedup window
+	withID := msg
+	withID.ID = "inbox-1"
+	for i := 0; i < 2; i++ {
+		if err := s.Enqueue(withID); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		if err := db.Model(&models.InboundKey{}).Where("1 = 1").
+			Update("created_at", time.Now().Add(-time.Hour)).Error; err != nil {
+			t.Fatalf("age key: %v", err)
+		}
+	}
+	if n := queued(); n != 3 {
+		t.Fatalf("got %d queued messages after a redelivered ID, want 3", n)
+	}
+}
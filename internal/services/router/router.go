@@ -0,0 +1,214 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"example-tool-call/internal/config"
+	openaiService "example-tool-call/internal/services/openai"
+	"github.com/sirupsen/logrus"
+)
+
+const classifierPrompt = "Classify how demanding the user's WhatsApp message is to answer. " +
+	"Reply with exactly one word from this list and nothing else: %s."
+
+type Router struct {
+	enabled      bool
+	defaultModel string
+	defaultTier  string
+	tiers        map[string]string
+	rules        []rule
+	toolPattern  *regexp.Regexp
+	classifier   config.RouterClassifierConfig
+	openai       *openaiService.Service
+	logger       *logrus.Logger
+}
+
+type rule struct {
+	config.RouteRule
+	pattern *regexp.Regexp
+}
+
+// Request describes the incoming message being routed
+type Request struct {
+	Text     string
+	HasMedia bool
+	// Override is a tier or model chosen by the user for the conversation
+	Override string
+}
+
+// Decision is the model picked for a message and why
+type Decision struct {
+	Tier   string `json:"tier,omitempty"`
+	Model  string `json:"model"`
+	Reason string `json:"reason"`
+}
+
+func New(cfg config.RouterConfig, defaultModel string, openai *openaiService.Service, logger *logrus.Logger) (*Router, error) {
+	r := &Router{
+		enabled:      cfg.Enabled,
+		defaultModel: defaultModel,
+		defaultTier:  cfg.DefaultTier,
+		tiers:        cfg.Tiers,
+		classifier:   cfg.Classifier,
+		openai:       openai,
+		logger:       logger,
+	}
+
+	// Tool keywords also keep tool requests out of the response cache, so
+	// they apply even when routing is off
+	if len(cfg.ToolKeywords) > 0 {
+		quoted := make([]string, len(cfg.ToolKeywords))
+		for i, keyword := range cfg.ToolKeywords {
+			quoted[i] = regexp.QuoteMeta(strings.ToLower(keyword))
+		}
+		r.toolPattern = regexp.MustCompile(`\b(` + strings.Join(quoted, "|") + `)`)
+	}
+
+	if !r.enabled {
+		return r, nil
+	}
+
+	if r.defaultTier != "" {
+		if _, ok := r.tiers[r.defaultTier]; !ok {
+			return nil, fmt.Errorf("default tier %q is not defined", r.defaultTier)
+		}
+	}
+
+	for i, rc := range cfg.Rules {
+		if _, ok := r.tiers[rc.Tier]; !ok {
+			return nil, fmt.Errorf("rule %d: tier %q is not defined", i, rc.Tier)
+		}
+		compiled := rule{RouteRule: rc}
+		if rc.Pattern != "" {
+			pattern, err := regexp.Compile(rc.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern: %w", i, err)
+			}
+			compiled.pattern = pattern
+		}
+		r.rules = append(r.rules, compiled)
+	}
+
+	if r.classifier.Enabled && r.classifier.Model == "" {
+		r.classifier.Model = defaultModel
+	}
+
+	return r, nil
+}
+
+// Route picks a model for a message. A user override wins, then the first
+// matching rule, then the classifier when enabled, then the default tier.
+func (r *Router) Route(ctx context.Context, req Request) Decision {
+	if req.Override != "" {
+		if decision, ok := r.Resolve(req.Override); ok {
+			decision.Reason = "user override"
+			return decision
+		}
+		r.logger.WithField("override", req.Override).Warn("Ignoring unknown model override")
+	}
+
+	if !r.enabled {
+		return Decision{Model: r.defaultModel, Reason: "default"}
+	}
+
+	needsTools := r.NeedsTools(req.Text)
+	length := utf8.RuneCountInString(strings.TrimSpace(req.Text))
+
+	for i, rl := range r.rules {
+		if rl.matches(req, length, needsTools) {
+			return Decision{Tier: rl.Tier, Model: r.tiers[rl.Tier], Reason: fmt.Sprintf("rule %d", i)}
+		}
+	}
+
+	if r.classifier.Enabled {
+		if tier, err := r.classify(ctx, req.Text); err != nil {
+			r.logger.WithError(err).Warn("Routing classifier failed")
+		} else {
+			return Decision{Tier: tier, Model: r.tiers[tier], Reason: "classifier"}
+		}
+	}
+
+	if r.defaultTier != "" {
+		return Decision{Tier: r.defaultTier, Model: r.tiers[r.defaultTier], Reason: "default tier"}
+	}
+	return Decision{Model: r.defaultModel, Reason: "default"}
+}
+
+// Resolve maps a tier name or a configured model name to a decision
+func (r *Router) Resolve(name string) (Decision, bool) {
+	name = strings.TrimSpace(name)
+	if model, ok := r.tiers[strings.ToLower(name)]; ok {
+		return Decision{Tier: strings.ToLower(name), Model: model}, true
+	}
+	for tier, model := range r.tiers {
+		if model == name {
+			return Decision{Tier: tier, Model: model}, true
+		}
+	}
+	if name == r.defaultModel {
+		return Decision{Model: name}, true
+	}
+	return Decision{}, false
+}
+
+// Tiers returns the configured tier names in sorted order
+func (r *Router) Tiers() []string {
+	names := make([]string, 0, len(r.tiers))
+	for name := range r.tiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NeedsTools reports whether the text mentions one of the configured tool
+// keywords
+func (r *Router) NeedsTools(text string) bool {
+	return r.toolPattern != nil && r.toolPattern.MatchString(strings.ToLower(text))
+}
+
+func (rl rule) matches(req Request, length int, needsTools bool) bool {
+	if rl.MinLength > 0 && length < rl.MinLength {
+		return false
+	}
+	if rl.MaxLength > 0 && length > rl.MaxLength {
+		return false
+	}
+	if rl.HasMedia != nil && *rl.HasMedia != req.HasMedia {
+		return false
+	}
+	if rl.NeedsTools != nil && *rl.NeedsTools != needsTools {
+		return false
+	}
+	if rl.pattern != nil && !rl.pattern.MatchString(req.Text) {
+		return false
+	}
+	return true
+}
+
+func (r *Router) classify(ctx context.Context, text string) (string, error) {
+	tiers := r.Tiers()
+	messages := []openaiService.ChatMessage{
+		{Role: "system", Content: fmt.Sprintf(classifierPrompt, strings.Join(tiers, ", "))},
+		{Role: "user", Content: text},
+	}
+
+	resp, err := r.openai.GenerateResponseWithModel(ctx, r.classifier.Model, messages, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("classifier returned no choices")
+	}
+
+	answer := strings.ToLower(strings.Trim(strings.TrimSpace(resp.Choices[0].Message.Content), ".\"'"))
+	if _, ok := r.tiers[answer]; !ok {
+		return "", fmt.Errorf("classifier returned unknown tier %q", answer)
+	}
+	return answer, nil
+}
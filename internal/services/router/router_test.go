@@ -0,0 +1,98 @@
+package router
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"example-tool-call/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestRouter(t *testing.T, cfg config.RouterConfig) *Router {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	r, err := New(cfg, "default-model", nil, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return r
+}
+
+func TestRoute(t *testing.T) {
+	yes := true
+	r := newTestRouter(t, config.RouterConfig{
+		Enabled:      true,
+		DefaultTier:  "standard",
+		Tiers:        map[string]string{"fast": "small-model", "standard": "mid-model", "smart": "large-model"},
+		ToolKeywords: []string{"draw", "gambar"},
+		Rules: []config.RouteRule{
+			{Tier: "smart", HasMedia: &yes},
+			{Tier: "standard", NeedsTools: &yes},
+			{Tier: "smart", Pattern: `(?i)\bexplain\b`},
+			{Tier: "fast", MaxLength: 20},
+		},
+	})
+
+	tests := []struct {
+		name   string
+		req    Request
+		model  string
+		reason string
+	}{
+		{"media", Request{Text: "hi", HasMedia: true}, "large-model", "rule 0"},
+		{"tool keyword", Request{Text: "draw a cat"}, "mid-model", "rule 1"},
+		{"tool keyword in Indonesian", Request{Text: "Gambarkan kucing"}, "mid-model", "rule 1"},
+		{"pattern", Request{Text: "Explain how tides work"}, "large-model", "rule 2"},
+		{"short", Request{Text: "thanks!"}, "small-model", "rule 3"},
+		{"default tier", Request{Text: "what should I cook for dinner tonight?"}, "mid-model", "default tier"},
+		{"override by tier", Request{Text: "thanks!", Override: "Smart"}, "large-model", "user override"},
+		{"override by model", Request{Text: "thanks!", Override: "mid-model"}, "mid-model", "user override"},
+		{"override by default model", Request{Text: "thanks!", Override: "default-model"}, "default-model", "user override"},
+		{"unknown override", Request{Text: "thanks!", Override: "gpt-unknown"}, "small-model", "rule 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.Route(context.Background(), tt.req)
+			if got.Model != tt.model || got.Reason != tt.reason {
+				t.Fatalf("Route(%+v) = %s (%s), want %s (%s)", tt.req, got.Model, got.Reason, tt.model, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRouteDisabled(t *testing.T) {
+	r := newTestRouter(t, config.RouterConfig{
+		Tiers:        map[string]string{"smart": "large-model"},
+		ToolKeywords: []string{"draw"},
+	})
+
+	if got := r.Route(context.Background(), Request{Text: "explain everything"}); got.Model != "default-model" {
+		t.Fatalf("disabled router picked %s", got.Model)
+	}
+	if got := r.Route(context.Background(), Request{Text: "hi", Override: "smart"}); got.Model != "large-model" {
+		t.Fatalf("override ignored when routing is off: %s", got.Model)
+	}
+	// Tool keywords still keep tool requests out of the cache
+	if !r.NeedsTools("please draw a cat") {
+		t.Fatal("tool keyword not matched with routing off")
+	}
+}
+
+func TestNewRejectsUnknownTiers(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tiers := map[string]string{"fast": "small-model"}
+
+	configs := map[string]config.RouterConfig{
+		"default tier": {Enabled: true, Tiers: tiers, DefaultTier: "smart"},
+		"rule tier":    {Enabled: true, Tiers: tiers, Rules: []config.RouteRule{{Tier: "smart"}}},
+		"pattern":      {Enabled: true, Tiers: tiers, Rules: []config.RouteRule{{Tier: "fast", Pattern: "("}}},
+	}
+	for name, cfg := range configs {
+		if _, err := New(cfg, "default-model", nil, logger); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// EscalationTool lets the model hand a conversation over to a human agent.
+// It only validates the request; the handler starts the handoff, since it
+// knows which conversation the call belongs to.
+type EscalationTool struct{}
+
+type EscalationResult struct {
+	Reason string `json:"reason"`
+}
+
+func NewEscalationTool() *EscalationTool {
+	return &EscalationTool{}
+}
+
+func (t *EscalationTool) Name() string {
+	return "escalate_to_human"
+}
+
+func (t *EscalationTool) Description() string {
+	return "Hand the conversation over to a human agent"
+}
+
+func (t *EscalationTool) Execute(ctx context.Context, parameters map[string]interface{}) (interface{}, error) {
+	reason, _ := parameters["reason"].(string)
+	if strings.TrimSpace(reason) == "" {
+		return nil, fmt.Errorf("reason is required")
+	}
+	return EscalationResult{Reason: strings.TrimSpace(reason)}, nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
@@ -104,32 +103,5 @@ func (t *ImageGenerationTool) Execute(ctx context.Context, parameters map[string
 		"revised_prompt": result.RevisedPrompt,
 	}).Info("Image generated successfully")
 
-	// Validate that the image URL is accessible
-	if err := t.validateImageURL(result.ImageURL); err != nil {
-		t.logger.WithError(err).Warn("Generated image URL validation failed")
-	}
-
 	return result, nil
 }
-
-func (t *ImageGenerationTool) validateImageURL(url string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("image URL returned status %d", resp.StatusCode)
-	}
-
-	return nil
-}
\ No newline at end of file
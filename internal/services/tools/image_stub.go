@@ -0,0 +1,35 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// StubImageURL is the image every StubImageGenerationTool call returns
+const StubImageURL = "https://example.com/stub-image.png"
+
+// StubImageGenerationTool stands in for image generation when no real
+// provider should be called, such as with the scripted LLM provider or in
+// evaluations
+type StubImageGenerationTool struct{}
+
+func NewStubImageGenerationTool() *StubImageGenerationTool {
+	return &StubImageGenerationTool{}
+}
+
+func (t *StubImageGenerationTool) Name() string {
+	return "generate_image"
+}
+
+func (t *StubImageGenerationTool) Description() string {
+	return "Generate an image based on text prompt"
+}
+
+func (t *StubImageGenerationTool) Execute(ctx context.Context, parameters map[string]interface{}) (interface{}, error) {
+	prompt, _ := parameters["prompt"].(string)
+	if strings.TrimSpace(prompt) == "" {
+		return nil, fmt.Errorf("prompt is required")
+	}
+	return ImageGenerationResult{ImageURL: StubImageURL, RevisedPrompt: prompt}, nil
+}
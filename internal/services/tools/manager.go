@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"example-tool-call/internal/models"
@@ -107,6 +108,16 @@ func (m *Manager) ExecuteTool(ctx context.Context, messageID, toolName string, p
 	}, nil
 }
 
+// ToolNames returns the names of all registered tools in sorted order
+func (m *Manager) ToolNames() []string {
+	names := make([]string, 0, len(m.tools))
+	for name := range m.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (m *Manager) GetAvailableTools() []openai.Tool {
 	tools := make([]openai.Tool, 0, len(m.tools))
 	for _, tool := range m.tools {
@@ -141,6 +152,25 @@ func (m *Manager) GetAvailableTools() []openai.Tool {
 				},
 			})
 		}
+		if tool.Name() == "escalate_to_human" {
+			tools = append(tools, openai.Tool{
+				Type: openai.ToolTypeFunction,
+				Function: &openai.FunctionDefinition{
+					Name:        "escalate_to_human",
+					Description: "Hand the conversation over to a human agent. Use it for complaints, refunds, sales inquiries or when the user asks for a person.",
+					Parameters: map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"reason": map[string]interface{}{
+								"type":        "string",
+								"description": "Short note for the agent on what the user needs",
+							},
+						},
+						"required": []string{"reason"},
+					},
+				},
+			})
+		}
 	}
 	return tools
 }
\ No newline at end of file
@@ -0,0 +1,186 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/format"
+	"example-tool-call/internal/services/media"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+// maxSpeechInput is the longest text /audio/speech accepts
+const maxSpeechInput = 4096
+
+// speechFormats maps response formats to the MIME type WhatsApp expects.
+// Opus comes back in an Ogg container, which WhatsApp plays as a voice note.
+var speechFormats = map[string]string{
+	"opus": "audio/ogg",
+	"mp3":  "audio/mpeg",
+	"aac":  "audio/aac",
+}
+
+// Service transcribes voice notes and speaks replies through an
+// OpenAI-compatible audio API
+type Service struct {
+	cfg    config.VoiceConfig
+	client *openai.Client
+	media  *media.Service
+	logger *logrus.Logger
+
+	transcribed         atomic.Int64
+	transcriptionFailed atomic.Int64
+	spoken              atomic.Int64
+	speechFailed        atomic.Int64
+}
+
+// Stats reports audio processed since startup
+type Stats struct {
+	Enabled             bool  `json:"enabled"`
+	Reply               bool  `json:"reply"`
+	Transcribed         int64 `json:"transcribed"`
+	TranscriptionFailed int64 `json:"transcription_failed"`
+	Spoken              int64 `json:"spoken"`
+	SpeechFailed        int64 `json:"speech_failed"`
+}
+
+// New creates the voice service. Spoken replies are kept in the media
+// store, so they are only sent when it can serve them.
+func New(cfg config.VoiceConfig, store *media.Service, logger *logrus.Logger) *Service {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	if _, ok := speechFormats[cfg.SpeechFormat]; !ok {
+		cfg.SpeechFormat = "opus"
+	}
+
+	return &Service{
+		cfg:    cfg,
+		client: openai.NewClientWithConfig(clientConfig),
+		media:  store,
+		logger: logger,
+	}
+}
+
+// Enabled reports whether voice notes are transcribed
+func (s *Service) Enabled() bool {
+	return s != nil && s.cfg.Enabled
+}
+
+// Replies reports whether voice notes are answered with audio
+func (s *Service) Replies() bool {
+	return s.Enabled() && s.cfg.Reply && s.media.CanServe()
+}
+
+// Transcribe returns the text spoken in audio. The filename's extension
+// tells the API the audio format.
+func (s *Service) Transcribe(ctx context.Context, audio io.Reader, filename string) (string, error) {
+	// Read one byte past the limit to tell a full file from a cut-off one;
+	// a MaxSize of zero means no limit
+	if s.cfg.MaxSize > 0 {
+		audio = io.LimitReader(audio, s.cfg.MaxSize+1)
+	}
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		s.transcriptionFailed.Add(1)
+		return "", fmt.Errorf("failed to read audio: %w", err)
+	}
+	if s.cfg.MaxSize > 0 && int64(len(data)) > s.cfg.MaxSize {
+		s.transcriptionFailed.Add(1)
+		return "", fmt.Errorf("audio exceeds %d bytes", s.cfg.MaxSize)
+	}
+
+	start := time.Now()
+	resp, err := s.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    s.cfg.TranscriptionModel,
+		FilePath: filename,
+		Reader:   bytes.NewReader(data),
+		Language: s.cfg.Language,
+	})
+	duration := time.Since(start)
+
+	if err != nil {
+		s.transcriptionFailed.Add(1)
+		s.logger.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"duration": duration,
+		}).Error("Transcription request failed")
+		return "", fmt.Errorf("transcription request failed: %w", err)
+	}
+
+	s.transcribed.Add(1)
+	s.logger.WithFields(logrus.Fields{
+		"duration": duration,
+		"bytes":    len(data),
+		"language": resp.Language,
+	}).Debug("Voice message transcribed")
+
+	return strings.TrimSpace(resp.Text), nil
+}
+
+// Synthesize speaks text and stores the audio in the media store. Markdown
+// is stripped first, and text past the API limit is left out since the
+// reply is also sent as text.
+func (s *Service) Synthesize(ctx context.Context, text string) (*models.Media, error) {
+	text = speakable(text)
+	if text == "" {
+		return nil, fmt.Errorf("nothing to speak")
+	}
+	text = format.Split(text, maxSpeechInput)[0]
+
+	start := time.Now()
+	resp, err := s.client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          openai.SpeechModel(s.cfg.SpeechModel),
+		Input:          text,
+		Voice:          openai.SpeechVoice(s.cfg.Voice),
+		ResponseFormat: openai.SpeechResponseFormat(s.cfg.SpeechFormat),
+	})
+	if err != nil {
+		s.speechFailed.Add(1)
+		return nil, fmt.Errorf("speech request failed: %w", err)
+	}
+	defer resp.Close()
+
+	stored, err := s.media.Store(ctx, resp, media.Info{MimeType: speechFormats[s.cfg.SpeechFormat]})
+	if err != nil {
+		s.speechFailed.Add(1)
+		return nil, fmt.Errorf("failed to store speech: %w", err)
+	}
+
+	s.spoken.Add(1)
+	s.logger.WithFields(logrus.Fields{
+		"duration": time.Since(start),
+		"media_id": stored.ID,
+	}).Debug("Reply synthesized")
+	return stored, nil
+}
+
+// formatMarkers are the WhatsApp formatting characters left out of speech
+var formatMarkers = strings.NewReplacer("```", "", "*", "", "_", "", "~", "", "•", "")
+
+// speakable reduces a Markdown reply to plain text for speech
+func speakable(text string) string {
+	return strings.TrimSpace(formatMarkers.Replace(format.ToWhatsApp(text)))
+}
+
+// Stats returns the audio processed since startup
+func (s *Service) Stats() Stats {
+	stats := Stats{Enabled: s.Enabled(), Reply: s.Replies()}
+	if !stats.Enabled {
+		return stats
+	}
+	stats.Transcribed = s.transcribed.Load()
+	stats.TranscriptionFailed = s.transcriptionFailed.Load()
+	stats.Spoken = s.spoken.Load()
+	stats.SpeechFailed = s.speechFailed.Load()
+	return stats
+}
@@ -0,0 +1,343 @@
+package webhookauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers carrying the shared token and the replay protection values
+const (
+	TokenHeader     = "X-Webhook-Token"
+	TimestampHeader = "X-Webhook-Timestamp"
+	NonceHeader     = "X-Webhook-Nonce"
+)
+
+// Reasons a request is rejected, recorded as the security event kind
+const (
+	ReasonInvalidToken     = "webhook_invalid_token"
+	ReasonIPNotAllowed     = "webhook_ip_not_allowed"
+	ReasonInvalidSignature = "webhook_invalid_signature"
+	ReasonStaleTimestamp   = "webhook_stale_timestamp"
+	ReasonReplayedNonce    = "webhook_replayed_nonce"
+	ReasonUnknownDevice    = "webhook_unknown_device"
+)
+
+// maxNonces caps the nonces remembered for replay protection, so a flood of
+// requests cannot grow the map without bound
+const maxNonces = 100000
+
+// maxEventsPerMinute caps the security events stored per rejection reason;
+// further rejections within the minute are only counted
+const maxEventsPerMinute = 20
+
+// Service authenticates webhook requests before they are parsed
+type Service struct {
+	token           string
+	allowed         []*net.IPNet
+	secret          []byte
+	signatureHeader string
+	devices         map[string]bool
+	replayWindow    time.Duration
+	db              *database.DB
+	logger          *logrus.Logger
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+
+	eventsMu sync.Mutex
+	events   map[string]*eventWindow
+
+	accepted atomic.Int64
+	rejected sync.Map // reason -> *atomic.Int64
+}
+
+// eventWindow counts the security events of one reason in the current minute
+type eventWindow struct {
+	start      time.Time
+	stored     int
+	suppressed int
+}
+
+func New(cfg config.WebhookConfig, db *database.DB, logger *logrus.Logger) (*Service, error) {
+	s := &Service{
+		token:           cfg.Token,
+		secret:          []byte(cfg.Secret),
+		signatureHeader: cfg.SignatureHeader,
+		replayWindow:    cfg.ReplayWindow,
+		db:              db,
+		logger:          logger,
+		nonces:          make(map[string]time.Time),
+		events:          make(map[string]*eventWindow),
+	}
+	if s.signatureHeader == "" {
+		s.signatureHeader = "X-Webhook-Signature"
+	}
+
+	for _, entry := range cfg.AllowedIPs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook allowed IP %q: %w", entry, err)
+		}
+		s.allowed = append(s.allowed, network)
+	}
+
+	if len(cfg.Devices) > 0 {
+		s.devices = make(map[string]bool, len(cfg.Devices))
+		for _, device := range cfg.Devices {
+			if device = strings.TrimSpace(device); device != "" {
+				s.devices[device] = true
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Middleware rejects requests that fail the configured checks: source IP,
+// shared token, HMAC signature and timestamp/nonce replay protection. The
+// body is restored for the handler after the signature is checked.
+func (s *Service) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if reason, detail := s.check(c); reason != "" {
+			s.Reject(c.Request, c.ClientIP(), "", reason, detail)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Service) check(c *gin.Context) (string, string) {
+	if len(s.allowed) > 0 && !s.ipAllowed(c.ClientIP()) {
+		return ReasonIPNotAllowed, ""
+	}
+
+	if s.token != "" {
+		token := c.GetHeader(TokenHeader)
+		if token == "" {
+			token = c.Query("token")
+		}
+		if !hmac.Equal([]byte(token), []byte(s.token)) {
+			return ReasonInvalidToken, ""
+		}
+	}
+
+	if len(s.secret) == 0 && s.replayWindow <= 0 {
+		return "", ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return ReasonInvalidSignature, fmt.Sprintf("failed to read body: %v", err)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	timestamp, nonce := c.GetHeader(TimestampHeader), c.GetHeader(NonceHeader)
+	if len(s.secret) > 0 && !s.validSignature(c.GetHeader(s.signatureHeader), timestamp, nonce, body) {
+		return ReasonInvalidSignature, ""
+	}
+
+	if s.replayWindow > 0 {
+		return s.checkReplay(timestamp, nonce, time.Now())
+	}
+	return "", ""
+}
+
+func (s *Service) ipAllowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range s.allowed {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// validSignature checks a hex HMAC-SHA256, optionally prefixed "sha256=".
+// With replay protection on, the timestamp and nonce are signed too, as
+// "<timestamp>.<nonce>.<body>", so they cannot be swapped.
+func (s *Service) validSignature(header, timestamp, nonce string, body []byte) bool {
+	signature, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(header), "sha256="))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	if s.replayWindow > 0 {
+		mac.Write([]byte(timestamp + "." + nonce + "."))
+	}
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// checkReplay requires a Unix timestamp within the replay window and a
+// nonce that has not been seen within it. Expired nonces are swept at most
+// once per window, and new nonces are refused while the store is full.
+func (s *Service) checkReplay(timestamp, nonce string, now time.Time) (string, string) {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ReasonStaleTimestamp, "missing or invalid timestamp"
+	}
+	sent := time.Unix(seconds, 0)
+	if age := now.Sub(sent); age > s.replayWindow || age < -s.replayWindow {
+		return ReasonStaleTimestamp, fmt.Sprintf("timestamp %s is outside the %s window", sent.UTC().Format(time.RFC3339), s.replayWindow)
+	}
+	if nonce == "" {
+		return ReasonReplayedNonce, "missing nonce"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expires, seen := s.nonces[nonce]; seen && !now.After(expires) {
+		return ReasonReplayedNonce, fmt.Sprintf("nonce %q", nonce)
+	}
+	if len(s.nonces) >= maxNonces || now.Sub(s.lastSweep) >= s.replayWindow {
+		for seen, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, seen)
+			}
+		}
+		s.lastSweep = now
+	}
+	if len(s.nonces) >= maxNonces {
+		return ReasonReplayedNonce, "too many recent nonces"
+	}
+	s.nonces[nonce] = now.Add(2 * s.replayWindow)
+	return "", ""
+}
+
+// AllowDevice reports whether a parsed message was received by one of our
+// registered devices. Every device is allowed when none are configured.
+func (s *Service) AllowDevice(r *http.Request, clientIP, device, sender string) bool {
+	if s == nil || s.devices == nil || s.devices[device] {
+		return true
+	}
+	s.Reject(r, clientIP, sender, ReasonUnknownDevice, fmt.Sprintf("device=%q", device))
+	return false
+}
+
+// Accept counts a request that passed every check
+func (s *Service) Accept() {
+	if s == nil {
+		return
+	}
+	s.accepted.Add(1)
+}
+
+// Reject counts a refused request and logs it as a security event. It is
+// also used by handlers for checks done after parsing, such as gateway
+// signatures. Only maxEventsPerMinute events are logged and stored per
+// reason; the next one stored notes how many were skipped.
+func (s *Service) Reject(r *http.Request, clientIP, sender, reason, detail string) {
+	if s == nil {
+		return
+	}
+	counter, _ := s.rejected.LoadOrStore(reason, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(1)
+
+	suppressed, ok := s.sample(reason, time.Now())
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		detail += fmt.Sprintf(" suppressed=%d", suppressed)
+	}
+
+	detail = strings.TrimSpace(fmt.Sprintf("ip=%s path=%s %s", clientIP, r.URL.Path, detail))
+	s.logger.WithFields(logrus.Fields{
+		"kind":   reason,
+		"sender": sender,
+		"detail": detail,
+	}).Warn("Rejected webhook request")
+
+	event := &models.SecurityEvent{
+		Kind:      reason,
+		Source:    "webhook",
+		SenderJID: sender,
+		Detail:    detail,
+	}
+	if err := s.db.SaveSecurityEvent(event); err != nil {
+		s.logger.WithError(err).Error("Failed to save security event")
+	}
+}
+
+// sample reports whether a rejection for reason may be stored, and how
+// many were skipped since the last one that was
+func (s *Service) sample(reason string, now time.Time) (int, bool) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	window, ok := s.events[reason]
+	if !ok {
+		window = &eventWindow{start: now}
+		s.events[reason] = window
+	}
+	if now.Sub(window.start) >= time.Minute {
+		window.start, window.stored = now, 0
+	}
+	if window.stored >= maxEventsPerMinute {
+		window.suppressed++
+		return 0, false
+	}
+	window.stored++
+	suppressed := window.suppressed
+	window.suppressed = 0
+	return suppressed, true
+}
+
+// Stats counts webhook requests since startup
+type Stats struct {
+	Accepted         int64            `json:"accepted"`
+	Rejected         int64            `json:"rejected"`
+	RejectedByReason map[string]int64 `json:"rejected_by_reason"`
+}
+
+// Stats reports accepted and rejected requests since startup
+func (s *Service) Stats() Stats {
+	stats := Stats{RejectedByReason: map[string]int64{}}
+	if s == nil {
+		return stats
+	}
+
+	stats.Accepted = s.accepted.Load()
+	s.rejected.Range(func(key, value interface{}) bool {
+		n := value.(*atomic.Int64).Load()
+		stats.RejectedByReason[key.(string)] = n
+		stats.Rejected += n
+		return true
+	})
+	return stats
+}
@@ -0,0 +1,77 @@
+package webhookauth
+
+import (
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestService(t *testing.T, cfg config.WebhookConfig) (*Service, *database.DB) {
+	t.Helper()
+	db, err := database.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := New(cfg, db, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s, db
+}
+
+func TestRejectSamplesSecurityEvents(t *testing.T) {
+	s, db := newTestService(t, config.WebhookConfig{Token: "secret"})
+	r := httptest.NewRequest("POST", "/webhook/fonnte", nil)
+
+	for i := 0; i < maxEventsPerMinute+5; i++ {
+		s.Reject(r, "203.0.113.1", "", ReasonInvalidToken, "")
+	}
+	if got := s.Stats().Rejected; got != maxEventsPerMinute+5 {
+		t.Errorf("counted %d rejections, want %d", got, maxEventsPerMinute+5)
+	}
+
+	var stored int64
+	db.Model(&models.SecurityEvent{}).Count(&stored)
+	if stored != maxEventsPerMinute {
+		t.Fatalf("stored %d events, want %d", stored, maxEventsPerMinute)
+	}
+
+	// The first event of the next minute reports what was skipped
+	if suppressed, ok := s.sample(ReasonInvalidToken, time.Now().Add(time.Minute)); !ok || suppressed != 5 {
+		t.Errorf("got suppressed=%d ok=%v, want 5 true", suppressed, ok)
+	}
+}
+
+func TestCheckReplay(t *testing.T) {
+	s, _ := newTestService(t, config.WebhookConfig{Secret: "secret", ReplayWindow: time.Minute})
+	now := time.Now()
+	ts := func(at time.Time) string { return strconv.FormatInt(at.Unix(), 10) }
+	if reason, _ := s.checkReplay(ts(now), "a", now); reason != "" {
+		t.Fatalf("fresh request rejected: %s", reason)
+	}
+	if reason, _ := s.checkReplay(ts(now), "a", now); reason != ReasonReplayedNonce {
+		t.Errorf("replayed nonce got %q", reason)
+	}
+	if reason, _ := s.checkReplay(ts(now.Add(-2*time.Minute)), "b", now); reason != ReasonStaleTimestamp {
+		t.Errorf("stale timestamp got %q", reason)
+	}
+
+	// Expired nonces are swept, so the store does not grow without bound
+	later := now.Add(3 * time.Minute)
+	if reason, _ := s.checkReplay(ts(later), "c", later); reason != "" {
+		t.Fatalf("fresh request rejected: %s", reason)
+	}
+	if len(s.nonces) != 1 {
+		t.Errorf("kept %d nonces, want 1", len(s.nonces))
+	}
+}
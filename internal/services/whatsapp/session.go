@@ -0,0 +1,63 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"example-tool-call/internal/models"
+	"example-tool-call/internal/services/database"
+)
+
+// storeFile is the name of whatsmeow's device store under the session path
+const storeFile = "whatsmeow.db"
+
+// sessionStore keeps whatsmeow's device store in models.Session so a
+// deployment can be recreated without pairing the phone again. whatsmeow
+// works on a SQLite file under WHATSAPP_SESSION_PATH; the file is restored
+// from the database on start and saved back after pairing and on shutdown.
+type sessionStore struct {
+	db   *database.DB
+	path string
+}
+
+func newSessionStore(db *database.DB, sessionPath string) *sessionStore {
+	return &sessionStore{db: db, path: filepath.Join(sessionPath, storeFile)}
+}
+
+// restore writes the last saved device store to disk unless a store file
+// already exists
+func (s *sessionStore) restore() (bool, error) {
+	if _, err := os.Stat(s.path); err == nil {
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	session, err := s.db.GetLatestSession()
+	if err != nil {
+		return false, fmt.Errorf("failed to load session: %w", err)
+	}
+	if session == nil || len(session.Data) == 0 {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(s.path, session.Data, 0600); err != nil {
+		return false, fmt.Errorf("failed to restore session: %w", err)
+	}
+	return true, nil
+}
+
+// save stores a snapshot of the device store for the paired device JID
+func (s *sessionStore) save(jid string, data []byte) error {
+	session, err := s.db.GetSession(jid)
+	if err != nil {
+		session = &models.Session{JID: jid}
+	}
+	session.Data = data
+	return s.db.SaveSession(session)
+}
@@ -0,0 +1,44 @@
+//go:build !whatsmeow
+
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	"github.com/sirupsen/logrus"
+)
+
+var errNotCompiled = errors.New("whatsmeow gateway is not compiled in, rebuild with -tags whatsmeow")
+
+// Service is a placeholder used when the binary is built without the
+// whatsmeow tag
+type Service struct{}
+
+func New(cfg config.WhatsAppConfig, db *database.DB, logger *logrus.Logger) (*Service, error) {
+	return nil, errNotCompiled
+}
+
+func (s *Service) Name() string {
+	return messenger.GatewayWhatsmeow
+}
+
+func (s *Service) SendText(ctx context.Context, to, text string) (string, error) {
+	return "", errNotCompiled
+}
+
+func (s *Service) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	return "", errNotCompiled
+}
+
+func (s *Service) ParseWebhook(r *http.Request) ([]messenger.Inbound, error) {
+	return nil, messenger.ErrNoWebhook
+}
+
+func (s *Service) Listen(ctx context.Context, handle func(messenger.Inbound)) error {
+	return errNotCompiled
+}
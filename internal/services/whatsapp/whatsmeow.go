@@ -0,0 +1,420 @@
+//go:build whatsmeow
+
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"example-tool-call/internal/config"
+	"example-tool-call/internal/services/database"
+	"example-tool-call/internal/services/messenger"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Service connects to WhatsApp directly as a linked device
+type Service struct {
+	client  *whatsmeow.Client
+	session *sessionStore
+	http    *http.Client
+	logger  *logrus.Logger
+
+	mu     sync.Mutex
+	handle func(messenger.Inbound)
+	status func(messenger.Status)
+}
+
+// New opens the device store, restoring it from the database first when the
+// session path is empty
+func New(cfg config.WhatsAppConfig, db *database.DB, logger *logrus.Logger) (*Service, error) {
+	session := newSessionStore(db, cfg.SessionPath)
+	restored, err := session.restore()
+	if err != nil {
+		return nil, err
+	}
+	if restored {
+		logger.Info("Restored WhatsApp session from database")
+	}
+
+	log := logAdapter{entry: logger.WithField("component", "whatsmeow")}
+	container, err := sqlstore.New(context.Background(), "sqlite3", storeAddress(session.path), log.Sub("Database"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open whatsmeow store: %w", err)
+	}
+
+	device, err := container.GetFirstDevice(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load whatsmeow device: %w", err)
+	}
+
+	s := &Service{
+		client:  whatsmeow.NewClient(device, log.Sub("Client")),
+		session: session,
+		http:    &http.Client{Timeout: 60 * time.Second},
+		logger:  logger,
+	}
+	s.client.AddEventHandler(s.onEvent)
+	return s, nil
+}
+
+// Name implements messenger.Messenger
+func (s *Service) Name() string {
+	return messenger.GatewayWhatsmeow
+}
+
+// SendText implements messenger.Messenger
+func (s *Service) SendText(ctx context.Context, to, text string) (string, error) {
+	jid, err := parseJID(to)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.client.SendMessage(ctx, jid, &waE2E.Message{Conversation: proto.String(text)})
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	return resp.ID, nil
+}
+
+// SendMedia implements messenger.Messenger. The media is downloaded from
+// its URL and uploaded to WhatsApp's media servers.
+func (s *Service) SendMedia(ctx context.Context, to string, media messenger.Media) (string, error) {
+	jid, err := parseJID(to)
+	if err != nil {
+		return "", err
+	}
+
+	data, mimeType, err := s.download(ctx, media.URL)
+	if err != nil {
+		return "", err
+	}
+	if media.MimeType != "" {
+		mimeType = media.MimeType
+	}
+
+	var mediaType whatsmeow.MediaType
+	switch media.Type {
+	case messenger.MediaImage:
+		mediaType = whatsmeow.MediaImage
+	case messenger.MediaAudio:
+		mediaType = whatsmeow.MediaAudio
+	case messenger.MediaVideo:
+		mediaType = whatsmeow.MediaVideo
+	case messenger.MediaDocument:
+		mediaType = whatsmeow.MediaDocument
+	default:
+		return "", fmt.Errorf("unsupported media type %q", media.Type)
+	}
+
+	uploaded, err := s.client.Upload(ctx, data, mediaType)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload media: %w", err)
+	}
+
+	msg := &waE2E.Message{}
+	switch media.Type {
+	case messenger.MediaImage:
+		msg.ImageMessage = &waE2E.ImageMessage{
+			Caption:       proto.String(media.Caption),
+			Mimetype:      proto.String(mimeType),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}
+	case messenger.MediaAudio:
+		msg.AudioMessage = &waE2E.AudioMessage{
+			Mimetype:      proto.String(mimeType),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}
+	case messenger.MediaVideo:
+		msg.VideoMessage = &waE2E.VideoMessage{
+			Caption:       proto.String(media.Caption),
+			Mimetype:      proto.String(mimeType),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}
+	case messenger.MediaDocument:
+		msg.DocumentMessage = &waE2E.DocumentMessage{
+			Caption:       proto.String(media.Caption),
+			FileName:      proto.String(media.Filename),
+			Mimetype:      proto.String(mimeType),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}
+	}
+
+	resp, err := s.client.SendMessage(ctx, jid, msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to send media: %w", err)
+	}
+	return resp.ID, nil
+}
+
+func (s *Service) download(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download media: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download media: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read media: %w", err)
+	}
+	return data, http.DetectContentType(data), nil
+}
+
+// ParseWebhook implements messenger.Messenger. whatsmeow receives messages
+// over its own connection.
+func (s *Service) ParseWebhook(r *http.Request) ([]messenger.Inbound, error) {
+	return nil, messenger.ErrNoWebhook
+}
+
+// Listen implements messenger.Listener. An unpaired device logs a QR code
+// to scan from WhatsApp's "Linked devices" screen.
+func (s *Service) Listen(ctx context.Context, handle func(messenger.Inbound)) error {
+	s.mu.Lock()
+	s.handle = handle
+	s.mu.Unlock()
+
+	if s.client.Store.ID == nil {
+		qr, err := s.client.GetQRChannel(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get QR channel: %w", err)
+		}
+		go func() {
+			for item := range qr {
+				if item.Event == "code" {
+					s.logger.WithField("qr", item.Code).Info("Scan this QR code with WhatsApp to link the bot")
+				} else {
+					s.logger.WithField("event", item.Event).Info("WhatsApp pairing")
+				}
+			}
+		}()
+	}
+
+	if err := s.client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to WhatsApp: %w", err)
+	}
+
+	<-ctx.Done()
+	s.client.Disconnect()
+	s.saveSession()
+	return nil
+}
+
+// OnStatus implements messenger.StatusNotifier with the delivery and read
+// receipts WhatsApp sends for our messages
+func (s *Service) OnStatus(handle func(messenger.Status)) {
+	s.mu.Lock()
+	s.status = handle
+	s.mu.Unlock()
+}
+
+func (s *Service) onEvent(evt interface{}) {
+	switch e := evt.(type) {
+	case *events.PairSuccess:
+		s.logger.WithField("jid", e.ID.String()).Info("WhatsApp device linked")
+		s.saveSession()
+	case *events.Connected:
+		s.logger.Info("Connected to WhatsApp")
+	case *events.LoggedOut:
+		s.logger.WithField("reason", e.Reason.String()).Warn("WhatsApp device logged out")
+	case *events.Message:
+		if e.Info.IsFromMe {
+			return
+		}
+		s.mu.Lock()
+		handle := s.handle
+		s.mu.Unlock()
+		// Reactions and protocol messages such as revokes carry no type
+		if inbound := s.toInbound(e); handle != nil && inbound.Type != "" {
+			go handle(inbound)
+		}
+	case *events.Receipt:
+		s.mu.Lock()
+		handle := s.status
+		s.mu.Unlock()
+		if handle == nil || e.IsFromMe {
+			return
+		}
+		var state messenger.DeliveryState
+		switch e.Type {
+		case types.ReceiptTypeDelivered:
+			state = messenger.StateDelivered
+		case types.ReceiptTypeRead, types.ReceiptTypePlayed:
+			state = messenger.StateRead
+		case types.ReceiptTypeServerError:
+			state = messenger.StateFailed
+		default:
+			return
+		}
+		for _, id := range e.MessageIDs {
+			go handle(messenger.Status{
+				ProviderID: id,
+				State:      state,
+				Recipient:  e.Chat.User,
+				Timestamp:  e.Timestamp,
+			})
+		}
+	}
+}
+
+func (s *Service) toInbound(e *events.Message) messenger.Inbound {
+	msg := e.Message
+	inbound := messenger.Inbound{
+		ID:        e.Info.ID,
+		Type:      messenger.TypeText,
+		Gateway:   messenger.GatewayWhatsmeow,
+		Sender:    e.Info.Sender.User,
+		Name:      e.Info.PushName,
+		Timestamp: e.Info.Timestamp,
+	}
+	if s.client.Store.ID != nil {
+		inbound.Device = s.client.Store.ID.User
+	}
+	if e.Info.IsGroup {
+		inbound.Sender = e.Info.Chat.String()
+		inbound.Member = e.Info.Sender.User
+	}
+
+	switch {
+	case msg.GetConversation() != "":
+		inbound.Message = msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		inbound.Message = msg.GetExtendedTextMessage().GetText()
+		if info := msg.GetExtendedTextMessage().GetContextInfo(); info != nil {
+			inbound.QuotedID = info.GetStanzaID()
+			if participant, err := types.ParseJID(info.GetParticipant()); err == nil {
+				inbound.QuotedSender = participant.User
+			}
+			inbound.Mentions = info.GetMentionedJID()
+		}
+	case msg.GetImageMessage() != nil:
+		inbound.Type = messenger.TypeImage
+		inbound.Message = msg.GetImageMessage().GetCaption()
+		inbound.MediaID = msg.GetImageMessage().GetDirectPath()
+	case msg.GetVideoMessage() != nil:
+		inbound.Type = messenger.TypeVideo
+		inbound.Message = msg.GetVideoMessage().GetCaption()
+		inbound.MediaID = msg.GetVideoMessage().GetDirectPath()
+	case msg.GetDocumentMessage() != nil:
+		inbound.Type = messenger.TypeDocument
+		inbound.Message = msg.GetDocumentMessage().GetCaption()
+		inbound.MediaID = msg.GetDocumentMessage().GetDirectPath()
+		inbound.Filename = msg.GetDocumentMessage().GetFileName()
+	case msg.GetAudioMessage() != nil:
+		inbound.Type = messenger.TypeAudio
+		inbound.MediaID = msg.GetAudioMessage().GetDirectPath()
+	case msg.GetLocationMessage() != nil:
+		location := msg.GetLocationMessage()
+		inbound.Type = messenger.TypeLocation
+		inbound.Location = fmt.Sprintf("%f,%f", location.GetDegreesLatitude(), location.GetDegreesLongitude())
+	case msg.GetReactionMessage() != nil || msg.GetProtocolMessage() != nil:
+		inbound.Type = ""
+	default:
+		inbound.Type = messenger.TypeUnsupported
+	}
+	return inbound
+}
+
+// saveSession snapshots the device store into models.Session. VACUUM INTO
+// produces a consistent copy while whatsmeow keeps the store open.
+func (s *Service) saveSession() {
+	if s.client.Store.ID == nil {
+		return
+	}
+
+	snapshot := filepath.Join(filepath.Dir(s.session.path), fmt.Sprintf("snapshot-%d.db", time.Now().UnixNano()))
+	defer os.Remove(snapshot)
+
+	err := func() error {
+		db, err := sql.Open("sqlite3", storeAddress(s.session.path))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if _, err := db.Exec("VACUUM INTO ?", snapshot); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(snapshot)
+		if err != nil {
+			return err
+		}
+		return s.session.save(s.client.Store.ID.ToNonAD().String(), data)
+	}()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to save WhatsApp session")
+		return
+	}
+	s.logger.Debug("Saved WhatsApp session")
+}
+
+func storeAddress(path string) string {
+	return "file:" + path + "?_foreign_keys=on"
+}
+
+// parseJID accepts full JIDs and plain phone numbers
+func parseJID(to string) (types.JID, error) {
+	if strings.Contains(to, "@") {
+		jid, err := types.ParseJID(to)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+		return jid, nil
+	}
+	return types.NewJID(strings.TrimPrefix(to, "+"), types.DefaultUserServer), nil
+}
+
+// logAdapter routes whatsmeow's logs through logrus
+type logAdapter struct {
+	entry *logrus.Entry
+}
+
+func (l logAdapter) Errorf(msg string, args ...interface{}) { l.entry.Errorf(msg, args...) }
+func (l logAdapter) Warnf(msg string, args ...interface{})  { l.entry.Warnf(msg, args...) }
+func (l logAdapter) Infof(msg string, args ...interface{})  { l.entry.Debugf(msg, args...) }
+func (l logAdapter) Debugf(msg string, args ...interface{}) { l.entry.Tracef(msg, args...) }
+
+func (l logAdapter) Sub(module string) waLog.Logger {
+	return logAdapter{entry: l.entry.WithField("module", module)}
+}